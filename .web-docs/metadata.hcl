@@ -9,4 +9,14 @@ integration {
     name = "gridscale"
     slug = "gridscale"
   }
+  component {
+    type = "post-processor"
+    name = "gridscale-template-copy"
+    slug = "template-copy"
+  }
+  component {
+    type = "post-processor"
+    name = "gridscale-download"
+    slug = "download"
+  }
 }
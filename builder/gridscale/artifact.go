@@ -3,43 +3,176 @@ package gridscale
 import (
 	"context"
 	"fmt"
-	"github.com/gridscale/gsclient-go/v3"
 	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gridscale/gsclient-go/v3"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+)
+
+const (
+	artifactTypeTemplate = "template"
+	artifactTypeStorage  = "storage"
+	artifactTypeSnapshot = "snapshot"
 )
 
 type Artifact struct {
-	// The name of the template
+	// The kind of object produced by the build: template, storage or
+	// snapshot. An empty type is a template.
+	ArtifactType string
+
+	// The name of the template, the storage or the snapshot
 	TemplateName string
 
 	// The UUID of the template
 	TemplateUUID string
 
+	// The UUID of the storage, which holds the snapshot for snapshots
+	StorageUUID string
+
+	// The UUID of the snapshot
+	SnapshotUUID string
+
+	// The location of the template, or of the storage of storage and
+	// snapshot artifacts
+	LocationUUID string
+	LocationName string
+
+	// The capacity of the storage in GB
+	StorageCapacity int
+
+	// The template the storage has been created from
+	SourceTemplateUUID string
+
+	// The UUID, URL or file of the ISO image the server has booted from
+	SourceISOImage string
+
+	// The labels of the template
+	Labels []string
+
+	// StateData holds the data shared with post-processors, e.g.
+	// generated_data
+	StateData map[string]interface{}
+
+	// The manifest written to manifest_output
+	ManifestFile string
+
+	// SkippedTemplate is set if no template has been created because of
+	// skip_create_template
+	SkippedTemplate bool
+
 	// The client for making API calls
 	Client gsclient.TemplateOperator
+
+	// The clients for destroying storages and snapshots
+	StorageClient  gsclient.StorageOperator
+	SnapshotClient gsclient.StorageSnapshotOperator
 }
 
 func (*Artifact) BuilderId() string {
 	return BuilderId
 }
 
-func (*Artifact) Files() []string {
-	// No files with DigitalOcean
-	return nil
+func (a *Artifact) Files() []string {
+	if a.ManifestFile == "" {
+		return nil
+	}
+	return []string{a.ManifestFile}
 }
 
 func (a *Artifact) Id() string {
+	switch a.ArtifactType {
+	case artifactTypeStorage:
+		return a.StorageUUID
+	case artifactTypeSnapshot:
+		return a.SnapshotUUID
+	}
 	return a.TemplateUUID
 }
 
 func (a *Artifact) String() string {
+	switch a.ArtifactType {
+	case artifactTypeStorage:
+		return fmt.Sprintf("A storage was created: '%v' (ID: %v)", a.TemplateName, a.StorageUUID)
+	case artifactTypeSnapshot:
+		return fmt.Sprintf("A snapshot was created: '%v' (ID: %v, storage ID: %v)", a.TemplateName, a.SnapshotUUID, a.StorageUUID)
+	}
+	if a.SkippedTemplate {
+		return fmt.Sprintf("No template was created: '%v' (skip_create_template)", a.TemplateName)
+	}
 	return fmt.Sprintf("A template was created: '%v' (ID: %v)", a.TemplateName, a.TemplateUUID)
 }
 
 func (a *Artifact) State(name string) interface{} {
-	return nil
+	if name == registryimage.ArtifactStateURI {
+		return a.stateHCPPackerRegistryMetadata()
+	}
+	return a.StateData[name]
+}
+
+// stateHCPPackerRegistryMetadata returns the image metadata of the artifact
+// for the HCP Packer registry, or nil if no object has been produced.
+func (a *Artifact) stateHCPPackerRegistryMetadata() interface{} {
+	if a.Id() == "" {
+		return nil
+	}
+	labels := map[string]interface{}{
+		"name":             a.TemplateName,
+		"artifact_type":    a.ArtifactType,
+		"location_uuid":    a.LocationUUID,
+		"location_name":    a.LocationName,
+		"storage_capacity": strconv.Itoa(a.StorageCapacity),
+		"labels":           strings.Join(a.Labels, ","),
+	}
+	if a.ArtifactType == "" {
+		labels["artifact_type"] = artifactTypeTemplate
+	}
+	if a.StorageUUID != "" {
+		labels["storage_uuid"] = a.StorageUUID
+	}
+	sourceID := a.SourceTemplateUUID
+	if sourceID != "" {
+		labels["source_template_uuid"] = a.SourceTemplateUUID
+	}
+	if a.SourceISOImage != "" {
+		labels["source_iso_image"] = a.SourceISOImage
+		if sourceID == "" {
+			sourceID = a.SourceISOImage
+		}
+	}
+	img, err := registryimage.FromArtifact(a,
+		registryimage.WithProvider("gridscale"),
+		registryimage.WithRegion(a.LocationUUID),
+		registryimage.WithSourceID(sourceID),
+		registryimage.SetLabels(labels),
+	)
+	if err != nil {
+		log.Printf("Error creating the HCP Packer registry metadata: %s", err)
+		return nil
+	}
+	return img
 }
 
 func (a *Artifact) Destroy() error {
+	switch a.ArtifactType {
+	case artifactTypeStorage:
+		log.Printf("Destroying storage: %s (%s)", a.TemplateName, a.StorageUUID)
+		return a.StorageClient.DeleteStorage(context.Background(), a.StorageUUID)
+	case artifactTypeSnapshot:
+		// The storage has only been kept for the snapshot
+		log.Printf("Destroying snapshot: %s (%s)", a.TemplateName, a.SnapshotUUID)
+		err := a.SnapshotClient.DeleteStorageSnapshot(context.Background(), a.StorageUUID, a.SnapshotUUID)
+		if err != nil {
+			return err
+		}
+		log.Printf("Destroying storage: %s", a.StorageUUID)
+		return a.StorageClient.DeleteStorage(context.Background(), a.StorageUUID)
+	}
+	if a.SkippedTemplate {
+		// Nothing to destroy
+		return nil
+	}
 	log.Printf("Destroying template: %s (%s)", a.TemplateName, a.TemplateUUID)
 	err := a.Client.DeleteTemplate(context.Background(), a.TemplateUUID)
 	return err
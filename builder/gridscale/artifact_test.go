@@ -7,12 +7,20 @@ import (
 	"testing"
 
 	"github.com/gridscale/gsclient-go/v3"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
 )
 
 type TemplateOperatorMock struct{}
 
 func (t TemplateOperatorMock) GetTemplate(ctx context.Context, id string) (gsclient.Template, error) {
-	panic("implement me")
+	if id == "test" {
+		return gsclient.Template{Properties: gsclient.TemplateProperties{
+			ObjectUUID:   id,
+			LocationUUID: "location UUID",
+			LocationName: "de/fra",
+		}}, nil
+	}
+	return gsclient.Template{}, errors.New("error")
 }
 
 func (t TemplateOperatorMock) GetTemplateByName(ctx context.Context, name string) (gsclient.Template, error) {
@@ -89,9 +97,15 @@ func TestArtifact_BuilderId(t *testing.T) {
 
 func TestArtifact_Destroy(t *testing.T) {
 	type fields struct {
-		TemplateName string
-		TemplateUUID string
-		Client       gsclient.TemplateOperator
+		ArtifactType    string
+		StorageUUID     string
+		SnapshotUUID    string
+		StorageClient   gsclient.StorageOperator
+		SnapshotClient  gsclient.StorageSnapshotOperator
+		TemplateName    string
+		TemplateUUID    string
+		SkippedTemplate bool
+		Client          gsclient.TemplateOperator
 	}
 	tests := []struct {
 		name    string
@@ -116,13 +130,72 @@ func TestArtifact_Destroy(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "skipped template",
+			fields: fields{
+				TemplateName:    "test",
+				SkippedTemplate: true,
+				Client:          TemplateOperatorMock{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "destroy storage success",
+			fields: fields{
+				ArtifactType:  artifactTypeStorage,
+				TemplateName:  "test",
+				StorageUUID:   "success",
+				StorageClient: StorageOperatorMock{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "destroy storage fail",
+			fields: fields{
+				ArtifactType:  artifactTypeStorage,
+				TemplateName:  "test",
+				StorageUUID:   "fail",
+				StorageClient: StorageOperatorMock{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "destroy snapshot success",
+			fields: fields{
+				ArtifactType:   artifactTypeSnapshot,
+				TemplateName:   "test",
+				StorageUUID:    "success",
+				SnapshotUUID:   "success",
+				StorageClient:  StorageOperatorMock{},
+				SnapshotClient: SnapshotOperatorMock{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "destroy snapshot fail",
+			fields: fields{
+				ArtifactType:   artifactTypeSnapshot,
+				TemplateName:   "test",
+				StorageUUID:    "success",
+				SnapshotUUID:   "fail",
+				StorageClient:  StorageOperatorMock{},
+				SnapshotClient: SnapshotOperatorMock{},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &Artifact{
-				TemplateName: tt.fields.TemplateName,
-				TemplateUUID: tt.fields.TemplateUUID,
-				Client:       tt.fields.Client,
+				ArtifactType:    tt.fields.ArtifactType,
+				StorageUUID:     tt.fields.StorageUUID,
+				SnapshotUUID:    tt.fields.SnapshotUUID,
+				StorageClient:   tt.fields.StorageClient,
+				SnapshotClient:  tt.fields.SnapshotClient,
+				TemplateName:    tt.fields.TemplateName,
+				TemplateUUID:    tt.fields.TemplateUUID,
+				SkippedTemplate: tt.fields.SkippedTemplate,
+				Client:          tt.fields.Client,
 			}
 			if err := a.Destroy(); (err != nil) != tt.wantErr {
 				t.Errorf("Destroy() error = %v, wantErr %v", err, tt.wantErr)
@@ -135,6 +208,7 @@ func TestArtifact_Files(t *testing.T) {
 	type fields struct {
 		TemplateName string
 		TemplateUUID string
+		ManifestFile string
 		Client       gsclient.TemplateOperator
 	}
 	tests := []struct {
@@ -147,12 +221,20 @@ func TestArtifact_Files(t *testing.T) {
 			fields: fields{},
 			want:   nil,
 		},
+		{
+			name: "manifest file",
+			fields: fields{
+				ManifestFile: "manifest.json",
+			},
+			want: []string{"manifest.json"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ar := &Artifact{
 				TemplateName: tt.fields.TemplateName,
 				TemplateUUID: tt.fields.TemplateUUID,
+				ManifestFile: tt.fields.ManifestFile,
 				Client:       tt.fields.Client,
 			}
 			if got := ar.Files(); !reflect.DeepEqual(got, tt.want) {
@@ -164,9 +246,14 @@ func TestArtifact_Files(t *testing.T) {
 
 func TestArtifact_Id(t *testing.T) {
 	type fields struct {
-		TemplateName string
-		TemplateUUID string
-		Client       gsclient.TemplateOperator
+		ArtifactType   string
+		StorageUUID    string
+		SnapshotUUID   string
+		StorageClient  gsclient.StorageOperator
+		SnapshotClient gsclient.StorageSnapshotOperator
+		TemplateName   string
+		TemplateUUID   string
+		Client         gsclient.TemplateOperator
 	}
 	tests := []struct {
 		name   string
@@ -182,13 +269,37 @@ func TestArtifact_Id(t *testing.T) {
 			},
 			want: "test UUID",
 		},
+		{
+			name: "Get storage artifact ID",
+			fields: fields{
+				ArtifactType: artifactTypeStorage,
+				TemplateName: "test",
+				StorageUUID:  "storage UUID",
+			},
+			want: "storage UUID",
+		},
+		{
+			name: "Get snapshot artifact ID",
+			fields: fields{
+				ArtifactType: artifactTypeSnapshot,
+				TemplateName: "test",
+				StorageUUID:  "storage UUID",
+				SnapshotUUID: "snapshot UUID",
+			},
+			want: "snapshot UUID",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &Artifact{
-				TemplateName: tt.fields.TemplateName,
-				TemplateUUID: tt.fields.TemplateUUID,
-				Client:       tt.fields.Client,
+				ArtifactType:   tt.fields.ArtifactType,
+				StorageUUID:    tt.fields.StorageUUID,
+				SnapshotUUID:   tt.fields.SnapshotUUID,
+				StorageClient:  tt.fields.StorageClient,
+				SnapshotClient: tt.fields.SnapshotClient,
+				TemplateName:   tt.fields.TemplateName,
+				TemplateUUID:   tt.fields.TemplateUUID,
+				Client:         tt.fields.Client,
 			}
 			if got := a.Id(); got != tt.want {
 				t.Errorf("Id() = %v, want %v", got, tt.want)
@@ -201,6 +312,7 @@ func TestArtifact_State(t *testing.T) {
 	type fields struct {
 		TemplateName string
 		TemplateUUID string
+		StateData    map[string]interface{}
 		Client       gsclient.TemplateOperator
 	}
 	type args struct {
@@ -218,12 +330,33 @@ func TestArtifact_State(t *testing.T) {
 			args:   args{},
 			want:   nil,
 		},
+		{
+			name: "Get generated data",
+			fields: fields{
+				TemplateName: "test",
+				TemplateUUID: "test UUID",
+				StateData: map[string]interface{}{
+					"generated_data": map[string]interface{}{"TemplateUUID": "test UUID"},
+				},
+			},
+			args: args{name: "generated_data"},
+			want: map[string]interface{}{"TemplateUUID": "test UUID"},
+		},
+		{
+			name: "Get unknown state",
+			fields: fields{
+				StateData: map[string]interface{}{"generated_data": nil},
+			},
+			args: args{name: "unknown"},
+			want: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &Artifact{
 				TemplateName: tt.fields.TemplateName,
 				TemplateUUID: tt.fields.TemplateUUID,
+				StateData:    tt.fields.StateData,
 				Client:       tt.fields.Client,
 			}
 			if got := a.State(tt.args.name); !reflect.DeepEqual(got, tt.want) {
@@ -233,11 +366,101 @@ func TestArtifact_State(t *testing.T) {
 	}
 }
 
+func TestArtifact_State_hcpPackerRegistryMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		want     interface{}
+	}{
+		{
+			name: "template from a template",
+			artifact: &Artifact{
+				ArtifactType:       artifactTypeTemplate,
+				TemplateName:       "test",
+				TemplateUUID:       "test UUID",
+				LocationUUID:       "location UUID",
+				LocationName:       "de/fra",
+				StorageCapacity:    10,
+				SourceTemplateUUID: "base UUID",
+				Labels:             []string{"nightly", "ubuntu"},
+			},
+			want: &registryimage.Image{
+				ImageID:        "test UUID",
+				ProviderName:   "gridscale",
+				ProviderRegion: "location UUID",
+				SourceImageID:  "base UUID",
+				Labels: map[string]string{
+					"name":                 "test",
+					"artifact_type":        "template",
+					"location_uuid":        "location UUID",
+					"location_name":        "de/fra",
+					"storage_capacity":     "10",
+					"labels":               "nightly,ubuntu",
+					"source_template_uuid": "base UUID",
+				},
+			},
+		},
+		{
+			name: "storage from an ISO image",
+			artifact: &Artifact{
+				ArtifactType:    artifactTypeStorage,
+				TemplateName:    "test",
+				StorageUUID:     "storage UUID",
+				StorageCapacity: 20,
+				SourceISOImage:  "https://example.com/ubuntu.iso",
+			},
+			want: &registryimage.Image{
+				ImageID:       "storage UUID",
+				ProviderName:  "gridscale",
+				SourceImageID: "https://example.com/ubuntu.iso",
+				Labels: map[string]string{
+					"name":             "test",
+					"artifact_type":    "storage",
+					"location_uuid":    "",
+					"location_name":    "",
+					"storage_capacity": "20",
+					"labels":           "",
+					"storage_uuid":     "storage UUID",
+					"source_iso_image": "https://example.com/ubuntu.iso",
+				},
+			},
+		},
+		{
+			name: "skipped template",
+			artifact: &Artifact{
+				TemplateName:    "test",
+				SkippedTemplate: true,
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.artifact.State(registryimage.ArtifactStateURI)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("State() = %v, want nil", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("State() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestArtifact_String(t *testing.T) {
 	type fields struct {
-		TemplateName string
-		TemplateUUID string
-		Client       gsclient.TemplateOperator
+		ArtifactType    string
+		StorageUUID     string
+		SnapshotUUID    string
+		StorageClient   gsclient.StorageOperator
+		SnapshotClient  gsclient.StorageSnapshotOperator
+		TemplateName    string
+		TemplateUUID    string
+		SkippedTemplate bool
+		Client          gsclient.TemplateOperator
 	}
 	tests := []struct {
 		name   string
@@ -253,13 +476,46 @@ func TestArtifact_String(t *testing.T) {
 			},
 			want: "A template was created: 'test' (ID: test UUID)",
 		},
+		{
+			name: "Get artifact string of a skipped template",
+			fields: fields{
+				TemplateName:    "test",
+				SkippedTemplate: true,
+			},
+			want: "No template was created: 'test' (skip_create_template)",
+		},
+		{
+			name: "Get storage artifact string",
+			fields: fields{
+				ArtifactType: artifactTypeStorage,
+				TemplateName: "test",
+				StorageUUID:  "storage UUID",
+			},
+			want: "A storage was created: 'test' (ID: storage UUID)",
+		},
+		{
+			name: "Get snapshot artifact string",
+			fields: fields{
+				ArtifactType: artifactTypeSnapshot,
+				TemplateName: "test",
+				StorageUUID:  "storage UUID",
+				SnapshotUUID: "snapshot UUID",
+			},
+			want: "A snapshot was created: 'test' (ID: snapshot UUID, storage ID: storage UUID)",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &Artifact{
-				TemplateName: tt.fields.TemplateName,
-				TemplateUUID: tt.fields.TemplateUUID,
-				Client:       tt.fields.Client,
+				ArtifactType:    tt.fields.ArtifactType,
+				StorageUUID:     tt.fields.StorageUUID,
+				SnapshotUUID:    tt.fields.SnapshotUUID,
+				StorageClient:   tt.fields.StorageClient,
+				SnapshotClient:  tt.fields.SnapshotClient,
+				TemplateName:    tt.fields.TemplateName,
+				TemplateUUID:    tt.fields.TemplateUUID,
+				SkippedTemplate: tt.fields.SkippedTemplate,
+				Client:          tt.fields.Client,
 			}
 			if got := a.String(); got != tt.want {
 				t.Errorf("String() = %v, want %v", got, tt.want)
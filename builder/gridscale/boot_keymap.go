@@ -0,0 +1,251 @@
+package gridscale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// keymapKey holds the characters a physical key produces in a keyboard
+// layout, unmodified, with shift and with AltGr. A zero rune means that the
+// key produces no character with that modifier.
+type keymapKey struct {
+	base  rune
+	shift rune
+	altGr rune
+}
+
+// keymap is a guest keyboard layout. The VNC server translates keysyms to
+// scancodes with a US layout, so the guest layout is described by what each
+// physical key produces, keyed by the unshifted character of that key on a
+// US keyboard. Letter keys which are not listed behave like on a US keyboard.
+type keymap struct {
+	keys map[rune]keymapKey
+	// deadKeys holds, per physical key, the characters which are combined
+	// with the next key press instead of being typed right away.
+	deadKeys map[rune]string
+}
+
+// keymaps holds the supported values of boot_keymap, next to "us".
+// Characters on the additional key of ISO keyboards, e.g. "<", ">" and "|"
+// on German keyboards, cannot be reached with a US layout VNC server.
+var keymaps = map[string]keymap{
+	"de": {
+		keys: map[rune]keymapKey{
+			'`':  {'^', '°', 0},
+			'1':  {'1', '!', '¹'},
+			'2':  {'2', '"', '²'},
+			'3':  {'3', '§', '³'},
+			'4':  {'4', '$', 0},
+			'5':  {'5', '%', 0},
+			'6':  {'6', '&', 0},
+			'7':  {'7', '/', '{'},
+			'8':  {'8', '(', '['},
+			'9':  {'9', ')', ']'},
+			'0':  {'0', '=', '}'},
+			'-':  {'ß', '?', '\\'},
+			'=':  {'´', '`', 0},
+			'q':  {'q', 'Q', '@'},
+			'e':  {'e', 'E', '€'},
+			'y':  {'z', 'Z', 0},
+			'[':  {'ü', 'Ü', 0},
+			']':  {'+', '*', '~'},
+			'\\': {'#', '\'', 0},
+			';':  {'ö', 'Ö', 0},
+			'\'': {'ä', 'Ä', 0},
+			'z':  {'y', 'Y', 0},
+			'm':  {'m', 'M', 'µ'},
+			',':  {',', ';', 0},
+			'.':  {'.', ':', 0},
+			'/':  {'-', '_', 0},
+		},
+		deadKeys: map[rune]string{'`': "^", '=': "´`"},
+	},
+	"fr": {
+		keys: map[rune]keymapKey{
+			'`':  {'²', 0, 0},
+			'1':  {'&', '1', 0},
+			'2':  {'é', '2', '~'},
+			'3':  {'"', '3', '#'},
+			'4':  {'\'', '4', '{'},
+			'5':  {'(', '5', '['},
+			'6':  {'-', '6', '|'},
+			'7':  {'è', '7', '`'},
+			'8':  {'_', '8', '\\'},
+			'9':  {'ç', '9', '^'},
+			'0':  {'à', '0', '@'},
+			'-':  {')', '°', ']'},
+			'=':  {'=', '+', '}'},
+			'q':  {'a', 'A', 0},
+			'w':  {'z', 'Z', 0},
+			'e':  {'e', 'E', '€'},
+			'[':  {'^', '¨', 0},
+			']':  {'$', '£', '¤'},
+			'\\': {'*', 'µ', 0},
+			'a':  {'q', 'Q', 0},
+			';':  {'m', 'M', 0},
+			'\'': {'ù', '%', 0},
+			'z':  {'w', 'W', 0},
+			'm':  {',', '?', 0},
+			',':  {';', '.', 0},
+			'.':  {':', '/', 0},
+			'/':  {'!', '§', 0},
+		},
+		deadKeys: map[rune]string{'[': "^¨"},
+	},
+	"ch": {
+		keys: map[rune]keymapKey{
+			'`':  {'§', '°', 0},
+			'1':  {'1', '+', '¦'},
+			'2':  {'2', '"', '@'},
+			'3':  {'3', '*', '#'},
+			'4':  {'4', 'ç', 0},
+			'5':  {'5', '%', 0},
+			'6':  {'6', '&', '¬'},
+			'7':  {'7', '/', '|'},
+			'8':  {'8', '(', '¢'},
+			'9':  {'9', ')', 0},
+			'0':  {'0', '=', 0},
+			'-':  {'\'', '?', '´'},
+			'=':  {'^', '`', '~'},
+			'e':  {'e', 'E', '€'},
+			'y':  {'z', 'Z', 0},
+			'[':  {'ü', 'è', '['},
+			']':  {'¨', '!', ']'},
+			'\\': {'$', '£', '}'},
+			';':  {'ö', 'é', 0},
+			'\'': {'ä', 'à', '{'},
+			'z':  {'y', 'Y', 0},
+			',':  {',', ';', 0},
+			'.':  {'.', ':', 0},
+			'/':  {'-', '_', 0},
+		},
+		deadKeys: map[rune]string{'-': "´", '=': "^`~", ']': "¨"},
+	},
+}
+
+// usShiftedKeys maps the unshifted characters of the US layout to the
+// characters produced by the same keys with shift.
+var usShiftedKeys = map[rune]rune{
+	'`': '~', '1': '!', '2': '@', '3': '#', '4': '$', '5': '%', '6': '^',
+	'7': '&', '8': '*', '9': '(', '0': ')', '-': '_', '=': '+', '[': '{',
+	']': '}', '\\': '|', ';': ':', '\'': '"', ',': '<', '.': '>', '/': '?',
+}
+
+// keyStroke describes how a character is typed with a US layout VNC
+// server, so that the guest layout produces it.
+type keyStroke struct {
+	// key is the character sent to the VNC server, a shifted US character
+	// if shift has to be held.
+	key   rune
+	altGr bool
+	// dead is set if the key has to be followed by a space to produce
+	// the character itself.
+	dead bool
+}
+
+// keyStrokes returns the key strokes for every character of the keymap.
+// If a character can be typed in several ways, the one with the fewest
+// modifiers is used.
+func (k keymap) keyStrokes() map[rune]keyStroke {
+	keys := make(map[rune]keymapKey, len(k.keys))
+	for key, chars := range k.keys {
+		keys[key] = chars
+	}
+	for key := 'a'; key <= 'z'; key++ {
+		if _, ok := keys[key]; !ok {
+			keys[key] = keymapKey{key, unicode.ToUpper(key), 0}
+		}
+	}
+	// Iterate in a stable order, so that ties are always resolved the same way
+	usKeys := make([]rune, 0, len(keys))
+	for key := range keys {
+		usKeys = append(usKeys, key)
+	}
+	sort.Slice(usKeys, func(i, j int) bool { return usKeys[i] < usKeys[j] })
+
+	strokes := make(map[rune]keyStroke)
+	costs := make(map[rune]int)
+	add := func(char rune, stroke keyStroke, cost int) {
+		if char == 0 {
+			return
+		}
+		if stroke.dead {
+			cost += 10
+		}
+		if c, ok := costs[char]; ok && c <= cost {
+			return
+		}
+		strokes[char] = stroke
+		costs[char] = cost
+	}
+	for _, key := range usKeys {
+		chars := keys[key]
+		shifted := unicode.ToUpper(key)
+		if s, ok := usShiftedKeys[key]; ok {
+			shifted = s
+		}
+		dead := func(char rune) bool {
+			return strings.ContainsRune(k.deadKeys[key], char)
+		}
+		add(chars.base, keyStroke{key: key, dead: dead(chars.base)}, 0)
+		add(chars.shift, keyStroke{key: shifted, dead: dead(chars.shift)}, 1)
+		add(chars.altGr, keyStroke{key: key, altGr: true, dead: dead(chars.altGr)}, 2)
+	}
+	return strokes
+}
+
+// vncBootDriver types boot commands over VNC for a guest with a non-US
+// keyboard layout. Special keys and white space are sent unchanged, other
+// characters missing in the keymap cannot be typed.
+type vncBootDriver struct {
+	bootcommand.BCDriver
+	keymap  string
+	strokes map[rune]keyStroke
+}
+
+// newVNCBootDriver returns the boot command driver for the given
+// boot_keymap.
+func newVNCBootDriver(c bootcommand.VNCKeyEvent, interval time.Duration, bootKeymap string) bootcommand.BCDriver {
+	d := bootcommand.NewVNCDriver(c, interval)
+	k, ok := keymaps[bootKeymap]
+	if !ok {
+		return d
+	}
+	return &vncBootDriver{
+		BCDriver: d,
+		keymap:   bootKeymap,
+		strokes:  k.keyStrokes(),
+	}
+}
+
+func (d *vncBootDriver) SendKey(key rune, action bootcommand.KeyAction) error {
+	stroke, ok := d.strokes[key]
+	if !ok {
+		if !unicode.IsSpace(key) {
+			return fmt.Errorf("the character %q cannot be typed with boot_keymap %q", key, d.keymap)
+		}
+		return d.BCDriver.SendKey(key, action)
+	}
+	if stroke.altGr && action != bootcommand.KeyOff {
+		if err := d.SendSpecial("rightalt", bootcommand.KeyOn); err != nil {
+			return err
+		}
+	}
+	if err := d.BCDriver.SendKey(stroke.key, action); err != nil {
+		return err
+	}
+	if stroke.altGr && action != bootcommand.KeyOn {
+		if err := d.SendSpecial("rightalt", bootcommand.KeyOff); err != nil {
+			return err
+		}
+	}
+	if stroke.dead && action == bootcommand.KeyPress {
+		return d.SendSpecial("spacebar", bootcommand.KeyPress)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package gridscale
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// keyEventRecorder records the key down events sent by a boot command
+// driver.
+type keyEventRecorder struct {
+	keys []uint32
+}
+
+func (r *keyEventRecorder) KeyEvent(keysym uint32, down bool) error {
+	if down {
+		r.keys = append(r.keys, keysym)
+	}
+	return nil
+}
+
+const (
+	testKeyShift = bootcommand.KeyLeftShift
+	testKeyAltGr = 0xFFEA
+)
+
+func Test_keymap_keyStrokes(t *testing.T) {
+	tests := []struct {
+		name   string
+		keymap string
+		char   rune
+		want   keyStroke
+	}{
+		{name: "de y", keymap: "de", char: 'y', want: keyStroke{key: 'z'}},
+		{name: "de Z", keymap: "de", char: 'Z', want: keyStroke{key: 'Y'}},
+		{name: "de minus", keymap: "de", char: '-', want: keyStroke{key: '/'}},
+		{name: "de colon", keymap: "de", char: ':', want: keyStroke{key: '>'}},
+		{name: "de slash", keymap: "de", char: '/', want: keyStroke{key: '&'}},
+		{name: "de at", keymap: "de", char: '@', want: keyStroke{key: 'q', altGr: true}},
+		{name: "de backslash", keymap: "de", char: '\\', want: keyStroke{key: '-', altGr: true}},
+		{name: "de caret", keymap: "de", char: '^', want: keyStroke{key: '`', dead: true}},
+		{name: "de umlaut", keymap: "de", char: 'ö', want: keyStroke{key: ';'}},
+		{name: "fr a", keymap: "fr", char: 'a', want: keyStroke{key: 'q'}},
+		{name: "fr m", keymap: "fr", char: 'm', want: keyStroke{key: ';'}},
+		{name: "fr digit", keymap: "fr", char: '1', want: keyStroke{key: '!'}},
+		{name: "fr slash", keymap: "fr", char: '/', want: keyStroke{key: '>'}},
+		{name: "fr caret prefers the key which is not dead", keymap: "fr", char: '^', want: keyStroke{key: '9', altGr: true}},
+		{name: "ch z", keymap: "ch", char: 'z', want: keyStroke{key: 'y'}},
+		{name: "ch plus", keymap: "ch", char: '+', want: keyStroke{key: '!'}},
+		{name: "ch pipe", keymap: "ch", char: '|', want: keyStroke{key: '7', altGr: true}},
+		{name: "ch tilde", keymap: "ch", char: '~', want: keyStroke{key: '=', altGr: true, dead: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := keymaps[tt.keymap].keyStrokes()[tt.char]
+			if !ok {
+				t.Fatalf("keyStrokes()[%q] is missing", tt.char)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keyStrokes()[%q] = %+v, want %+v", tt.char, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keymap_keyStrokes_complete(t *testing.T) {
+	// Every printable ASCII character has to be typeable, apart from the
+	// ones on the additional key of ISO keyboards
+	unreachable := map[string]string{
+		"de": "<>|",
+		"fr": "<>",
+		"ch": "<>\\",
+	}
+	for name, k := range keymaps {
+		strokes := k.keyStrokes()
+		for char := rune(0x21); char < 0x7F; char++ {
+			_, ok := strokes[char]
+			want := !strings.ContainsRune(unreachable[name], char)
+			if ok != want {
+				t.Errorf("%s: keyStrokes()[%q] present = %v, want %v", name, char, ok, want)
+			}
+		}
+	}
+}
+
+func Test_newVNCBootDriver(t *testing.T) {
+	tests := []struct {
+		name    string
+		keymap  string
+		command string
+		want    []uint32
+		wantErr string
+	}{
+		{
+			name:    "us",
+			keymap:  "",
+			command: "y-/:",
+			want:    []uint32{'y', '-', '/', testKeyShift, ':'},
+		},
+		{
+			name:    "de",
+			keymap:  "de",
+			command: "y-/:",
+			want:    []uint32{'z', '/', testKeyShift, '&', testKeyShift, '>'},
+		},
+		{
+			name:    "de AltGr",
+			keymap:  "de",
+			command: "@",
+			want:    []uint32{testKeyAltGr, 'q'},
+		},
+		{
+			name:    "de dead key",
+			keymap:  "de",
+			command: "^",
+			want:    []uint32{'`', ' '},
+		},
+		{
+			name:    "de special keys are not translated",
+			keymap:  "de",
+			command: "<enter>",
+			want:    []uint32{0xFF0D},
+		},
+		{
+			name:    "de held modifiers",
+			keymap:  "de",
+			command: "<leftCtrlOn>y<leftCtrlOff>",
+			want:    []uint32{0xFFE3, 'z'},
+		},
+		{
+			name:    "fr",
+			keymap:  "fr",
+			command: "a1",
+			want:    []uint32{'q', testKeyShift, '!'},
+		},
+		{
+			name:    "de white space",
+			keymap:  "de",
+			command: "y y",
+			want:    []uint32{'z', ' ', 'z'},
+		},
+		{
+			name:    "de missing character",
+			keymap:  "de",
+			command: "y|y",
+			want:    []uint32{'z'},
+			wantErr: `the character '|' cannot be typed with boot_keymap "de"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &keyEventRecorder{}
+			seq, err := bootcommand.GenerateExpressionSequence(tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = seq.Do(context.Background(), newVNCBootDriver(r, time.Nanosecond, tt.keymap))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(r.keys, tt.want) {
+				t.Errorf("key events = %v, want %v", r.keys, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -23,6 +24,17 @@ const (
 	defaultGSCMaxNumberOfRetries     = 5
 )
 
+// generatedDataNames are the variables published by the steps as they become
+// known, e.g. `build.ServerIP` for provisioners.
+var generatedDataNames = []string{
+	"ServerUUID",
+	"ServerIP",
+	"StorageUUID",
+	"TemplateUUID",
+	"SourceTemplateUUID",
+	"Password",
+}
+
 type Builder struct {
 	config Config
 	runner multistep.Runner
@@ -37,160 +49,366 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	}
 	b.config = *c
 
-	return nil, nil, nil
+	return generatedDataNames, warnings, nil
 }
 
 func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
 	//client := gsclient.NewClient(gsclient.DefaultConfiguration(b.config.APIKey, b.config.APIToken))
 	c := b.config
-	apiURL := defaultAPIURL
-	if c.APIURL != "" {
-		apiURL = c.APIURL
+	client := NewClient(c.APIURL, c.APIKey, c.APIToken, c.APIRequestHeaders)
+
+	// Build the steps
+	steps := b.steps(client, ui)
+	// Set up the state
+	state := new(multistep.BasicStateBag)
+	state.Put("hook", hook)
+	state.Put("ui", ui)
+	var manifest *buildManifest
+	if c.ManifestOutput != "" {
+		manifest = newBuildManifest(&c, time.Now)
+		state.Put("manifest", manifest)
+		steps = manifest.wrap(steps, c.PackerOnError)
+	}
+	// Run the steps
+	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
+	b.runner.Run(ctx, state)
+
+	var err error
+	if rawErr, ok := state.GetOk("error"); ok {
+		err = rawErr.(error)
+	}
+	var artifact *Artifact
+	if err == nil {
+		artifact = b.artifact(client, state)
+	}
+	if manifest != nil {
+		manifest.finish(artifact, err)
+		if writeErr := manifest.write(c.ManifestOutput); writeErr != nil {
+			ui.Error(fmt.Sprintf("Error writing the manifest %s: %s", c.ManifestOutput, writeErr))
+		} else if artifact != nil {
+			artifact.ManifestFile = c.ManifestOutput
+		}
+	}
+
+	// If there was an error, return that
+	if err != nil {
+		return nil, err
+	}
+	if artifact == nil {
+		return nil, nil
+	}
+	return artifact, nil
+}
+
+// NewClient returns an API client, which waits for the requests to finish.
+// The requests are logged with PACKER_LOG. requestHeaders are the debug
+// headers of api_request_headers.
+func NewClient(apiURL, apiKey, apiToken, requestHeaders string) *gsclient.Client {
+	if apiURL == "" {
+		apiURL = defaultAPIURL
 	}
 	client := gsclient.NewClient(gsclient.NewConfiguration(
 		apiURL,
-		c.APIKey,
-		c.APIToken,
+		apiKey,
+		apiToken,
 		os.Getenv("PACKER_LOG") != "",
 		true,
 		defaultGSCDelayIntervalMilliSecs,
 		defaultGSCMaxNumberOfRetries,
 	))
 	// Add debug HTTP Headers if set
-	if c.APIRequestHeaders != "" {
-		client.WithHTTPHeaders(convertStrToHeaderMap(c.APIRequestHeaders))
+	if requestHeaders != "" {
+		client.WithHTTPHeaders(convertStrToHeaderMap(requestHeaders))
 	}
+	return client
+}
 
-	// Build the steps
-	steps := []multistep.Step{
-		&stepGetPublicNetwork{
+// artifactClient is the part of the API client needed for the artifact.
+type artifactClient interface {
+	gsclient.TemplateOperator
+	gsclient.StorageOperator
+	gsclient.StorageSnapshotOperator
+	gsclient.LocationOperator
+}
+
+// artifact returns the artifact of a successful build, or nil if the
+// template cannot be found.
+func (b *Builder) artifact(client artifactClient, state multistep.StateBag) *Artifact {
+	c := b.config
+	artifact := &Artifact{
+		ArtifactType:       c.ArtifactType,
+		TemplateName:       c.TemplateName,
+		StorageCapacity:    c.StorageCapacity,
+		SourceTemplateUUID: c.BaseTemplateUUID,
+		SourceISOImage:     c.bootISOImageSource(),
+		Labels:             c.TemplateLabels,
+		Client:             client,
+		StorageClient:      client,
+		SnapshotClient:     client,
+		StateData: map[string]interface{}{
+			"generated_data": state.Get("generated_data"),
+			"artifact_type":  c.ArtifactType,
+		},
+	}
+	if url, ok := state.GetOk("export_url"); ok {
+		artifact.StateData["export_url"] = url
+		artifact.StateData["export_sha256"] = state.Get("export_sha256")
+	}
+	switch {
+	case c.SkipCreateTemplate:
+		artifact.SkippedTemplate = true
+		return artifact
+	case c.ArtifactType == artifactTypeStorage:
+		storageUUID, ok := state.Get("artifact_storage_uuid").(string)
+		if !ok {
+			log.Println("Failed to find artifact_storage_uuid in state. Bug?")
+			return nil
+		}
+		artifact.StorageUUID = storageUUID
+		artifact.StateData["storage_uuid"] = artifact.StorageUUID
+		artifact.setStorageLocation(client)
+		return artifact
+	case c.ArtifactType == artifactTypeSnapshot:
+		storageUUID, ok := state.Get("artifact_storage_uuid").(string)
+		if !ok {
+			log.Println("Failed to find artifact_storage_uuid in state. Bug?")
+			return nil
+		}
+		snapshotUUID, ok := state.Get("snapshot_uuid").(string)
+		if !ok {
+			log.Println("Failed to find snapshot_uuid in state. Bug?")
+			return nil
+		}
+		artifact.StorageUUID = storageUUID
+		artifact.SnapshotUUID = snapshotUUID
+		artifact.StateData["storage_uuid"] = artifact.StorageUUID
+		artifact.setStorageLocation(client)
+		return artifact
+	}
+
+	if _, ok := state.GetOk("template_uuid"); !ok {
+		log.Println("Failed to find template_uuid in state. Bug?")
+		return nil
+	}
+	artifact.TemplateUUID = state.Get("template_uuid").(string)
+	if template, ok := state.Get("template").(gsclient.Template); ok {
+		artifact.LocationUUID = template.Properties.LocationUUID
+		artifact.LocationName = template.Properties.LocationName
+	}
+	return artifact
+}
+
+// setStorageLocation sets the location of the artifact to the location of
+// its storage. The location is only informational, so it is left empty if it
+// cannot be found.
+func (a *Artifact) setStorageLocation(client artifactClient) {
+	storage, err := client.GetStorage(context.Background(), a.StorageUUID)
+	if err != nil {
+		log.Printf("Error getting the details of storage %v: %s", a.StorageUUID, err)
+		return
+	}
+	a.LocationUUID = storage.Properties.LocationUUID
+	location, err := client.GetLocation(context.Background(), a.LocationUUID)
+	if err != nil {
+		log.Printf("Error getting the location %v: %s", a.LocationUUID, err)
+		return
+	}
+	a.LocationName = location.Properties.Name
+}
+
+// steps returns the steps of a build. Isolated builds skip the steps
+// connecting the server to the public network. Without a communicator, the
+// steps connecting to the server are skipped, and the build goes from the
+// installation straight to the shutdown. The server is still connected to
+// the public network if it has to reach the HTTP file server. Without a
+// template to create, the build ends after the shutdown, or after taking the
+// snapshot. With export_s3, the snapshot is exported right after taking it.
+func (b *Builder) steps(client *gsclient.Client, ui packer.Ui) []multistep.Step {
+	c := &b.config
+	var steps []multistep.Step
+	if c.createsTemplate() {
+		steps = append(steps, &stepFindExistingTemplates{
 			client: client,
+			config: c,
 			ui:     ui,
-		},
+		})
+	}
+	if !c.Isolated {
+		steps = append(steps, &stepGetPublicNetwork{
+			client: client,
+			ui:     ui,
+		})
+	}
+	steps = append(steps,
 		&stepServeHTTPFiles{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepCreateServer{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
-		&stepCreateSSHKey{
-			Debug:        b.config.PackerDebug,
-			DebugKeyPath: fmt.Sprintf("gs_%s.pem", b.config.PackerBuildName),
+	)
+	if c.hasCommunicator() {
+		steps = append(steps, &stepCreateSSHKey{
+			Debug:        c.PackerDebug,
+			DebugKeyPath: fmt.Sprintf("gs_%s.pem", c.PackerBuildName),
 			client:       client,
-			config:       &b.config,
+			config:       c,
 			ui:           ui,
-		},
+		})
+	}
+	steps = append(steps,
 		&stepCreateBootStorage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepLinkServerBootStorage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepCreateSecondaryStorage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepLinkServerSecondaryStorage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
-		&stepCreateIPAddr{
-			client: client,
-			config: &b.config,
+	)
+	if !c.Isolated && (c.hasCommunicator() || c.needsFileServer()) {
+		steps = append(steps,
+			&stepCreateIPAddr{
+				client: client,
+				config: c,
+				ui:     ui,
+			},
+			&stepLinkServerIPAddr{
+				client: client,
+				config: c,
+				ui:     ui,
+			},
+			&stepLinkServerPublicNetwork{
+				client: client,
+				ui:     ui,
+			},
+		)
+	}
+	steps = append(steps,
+		&stepCreateCDImage{
+			config: c,
 			ui:     ui,
 		},
-		&stepLinkServerIPAddr{
-			client: client,
-			config: &b.config,
-			ui:     ui,
-		},
-		&stepLinkServerPublicNetwork{
-			client: client,
+		&stepUploadISOFiles{
+			config: c,
 			ui:     ui,
 		},
 		&stepCreateISOImage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepLinkServerISOImage{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepStartServer{
 			client: client,
 			ui:     ui,
 		},
+		&stepVNCProxy{
+			client: client,
+			config: c,
+			ui:     ui,
+		},
 		&StepVNCConnect{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&StepExecuteBootCommand{
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
 		&stepCleanupISOImageInstallation{
 			relClient: client,
 			sClient:   client,
-			config:    &b.config,
+			config:    c,
 			ui:        ui,
 		},
-		&communicator.StepConnect{
-			Config:    &b.config.Comm,
-			Host:      communicator.CommHost(b.config.Comm.SSHHost, "server_ip"),
-			SSHConfig: b.config.Comm.SSHConfigFunc(),
-		},
-		&commonsteps.StepProvision{},
-		&stepShutdownServer{
+	)
+	if c.hasCommunicator() {
+		steps = append(steps,
+			&StepVNCConnect{
+				client:     client,
+				config:     c,
+				ui:         ui,
+				postReboot: true,
+			},
+			&StepExecuteBootCommand{
+				config:     c,
+				ui:         ui,
+				postReboot: true,
+			},
+			&communicator.StepConnect{
+				Config:    &c.Comm,
+				Host:      communicator.CommHost(c.Comm.SSHHost, "server_ip"),
+				SSHConfig: c.Comm.SSHConfigFunc(),
+			},
+			&commonsteps.StepProvision{},
+		)
+	}
+	steps = append(steps, &stepShutdownServer{
+		client: client,
+		config: c,
+		ui:     ui,
+	})
+	if !c.createsTemplate() {
+		if c.ArtifactType == artifactTypeSnapshot || c.CheckSnapshot {
+			steps = append(steps, &stepCreateSnapshot{
+				client: client,
+				config: c,
+				ui:     ui,
+			})
+		}
+		if c.ExportS3 != nil && c.ArtifactType == artifactTypeSnapshot {
+			steps = append(steps, &stepExportSnapshot{
+				client: client,
+				config: c,
+				ui:     ui,
+			})
+		}
+		return steps
+	}
+	steps = append(steps, &stepCreateSnapshot{
+		client: client,
+		config: c,
+		ui:     ui,
+	})
+	if c.ExportS3 != nil {
+		steps = append(steps, &stepExportSnapshot{
 			client: client,
+			config: c,
 			ui:     ui,
-		},
-		&stepCreateSnapshot{
+		})
+	}
+	return append(steps,
+		&stepCreateTemplate{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
-		&stepCreateTemplate{
+		&stepRemoveOldTemplates{
 			client: client,
-			config: &b.config,
+			config: c,
 			ui:     ui,
 		},
-	}
-	// Set up the state
-	state := new(multistep.BasicStateBag)
-	state.Put("hook", hook)
-	state.Put("ui", ui)
-	// Run the steps
-	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
-
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
-	}
-
-	if _, ok := state.GetOk("template_uuid"); !ok {
-		log.Println("Failed to find template_uuid in state. Bug?")
-		return nil, nil
-	}
-
-	artifact := &Artifact{
-		TemplateName: b.config.TemplateName,
-		TemplateUUID: state.Get("template_uuid").(string),
-		Client:       client,
-	}
-
-	return artifact, nil
+	)
 }
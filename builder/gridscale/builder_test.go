@@ -1,10 +1,14 @@
 package gridscale
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 )
 
 func TestBuilder_Prepare(t *testing.T) {
@@ -37,22 +41,615 @@ func TestBuilder_Prepare(t *testing.T) {
 					"ssh_username":       "root",
 				},
 			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "bad type",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          []string{},
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "invalid key",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"invalid_key":        "",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "deprecated file server address placeholder",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"boot_command":       []string{"http://{{__HTTP__ADDRESS__}}/ks.cfg"},
+				},
+			},
+			want:    generatedDataNames,
+			want1:   []string{"{{__HTTP__ADDRESS__}} is deprecated, use {{ .HTTPIP }}:{{ .HTTPPort }} instead"},
+			wantErr: false,
+		},
+		{
+			name:   "several ISO images",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"iso_images": []map[string]interface{}{
+						{"url": "test URL", "boot": true},
+						{"uuid": "test"},
+					},
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "iso_images combined with isoimage_uuid",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_uuid":    "test",
+					"iso_images": []map[string]interface{}{
+						{"uuid": "test"},
+					},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "ISO image with uuid and url",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"iso_images": []map[string]interface{}{
+						{"uuid": "test", "url": "test URL"},
+					},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "several boot ISO images",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"iso_images": []map[string]interface{}{
+						{"uuid": "test", "boot": true},
+						{"url": "test URL", "boot": true},
+					},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "cached ISO image",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_url":     "test URL",
+					"iso_checksum":     "md5:d41d8cd98f00b204e9800998ecf8427e",
+					"iso_cache":        true,
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "iso_cache without checksum",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_url":     "test URL",
+					"iso_cache":        true,
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "invalid iso_checksum",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_url":     "test URL",
+					"iso_checksum":     "md5:test",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "iso_checksum with isoimage_uuid",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_uuid":    "test UUID",
+					"iso_checksum":     "md5:d41d8cd98f00b204e9800998ecf8427e",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "iso_checksum with isoimage_uuid and isoimage_url",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"isoimage_uuid":    "test UUID",
+					"isoimage_url":     "test URL",
+					"iso_checksum":     "md5:d41d8cd98f00b204e9800998ecf8427e",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "iso_file combined with isoimage_url",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"iso_file":         "builder_test.go",
+					"isoimage_url":     "test URL",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "missing iso_file",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"ssh_username":     "root",
+					"iso_file":         "missing.iso",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "iso_file uploaded to S3",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":         "test",
+					"api_key":           "test",
+					"server_cores":      2,
+					"server_memory":     4,
+					"storage_capacity":  10,
+					"ssh_username":      "root",
+					"iso_file":          "builder_test.go",
+					"iso_upload_target": "s3",
+					"iso_upload_s3": map[string]interface{}{
+						"bucket":     "test",
+						"access_key": "test",
+						"secret_key": "test",
+					},
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "iso_upload_s3 without bucket",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":         "test",
+					"api_key":           "test",
+					"server_cores":      2,
+					"server_memory":     4,
+					"storage_capacity":  10,
+					"ssh_username":      "root",
+					"iso_file":          "builder_test.go",
+					"iso_upload_target": "s3",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "CD",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"base_template_uuid": "test",
+					"cd_files":           []string{"builder_test.go"},
+					"cd_content": map[string]string{
+						"user-data": "",
+					},
+					"cd_label": "cidata",
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "missing cd_files",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"base_template_uuid": "test",
+					"cd_files":           []string{"missing"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unattended install",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"isoimage_uuid":      "test",
+					"ssh_password":       "test",
+					"unattended_install": map[string]interface{}{"family": "ubuntu"},
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "unattended install without ISO image",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"base_template_uuid": "test",
+					"ssh_password":       "test",
+					"unattended_install": map[string]interface{}{"family": "ubuntu"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unattended install with unsupported family",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"isoimage_uuid":      "test",
+					"ssh_password":       "test",
+					"unattended_install": map[string]interface{}{"family": "arch"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unattended install without password and keys",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"isoimage_uuid":      "test",
+					"unattended_install": map[string]interface{}{"family": "debian"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unattended install with unsupported disk_layout",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"ssh_username":       "root",
+					"isoimage_uuid":      "test",
+					"ssh_password":       "test",
+					"unattended_install": map[string]interface{}{"family": "rhel", "disk_layout": "zfs"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "isolated",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"isoimage_uuid":    "test",
+					"communicator":     "none",
+					"isolated":         true,
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "isolated with ssh communicator",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"isoimage_uuid":    "test",
+					"ssh_username":     "root",
+					"isolated":         true,
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "isolated without ISO image",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"communicator":       "none",
+					"isolated":           true,
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "isolated with files",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":        "test",
+					"api_key":          "test",
+					"server_cores":     2,
+					"server_memory":    4,
+					"storage_capacity": 10,
+					"isoimage_uuid":    "test",
+					"communicator":     "none",
+					"files":            []string{"builder_test.go"},
+					"isolated":         true,
+				},
+			},
 			want:    nil,
 			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "no communicator",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"communicator":       "none",
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
 			wantErr: false,
 		},
 		{
-			name:   "bad type",
+			name:   "no communicator with post_reboot_boot_command",
 			fields: fields{},
 			args: args{
 				raws: map[string]interface{}{
-					"api_token":          []string{},
+					"api_token":                "test",
+					"api_key":                  "test",
+					"server_cores":             2,
+					"server_memory":            4,
+					"storage_capacity":         10,
+					"isoimage_uuid":            "test",
+					"communicator":             "none",
+					"post_reboot_boot_command": []string{"<enter>"},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "shutdown_command",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
 					"api_key":            "test",
 					"server_cores":       2,
 					"server_memory":      4,
 					"storage_capacity":   10,
 					"base_template_uuid": "test",
 					"ssh_username":       "root",
+					"shutdown_command":   "sudo poweroff",
+					"shutdown_timeout":   "10m",
+					"shutdown_fallback":  "none",
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "shutdown_command without communicator",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"communicator":       "none",
+					"shutdown_command":   "sudo poweroff",
 				},
 			},
 			want:    nil,
@@ -60,7 +657,7 @@ func TestBuilder_Prepare(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:   "invalid key",
+			name:   "shutdown_fallback none without shutdown_command",
 			fields: fields{},
 			args: args{
 				raws: map[string]interface{}{
@@ -71,7 +668,176 @@ func TestBuilder_Prepare(t *testing.T) {
 					"storage_capacity":   10,
 					"base_template_uuid": "test",
 					"ssh_username":       "root",
-					"invalid_key":        "",
+					"shutdown_fallback":  "none",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unsupported shutdown_fallback",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"shutdown_fallback":  "halt",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "check_snapshot without skip_create_template",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"check_snapshot":     true,
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "unsupported artifact_type",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"artifact_type":      "image",
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "template_retention with artifact_type storage",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"artifact_type":      "storage",
+					"template_retention": map[string]interface{}{
+						"name_prefix": "packer-",
+						"keep_latest": 3,
+					},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "skip_create_template with artifact_type snapshot",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":            "test",
+					"api_key":              "test",
+					"server_cores":         2,
+					"server_memory":        4,
+					"storage_capacity":     10,
+					"base_template_uuid":   "test",
+					"ssh_username":         "root",
+					"artifact_type":        "snapshot",
+					"skip_create_template": true,
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "export_s3",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"export_s3": map[string]interface{}{
+						"bucket":     "packer",
+						"access_key": "access",
+						"secret_key": "secret",
+					},
+				},
+			},
+			want:    generatedDataNames,
+			want1:   nil,
+			wantErr: false,
+		},
+		{
+			name:   "export_s3 without bucket",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"export_s3": map[string]interface{}{
+						"access_key": "access",
+						"secret_key": "secret",
+					},
+				},
+			},
+			want:    nil,
+			want1:   nil,
+			wantErr: true,
+		},
+		{
+			name:   "export_s3 with artifact_type storage",
+			fields: fields{},
+			args: args{
+				raws: map[string]interface{}{
+					"api_token":          "test",
+					"api_key":            "test",
+					"server_cores":       2,
+					"server_memory":      4,
+					"storage_capacity":   10,
+					"base_template_uuid": "test",
+					"ssh_username":       "root",
+					"artifact_type":      "storage",
+					"export_s3": map[string]interface{}{
+						"bucket":     "packer",
+						"access_key": "access",
+						"secret_key": "secret",
+					},
 				},
 			},
 			want:    nil,
@@ -99,3 +865,228 @@ func TestBuilder_Prepare(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilder_steps(t *testing.T) {
+	tests := []struct {
+		name              string
+		config            Config
+		wantNetworks      int
+		wantCommunicators int
+		wantTemplates     int
+	}{
+		{
+			name:              "ssh",
+			config:            Config{},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     4,
+		},
+		{
+			name:              "no communicator",
+			config:            Config{Comm: communicator.Config{Type: "none"}},
+			wantNetworks:      1,
+			wantCommunicators: 0,
+			wantTemplates:     4,
+		},
+		{
+			name:              "no communicator with unattended install",
+			config:            Config{Comm: communicator.Config{Type: "none"}, UnattendedInstall: &UnattendedInstallConfig{}},
+			wantNetworks:      4,
+			wantCommunicators: 0,
+			wantTemplates:     4,
+		},
+		{
+			name:              "isolated",
+			config:            Config{Comm: communicator.Config{Type: "none"}, Isolated: true},
+			wantNetworks:      0,
+			wantCommunicators: 0,
+			wantTemplates:     4,
+		},
+		{
+			name:              "skip create template",
+			config:            Config{SkipCreateTemplate: true},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     0,
+		},
+		{
+			name:              "skip create template with snapshot check",
+			config:            Config{SkipCreateTemplate: true, CheckSnapshot: true},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     1,
+		},
+		{
+			name:              "storage artifact",
+			config:            Config{ArtifactType: artifactTypeStorage},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     0,
+		},
+		{
+			name:              "snapshot artifact",
+			config:            Config{ArtifactType: artifactTypeSnapshot},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     1,
+		},
+		{
+			name:              "export",
+			config:            Config{ExportS3: &S3Config{}},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     5,
+		},
+		{
+			name:              "snapshot artifact with export",
+			config:            Config{ArtifactType: artifactTypeSnapshot, ExportS3: &S3Config{}},
+			wantNetworks:      4,
+			wantCommunicators: 5,
+			wantTemplates:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Builder{config: tt.config}
+			networks, communicators, templates := 0, 0, 0
+			for _, step := range b.steps(nil, &uiMock{}) {
+				switch step := step.(type) {
+				case *stepGetPublicNetwork, *stepCreateIPAddr, *stepLinkServerIPAddr, *stepLinkServerPublicNetwork:
+					networks++
+				case *stepFindExistingTemplates, *stepCreateSnapshot, *stepExportSnapshot, *stepCreateTemplate, *stepRemoveOldTemplates:
+					templates++
+				case *stepCreateSSHKey, *communicator.StepConnect, *commonsteps.StepProvision:
+					communicators++
+				case *StepVNCConnect:
+					if step.postReboot {
+						communicators++
+					}
+				case *StepExecuteBootCommand:
+					if step.postReboot {
+						communicators++
+					}
+				}
+			}
+			if networks != tt.wantNetworks {
+				t.Errorf("steps() contains %d network steps, want %d", networks, tt.wantNetworks)
+			}
+			if communicators != tt.wantCommunicators {
+				t.Errorf("steps() contains %d communicator steps, want %d", communicators, tt.wantCommunicators)
+			}
+			if templates != tt.wantTemplates {
+				t.Errorf("steps() contains %d template steps, want %d", templates, tt.wantTemplates)
+			}
+		})
+	}
+}
+
+// artifactClientMock has the storages in the location "de/fra".
+type artifactClientMock struct {
+	TemplateOperatorMock
+	StorageOperatorMock
+	SnapshotOperatorMock
+	gsclient.LocationOperator
+}
+
+func (m artifactClientMock) GetStorage(ctx context.Context, id string) (gsclient.Storage, error) {
+	return gsclient.Storage{Properties: gsclient.StorageProperties{ObjectUUID: id, LocationUUID: "location"}}, nil
+}
+
+func (m artifactClientMock) GetLocation(ctx context.Context, id string) (gsclient.Location, error) {
+	return gsclient.Location{Properties: gsclient.LocationProperties{ObjectUUID: id, Name: "de/fra"}}, nil
+}
+
+func TestBuilder_artifact_secondaryStorage(t *testing.T) {
+	tests := []struct {
+		name             string
+		artifactType     string
+		wantStorageUUID  string
+		wantSnapshotUUID string
+	}{
+		{
+			name:            "storage artifact",
+			artifactType:    artifactTypeStorage,
+			wantStorageUUID: "secondary",
+		},
+		{
+			name:             "snapshot artifact",
+			artifactType:     artifactTypeSnapshot,
+			wantStorageUUID:  "secondary",
+			wantSnapshotUUID: "snapshot",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := produceTestConfig(map[string]interface{}{
+				"secondary_storage": true,
+				"artifact_type":     tt.artifactType,
+			})
+			b := &Builder{config: *c}
+			// The boot and secondary storage steps record the storage of the
+			// build output
+			state := StateBagMock{state: map[string]interface{}{
+				"boot_storage_uuid":      "boot",
+				"secondary_storage_uuid": "secondary",
+				"artifact_storage_uuid":  "secondary",
+				"snapshot_uuid":          "snapshot",
+			}}
+			artifact := b.artifact(artifactClientMock{}, state)
+			if artifact.StorageUUID != tt.wantStorageUUID || artifact.SnapshotUUID != tt.wantSnapshotUUID {
+				t.Errorf("artifact() = storage %v, snapshot %v, want storage %v, snapshot %v",
+					artifact.StorageUUID, artifact.SnapshotUUID, tt.wantStorageUUID, tt.wantSnapshotUUID)
+			}
+			if artifact.LocationUUID != "location" || artifact.LocationName != "de/fra" {
+				t.Errorf("artifact() = location %v (%v), want location de/fra (location)",
+					artifact.LocationName, artifact.LocationUUID)
+			}
+			if got := artifact.State("storage_uuid"); got != tt.wantStorageUUID {
+				t.Errorf("State(storage_uuid) = %v, want %v", got, tt.wantStorageUUID)
+			}
+			// Only the secondary storage is kept
+			if keepsStorage(c, state, "boot") {
+				t.Errorf("keepsStorage(boot) = true, want false")
+			}
+			if !keepsStorage(c, state, "secondary") {
+				t.Errorf("keepsStorage(secondary) = false, want true")
+			}
+		})
+	}
+}
+
+func TestBuilder_artifact_missingState(t *testing.T) {
+	tests := []struct {
+		name         string
+		artifactType string
+		state        map[string]interface{}
+	}{
+		{
+			name:         "storage artifact",
+			artifactType: artifactTypeStorage,
+			state:        map[string]interface{}{},
+		},
+		{
+			name:         "snapshot artifact without storage",
+			artifactType: artifactTypeSnapshot,
+			state:        map[string]interface{}{"snapshot_uuid": "snapshot"},
+		},
+		{
+			name:         "snapshot artifact without snapshot",
+			artifactType: artifactTypeSnapshot,
+			state:        map[string]interface{}{"artifact_storage_uuid": "storage"},
+		},
+		{
+			name:         "template artifact",
+			artifactType: artifactTypeTemplate,
+			state:        map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := produceTestConfig(map[string]interface{}{"artifact_type": tt.artifactType})
+			b := &Builder{config: *c}
+			if artifact := b.artifact(nil, StateBagMock{state: tt.state}); artifact != nil {
+				t.Errorf("artifact() = %+v, want nil", artifact)
+			}
+		})
+	}
+}
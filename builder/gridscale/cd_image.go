@@ -0,0 +1,592 @@
+package gridscale
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// This file writes ISO 9660 images with Joliet extensions, which are used as
+// config drives. The primary volume holds upper case, restricted names, the
+// Joliet volume holds the original names. Both share the file data.
+
+const (
+	cdSectorSize = 2048
+	// cdSystemAreaSectors is the number of sectors before the volume
+	// descriptors.
+	cdSystemAreaSectors = 16
+	// cdMaxJolietNameLen is the maximum number of UCS-2 characters of a
+	// Joliet name.
+	cdMaxJolietNameLen = 64
+	// cdMaxNameLen is the maximum length of a primary volume name.
+	cdMaxNameLen = 30
+	// defaultCDLabel is the label of a CD if cd_label is not set.
+	defaultCDLabel = "packer"
+)
+
+// cdFile is a file of a CD, either read from a local file or given by its
+// content.
+type cdFile struct {
+	// localPath is the path of the local file, if content is nil.
+	localPath string
+	content   []byte
+	size      int64
+	// extent is the first sector of the file data.
+	extent uint32
+}
+
+// cdDir is a directory of a CD.
+type cdDir struct {
+	name   string
+	parent *cdDir
+	dirs   map[string]*cdDir
+	files  map[string]*cdFile
+	// number is the directory number in the path tables.
+	number uint16
+	// The extents and sizes of the directory in the primary and the Joliet
+	// volume.
+	extent, jolietExtent uint32
+	size, jolietSize     uint32
+	// The names of the directory's children in the primary volume.
+	isoNames map[string]string
+}
+
+func newCDDir(name string, parent *cdDir) *cdDir {
+	return &cdDir{
+		name:   name,
+		parent: parent,
+		dirs:   map[string]*cdDir{},
+		files:  map[string]*cdFile{},
+	}
+}
+
+// cdImage is the content of a CD.
+type cdImage struct {
+	label string
+	root  *cdDir
+}
+
+func newCDImage(label string) *cdImage {
+	if label == "" {
+		label = defaultCDLabel
+	}
+	return &cdImage{label: label, root: newCDDir("", nil)}
+}
+
+// add adds a file at the slash separated path p, creating its parent
+// directories. A file added twice is replaced.
+func (img *cdImage) add(p string, f *cdFile) error {
+	parts := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	if parts[0] == "" {
+		return fmt.Errorf("invalid CD path %q", p)
+	}
+	dir := img.root
+	for _, part := range parts[:len(parts)-1] {
+		if _, ok := dir.files[part]; ok {
+			return fmt.Errorf("CD path %q is a file", part)
+		}
+		if dir.dirs[part] == nil {
+			dir.dirs[part] = newCDDir(part, dir)
+		}
+		dir = dir.dirs[part]
+	}
+	name := parts[len(parts)-1]
+	if _, ok := dir.dirs[name]; ok {
+		return fmt.Errorf("CD path %q is a directory", p)
+	}
+	dir.files[name] = f
+	return nil
+}
+
+// addContent adds a file with the given content.
+func (img *cdImage) addContent(p string, content []byte) error {
+	return img.add(p, &cdFile{content: content, size: int64(len(content))})
+}
+
+// addLocalPath adds a local file or directory. A file is added to the root
+// of the CD, a directory is added recursively with its name.
+func (img *cdImage) addLocalPath(localPath string) error {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return img.add(fi.Name(), &cdFile{localPath: localPath, size: fi.Size()})
+	}
+	parent := path.Dir(toSlash(localPath))
+	return walkLocalDir(localPath, func(p string, fi os.FileInfo) error {
+		rel := strings.TrimPrefix(toSlash(p), parent+"/")
+		if parent == "." {
+			rel = toSlash(p)
+		}
+		return img.add(rel, &cdFile{localPath: p, size: fi.Size()})
+	})
+}
+
+// walkLocalDir calls fn for every regular file below dir. Symbolic links are
+// followed.
+func walkLocalDir(dir string, fn func(p string, fi os.FileInfo) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := dir + string(os.PathSeparator) + entry.Name()
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = walkLocalDir(p, fn)
+		} else if fi.Mode().IsRegular() {
+			err = fn(p, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toSlash(p string) string {
+	return strings.ReplaceAll(p, string(os.PathSeparator), "/")
+}
+
+// dirs returns all directories in the order of the path tables: by level,
+// then by parent, then by name.
+func (img *cdImage) dirs() []*cdDir {
+	dirs := []*cdDir{img.root}
+	for i := 0; i < len(dirs); i++ {
+		dirs[i].number = uint16(i + 1)
+		names := make([]string, 0, len(dirs[i].dirs))
+		for name := range dirs[i].dirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dirs = append(dirs, dirs[i].dirs[name])
+		}
+	}
+	return dirs
+}
+
+// isoName converts a name to the restricted character set of the primary
+// volume.
+func isoName(name string, dir bool) string {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 && !dir {
+		base, ext = name[:i], name[i+1:]
+	}
+	conv := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			default:
+				return '_'
+			}
+		}, s)
+	}
+	base, ext = conv(base), conv(ext)
+	if dir {
+		if len(base) > cdMaxNameLen {
+			base = base[:cdMaxNameLen]
+		}
+		return base
+	}
+	if len(ext) > 3 {
+		ext = ext[:3]
+	}
+	if len(base)+1+len(ext) > cdMaxNameLen {
+		base = base[:cdMaxNameLen-1-len(ext)]
+	}
+	return base + "." + ext
+}
+
+// assignISONames assigns unique primary volume names to the children of dir.
+func assignISONames(dir *cdDir) {
+	dir.isoNames = map[string]string{}
+	used := map[string]bool{}
+	names := []string{}
+	for name := range dir.dirs {
+		names = append(names, name)
+	}
+	for name := range dir.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, isDir := dir.dirs[name]
+		isoN := isoName(name, isDir)
+		base, ext := isoN, ""
+		if i := strings.LastIndex(isoN, "."); i >= 0 && !isDir {
+			base, ext = isoN[:i], isoN[i:]
+		}
+		// Make the name unique by replacing the end of its base name
+		for i := 1; used[isoN]; i++ {
+			suffix := fmt.Sprintf("_%d", i)
+			b := base
+			if len(b)+len(suffix)+len(ext) > cdMaxNameLen {
+				b = b[:cdMaxNameLen-len(suffix)-len(ext)]
+			}
+			isoN = b + suffix + ext
+		}
+		used[isoN] = true
+		dir.isoNames[name] = isoN
+	}
+}
+
+// jolietName encodes a name as UCS-2 big endian.
+func jolietName(name string) []byte {
+	u := utf16.Encode([]rune(name))
+	if len(u) > cdMaxJolietNameLen {
+		u = u[:cdMaxJolietNameLen]
+	}
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// cdDirEntry is an entry of a directory extent.
+type cdDirEntry struct {
+	id     []byte
+	extent uint32
+	size   uint32
+	dir    bool
+}
+
+// dirEntries returns the entries of dir in the primary or the Joliet volume,
+// sorted by identifier and preceded by "." and "..".
+func dirEntries(dir *cdDir, joliet bool) []cdDirEntry {
+	self := cdDirEntry{id: []byte{0}, dir: true}
+	parent := cdDirEntry{id: []byte{1}, dir: true}
+	p := dir.parent
+	if p == nil {
+		p = dir
+	}
+	if joliet {
+		self.extent, self.size = dir.jolietExtent, dir.jolietSize
+		parent.extent, parent.size = p.jolietExtent, p.jolietSize
+	} else {
+		self.extent, self.size = dir.extent, dir.size
+		parent.extent, parent.size = p.extent, p.size
+	}
+	entries := []cdDirEntry{}
+	for name, d := range dir.dirs {
+		e := cdDirEntry{dir: true, extent: d.extent, size: d.size, id: []byte(dir.isoNames[name])}
+		if joliet {
+			e.extent, e.size, e.id = d.jolietExtent, d.jolietSize, jolietName(name)
+		}
+		entries = append(entries, e)
+	}
+	for name, f := range dir.files {
+		e := cdDirEntry{extent: f.extent, size: uint32(f.size), id: []byte(dir.isoNames[name] + ";1")}
+		if joliet {
+			e.id = append(jolietName(name), 0, ';', 0, '1')
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].id, entries[j].id) < 0
+	})
+	return append([]cdDirEntry{self, parent}, entries...)
+}
+
+// dirRecordLen returns the length of a directory record with an identifier
+// of n bytes.
+func dirRecordLen(n int) int {
+	return 33 + n + (n+1)%2
+}
+
+// dirExtentSize returns the size of the extent of the directory entries.
+// Records do not cross sector boundaries.
+func dirExtentSize(entries []cdDirEntry) uint32 {
+	size := 0
+	for _, e := range entries {
+		n := dirRecordLen(len(e.id))
+		if size%cdSectorSize+n > cdSectorSize {
+			size += cdSectorSize - size%cdSectorSize
+		}
+		size += n
+	}
+	return uint32(sectors(int64(size)) * cdSectorSize)
+}
+
+// sectors returns the number of sectors of size bytes.
+func sectors(size int64) uint32 {
+	return uint32((size + cdSectorSize - 1) / cdSectorSize)
+}
+
+// pathTableSize returns the size of the path table of dirs in the primary or
+// the Joliet volume.
+func pathTableSize(dirs []*cdDir, joliet bool) uint32 {
+	size := 0
+	for _, d := range dirs {
+		n := len(pathTableID(d, joliet))
+		size += 8 + n + n%2
+	}
+	return uint32(size)
+}
+
+func pathTableID(d *cdDir, joliet bool) []byte {
+	switch {
+	case d.parent == nil:
+		return []byte{0}
+	case joliet:
+		return jolietName(d.name)
+	default:
+		return []byte(d.parent.isoNames[d.name])
+	}
+}
+
+// writeTo writes the image to w.
+func (img *cdImage) writeTo(w io.Writer, now time.Time) error {
+	dirs := img.dirs()
+	for _, d := range dirs {
+		assignISONames(d)
+	}
+	// Lay out the path tables, the directories and the file data
+	pathTableLen := pathTableSize(dirs, false)
+	jolietPathTableLen := pathTableSize(dirs, true)
+	next := uint32(cdSystemAreaSectors + 3)
+	lPathTable := next
+	next += sectors(int64(pathTableLen))
+	mPathTable := next
+	next += sectors(int64(pathTableLen))
+	jolietLPathTable := next
+	next += sectors(int64(jolietPathTableLen))
+	jolietMPathTable := next
+	next += sectors(int64(jolietPathTableLen))
+	// The sizes of the directories don't depend on the extents
+	for _, d := range dirs {
+		d.size = dirExtentSize(dirEntries(d, false))
+		d.jolietSize = dirExtentSize(dirEntries(d, true))
+	}
+	for _, d := range dirs {
+		d.extent = next
+		next += d.size / cdSectorSize
+	}
+	for _, d := range dirs {
+		d.jolietExtent = next
+		next += d.jolietSize / cdSectorSize
+	}
+	files := []*cdFile{}
+	for _, d := range dirs {
+		names := make([]string, 0, len(d.files))
+		for name := range d.files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := d.files[name]
+			f.extent = next
+			next += sectors(f.size)
+			files = append(files, f)
+		}
+	}
+	volumeSize := next
+
+	buf := &bytes.Buffer{}
+	buf.Write(make([]byte, cdSystemAreaSectors*cdSectorSize))
+	buf.Write(img.volumeDescriptor(false, volumeSize, pathTableLen, lPathTable, mPathTable, dirs[0], now))
+	buf.Write(img.volumeDescriptor(true, volumeSize, jolietPathTableLen, jolietLPathTable, jolietMPathTable, dirs[0], now))
+	terminator := make([]byte, cdSectorSize)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001\x01")
+	buf.Write(terminator)
+	for _, t := range []struct {
+		joliet bool
+		order  binary.ByteOrder
+	}{
+		{false, binary.LittleEndian},
+		{false, binary.BigEndian},
+		{true, binary.LittleEndian},
+		{true, binary.BigEndian},
+	} {
+		writePadded(buf, pathTable(dirs, t.joliet, t.order))
+	}
+	for _, joliet := range []bool{false, true} {
+		for _, d := range dirs {
+			buf.Write(dirExtent(dirEntries(d, joliet), now))
+		}
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := f.writeTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTo writes the file data padded to a full sector.
+func (f *cdFile) writeTo(w io.Writer) error {
+	var r io.Reader = bytes.NewReader(f.content)
+	if f.content == nil {
+		lf, err := os.Open(f.localPath)
+		if err != nil {
+			return err
+		}
+		defer lf.Close()
+		r = lf
+	}
+	n, err := io.CopyN(w, r, f.size)
+	if err != nil {
+		return fmt.Errorf("writing %s to CD: %d of %d bytes written: %s", f.localPath, n, f.size, err)
+	}
+	_, err = w.Write(make([]byte, int64(sectors(f.size))*cdSectorSize-f.size))
+	return err
+}
+
+func writePadded(buf *bytes.Buffer, b []byte) {
+	buf.Write(b)
+	buf.Write(make([]byte, int64(sectors(int64(len(b))))*cdSectorSize-int64(len(b))))
+}
+
+// pathTable returns the path table of dirs in the given byte order.
+func pathTable(dirs []*cdDir, joliet bool, order binary.ByteOrder) []byte {
+	buf := &bytes.Buffer{}
+	for _, d := range dirs {
+		id := pathTableID(d, joliet)
+		extent := d.extent
+		if joliet {
+			extent = d.jolietExtent
+		}
+		parent := uint16(1)
+		if d.parent != nil {
+			parent = d.parent.number
+		}
+		rec := make([]byte, 8)
+		rec[0] = byte(len(id))
+		order.PutUint32(rec[2:], extent)
+		order.PutUint16(rec[6:], parent)
+		buf.Write(rec)
+		buf.Write(id)
+		if len(id)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// dirExtent returns the directory extent of the entries.
+func dirExtent(entries []cdDirEntry, now time.Time) []byte {
+	size := dirExtentSize(entries)
+	b := make([]byte, 0, size)
+	for _, e := range entries {
+		rec := dirRecord(e, now)
+		if len(b)%cdSectorSize+len(rec) > cdSectorSize {
+			b = append(b, make([]byte, cdSectorSize-len(b)%cdSectorSize)...)
+		}
+		b = append(b, rec...)
+	}
+	return append(b, make([]byte, int(size)-len(b))...)
+}
+
+// dirRecord returns the directory record of e.
+func dirRecord(e cdDirEntry, now time.Time) []byte {
+	rec := make([]byte, dirRecordLen(len(e.id)))
+	rec[0] = byte(len(rec))
+	putBothUint32(rec[2:], e.extent)
+	putBothUint32(rec[10:], e.size)
+	copy(rec[18:], recordingTime(now))
+	if e.dir {
+		rec[25] = 2
+	}
+	putBothUint16(rec[28:], 1)
+	rec[32] = byte(len(e.id))
+	copy(rec[33:], e.id)
+	return rec
+}
+
+// volumeDescriptor returns the primary or the Joliet supplementary volume
+// descriptor.
+func (img *cdImage) volumeDescriptor(joliet bool, volumeSize, pathTableLen, lPathTable, mPathTable uint32, root *cdDir, now time.Time) []byte {
+	vd := make([]byte, cdSectorSize)
+	vd[0] = 1
+	if joliet {
+		vd[0] = 2
+	}
+	copy(vd[1:], "CD001\x01")
+	label := strings.ToUpper(img.label)
+	text := func(b []byte, s string) {
+		for i := range b {
+			b[i] = ' '
+		}
+		copy(b, s)
+	}
+	if joliet {
+		label = img.label
+		text = func(b []byte, s string) {
+			for i := 0; i+1 < len(b); i += 2 {
+				b[i], b[i+1] = 0, ' '
+			}
+			copy(b, jolietName(s))
+		}
+		// UCS-2 level 3
+		copy(vd[88:], "%/E")
+	}
+	text(vd[8:40], "")
+	text(vd[40:72], label)
+	putBothUint32(vd[80:], volumeSize)
+	putBothUint16(vd[120:], 1)
+	putBothUint16(vd[124:], 1)
+	putBothUint16(vd[128:], cdSectorSize)
+	putBothUint32(vd[132:], pathTableLen)
+	binary.LittleEndian.PutUint32(vd[140:], lPathTable)
+	binary.BigEndian.PutUint32(vd[148:], mPathTable)
+	rootEntry := cdDirEntry{id: []byte{0}, dir: true, extent: root.extent, size: root.size}
+	if joliet {
+		rootEntry.extent, rootEntry.size = root.jolietExtent, root.jolietSize
+	}
+	copy(vd[156:190], dirRecord(rootEntry, now))
+	for _, field := range [][2]int{{190, 318}, {318, 446}, {446, 574}, {702, 739}, {739, 776}, {776, 813}} {
+		text(vd[field[0]:field[1]], "")
+	}
+	text(vd[574:702], "PACKER")
+	copy(vd[813:], volumeTime(now))
+	copy(vd[830:], volumeTime(now))
+	copy(vd[847:], "0000000000000000")
+	copy(vd[864:], "0000000000000000")
+	vd[881] = 1
+	return vd
+}
+
+// recordingTime returns the 7 byte time of a directory record.
+func recordingTime(t time.Time) []byte {
+	t = t.UTC()
+	return []byte{
+		byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()),
+		byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0,
+	}
+}
+
+// volumeTime returns the 17 byte time of a volume descriptor.
+func volumeTime(t time.Time) []byte {
+	t = t.UTC()
+	return append([]byte(fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d",
+		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e7)), 0)
+}
+
+func putBothUint16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBothUint32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
@@ -0,0 +1,166 @@
+package gridscale
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// readTestCDImage returns the label and the files of the primary or the
+// Joliet volume of an ISO 9660 image.
+func readTestCDImage(t *testing.T, img []byte, joliet bool) (string, map[string]string) {
+	sector := func(n uint32) []byte {
+		return img[n*cdSectorSize : (n+1)*cdSectorSize]
+	}
+	vd := sector(cdSystemAreaSectors)
+	decode := func(b []byte) string { return strings.TrimRight(string(b), " ") }
+	if joliet {
+		vd = sector(cdSystemAreaSectors + 1)
+		decode = func(b []byte) string {
+			u := make([]uint16, len(b)/2)
+			for i := range u {
+				u[i] = binary.BigEndian.Uint16(b[2*i:])
+			}
+			return strings.TrimRight(string(utf16.Decode(u)), " ")
+		}
+	}
+	if string(vd[1:6]) != "CD001" {
+		t.Fatalf("volume descriptor = %q, want CD001", vd[1:6])
+	}
+	files := map[string]string{}
+	var walkDir func(prefix string, extent, size uint32)
+	walkDir = func(prefix string, extent, size uint32) {
+		data := img[extent*cdSectorSize : extent*cdSectorSize+size]
+		for off := 0; off < len(data); {
+			n := int(data[off])
+			if n == 0 {
+				// Records do not cross sector boundaries
+				off = (off/cdSectorSize + 1) * cdSectorSize
+				continue
+			}
+			rec := data[off : off+n]
+			off += n
+			id := rec[33 : 33+int(rec[32])]
+			if len(id) == 1 && id[0] <= 1 {
+				continue
+			}
+			name := strings.TrimSuffix(decode(id), ";1")
+			recExtent := binary.LittleEndian.Uint32(rec[2:])
+			recSize := binary.LittleEndian.Uint32(rec[10:])
+			if rec[25]&2 != 0 {
+				walkDir(prefix+name+"/", recExtent, recSize)
+				continue
+			}
+			files[prefix+name] = string(img[recExtent*cdSectorSize : recExtent*cdSectorSize+recSize])
+		}
+	}
+	root := vd[156:190]
+	walkDir("", binary.LittleEndian.Uint32(root[2:]), binary.LittleEndian.Uint32(root[10:]))
+	return decode(vd[40:72]), files
+}
+
+func Test_cdImage_writeTo(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "drivers", "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"autounattend.xml":    "<unattend/>",
+		"drivers/net/e1.inf":  "inf",
+		"drivers/readme.txt":  "readme",
+		"drivers/net/big.bin": strings.Repeat("x", 3*cdSectorSize+1),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	img := newCDImage("cidata")
+	for _, p := range []string{filepath.Join(dir, "autounattend.xml"), filepath.Join(dir, "drivers")} {
+		if err := img.addLocalPath(p); err != nil {
+			t.Fatalf("addLocalPath() error = %v", err)
+		}
+	}
+	for p, content := range map[string]string{
+		"user-data":          "#cloud-config",
+		"meta-data":          "",
+		"Meta_Data":          "clash",
+		"autounattend.xml":   "<replaced/>",
+		"openstack/latest/x": strings.Repeat("y", 100),
+	} {
+		if err := img.addContent(p, []byte(content)); err != nil {
+			t.Fatalf("addContent() error = %v", err)
+		}
+	}
+	// Many files do not fit into a single directory sector
+	for i := 0; i < 100; i++ {
+		if err := img.addContent(filepath.ToSlash(filepath.Join("many", strings.Repeat("f", 40)+string(rune('a'+i%26))+string(rune('a'+i/26)))), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := img.writeTo(buf, time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)); err != nil {
+		t.Fatalf("writeTo() error = %v", err)
+	}
+	if buf.Len()%cdSectorSize != 0 {
+		t.Errorf("image size = %d, want a multiple of %d", buf.Len(), cdSectorSize)
+	}
+
+	label, files := readTestCDImage(t, buf.Bytes(), true)
+	if label != "cidata" {
+		t.Errorf("Joliet label = %q, want %q", label, "cidata")
+	}
+	want := map[string]string{
+		"autounattend.xml":    "<replaced/>",
+		"drivers/net/e1.inf":  "inf",
+		"drivers/readme.txt":  "readme",
+		"drivers/net/big.bin": strings.Repeat("x", 3*cdSectorSize+1),
+		"user-data":           "#cloud-config",
+		"meta-data":           "",
+		"Meta_Data":           "clash",
+		"openstack/latest/x":  strings.Repeat("y", 100),
+	}
+	for i := 0; i < 100; i++ {
+		want["many/"+strings.Repeat("f", 40)+string(rune('a'+i%26))+string(rune('a'+i/26))] = string([]byte{byte(i)})
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Joliet files = %v, want %v", files, want)
+	}
+
+	label, files = readTestCDImage(t, buf.Bytes(), false)
+	if label != "CIDATA" {
+		t.Errorf("label = %q, want %q", label, "CIDATA")
+	}
+	for _, name := range []string{"AUTOUNATTEND.XML", "DRIVERS/NET/E1.INF", "USER_DATA.", "META_DATA.", "META_DATA_1.", "OPENSTACK/LATEST/X."} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("file %s is missing, files = %v", name, files)
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("got %d files, want %d", len(files), len(want))
+	}
+}
+
+func Test_cdImage_add(t *testing.T) {
+	img := newCDImage("")
+	if img.label != defaultCDLabel {
+		t.Errorf("label = %q, want %q", img.label, defaultCDLabel)
+	}
+	if err := img.addContent("a/b", nil); err != nil {
+		t.Fatalf("addContent() error = %v", err)
+	}
+	if err := img.addContent("a", nil); err == nil {
+		t.Error("adding a file over a directory, want error")
+	}
+	if err := img.addContent("a/b/c", nil); err == nil {
+		t.Error("adding a file below a file, want error")
+	}
+	if err := img.addContent("/", nil); err == nil {
+		t.Error("adding the root, want error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
 )
 
 const CharSetAlphaNum = "abcdefghijklmnopqrstuvwxyz012346789"
@@ -56,3 +57,32 @@ func containsInt(arr []int, target int) bool {
 	}
 	return false
 }
+
+// containsString check if a string array contains a specific string.
+func containsString(arr []string, target string) bool {
+	for _, a := range arr {
+		if a == target {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsStorage reports whether the storage is kept as the artifact, or for
+// the snapshot artifact taken of it. The storage of the artifact is the
+// secondary storage if it is used, otherwise the boot storage.
+func keepsStorage(c *Config, state multistep.StateBag, storageUUID string) bool {
+	if c.ArtifactType != artifactTypeStorage && c.ArtifactType != artifactTypeSnapshot {
+		return false
+	}
+	artifactStorageUUID, _ := state.Get("artifact_storage_uuid").(string)
+	return artifactStorageUUID == storageUUID && buildSucceeded(state)
+}
+
+// buildSucceeded reports whether all steps have run, i.e. the build has
+// neither been halted nor cancelled.
+func buildSucceeded(state multistep.StateBag) bool {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	return !cancelled && !halted
+}
@@ -1,5 +1,5 @@
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,ISOImageConfig,S3Config,UnattendedInstallConfig,TemplateRetentionConfig
 
 package gridscale
 
@@ -7,21 +7,32 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/shutdowncommand"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 	"github.com/hashicorp/packer-plugin-sdk/uuid"
 )
 
 type Config struct {
-	common.PackerConfig `mapstructure:",squash"`
-	Comm                communicator.Config `mapstructure:",squash"`
+	common.PackerConfig            `mapstructure:",squash"`
+	Comm                           communicator.Config `mapstructure:",squash"`
+	shutdowncommand.ShutdownConfig `mapstructure:",squash"`
+	// How to shut down the server if no `shutdown_command` is set, or if the
+	// server is still powered on after `shutdown_timeout`. `api_stop` shuts
+	// the server down through the API (ACPI) and turns it off if it is still
+	// running after `shutdown_timeout`, `api` only shuts it down through the
+	// API, `stop` turns it off right away and `none` fails the build.
+	// Default: `api_stop`.
+	ShutdownFallback string `mapstructure:"shutdown_fallback" required:"false"`
 	// The client TOKEN to use to access your account. Environment variable `GRIDSCALE_TOKEN` can be set instead.
 	APIToken string `mapstructure:"api_token" required:"true"`
 	// The client KEY to use to access your account. Environment variable `GRIDSCALE_UUID` can be set instead.
@@ -30,8 +41,53 @@ type Config struct {
 	APIURL string `mapstructure:"api_url" required:"false"`
 	// APIRequestHeaders is for debug purpose only. Format: "key1:val1,key2:val2"
 	APIRequestHeaders string `mapstructure:"api_request_headers" required:"false"`
-	// The name of the new template.
+	// The name of the new template. It names the storage or the snapshot
+	// with `artifact_type` `storage` or `snapshot`.
 	TemplateName string `mapstructure:"template_name" required:"false"`
+	// Replace the existing private templates named `template_name`. They are
+	// removed after the new template has been created. It is enabled by
+	// `packer build -force` as well.
+	ForceDeregister bool `mapstructure:"force_deregister" required:"false"`
+	// Replace the existing templates even if storages have been created from
+	// them. Otherwise, the build fails before creating the server.
+	ForceDeleteInUse bool `mapstructure:"force_delete_in_use" required:"false"`
+	// Labels of the new template, e.g. to select the templates of a build
+	// with `template_retention`.
+	TemplateLabels []string `mapstructure:"template_labels" required:"false"`
+	// Removes the older templates of the build after creating the new
+	// template. See [Template Retention](#template-retention).
+	TemplateRetention *TemplateRetentionConfig `mapstructure:"template_retention" required:"false"`
+	// Do not create a template, e.g. for validating the provisioning in
+	// pull requests. The server is still shut down. `force_deregister` and
+	// `template_retention` are ignored.
+	SkipCreateTemplate bool `mapstructure:"skip_create_template" required:"false"`
+	// Take a snapshot of the storage and delete it right away, to check that
+	// the storage can be snapshotted. It requires `skip_create_template`.
+	CheckSnapshot bool `mapstructure:"check_snapshot" required:"false"`
+	// The kind of object produced by the build: `template`, `storage` to keep
+	// the boot storage, or `snapshot` to keep a snapshot of the boot storage
+	// together with the storage. With `secondary_storage`, the secondary
+	// storage is kept instead of the boot storage. `force_deregister` only applies to
+	// templates. Default: `template`.
+	ArtifactType string `mapstructure:"artifact_type" required:"false"`
+	// Write a JSON manifest of the build to this path when the build
+	// finishes, e.g. for CI. It holds the artifact, the source, the temporary
+	// resources with their creation and deletion times, the step durations
+	// and the plugin version. It is a file of the artifact. The step
+	// durations are not recorded with `-on-error` other than `cleanup`.
+	ManifestOutput string `mapstructure:"manifest_output" required:"false"`
+	// Export the snapshot of the build to this S3-compatible object storage
+	// bucket, e.g. to archive the image outside of gridscale. The API exports
+	// the snapshot as a gzipped image. A `.sha256` sidecar object with the
+	// SHA-256 checksum of the export is written next to it. It cannot be used
+	// with `skip_create_template` and artifact_type `storage`. See
+	// [S3](#s3).
+	ExportS3 *S3Config `mapstructure:"export_s3" required:"false"`
+	// The object key of the export. Default: `<template_name>.gz`. An
+	// existing object with the key and its `.sha256` sidecar are replaced.
+	ExportS3Key string `mapstructure:"export_s3_key" required:"false"`
+	// How long the export may take. Default: `2h`.
+	ExportTimeout time.Duration `mapstructure:"export_timeout" required:"false"`
 	// Name of the host.
 	Hostname string `mapstructure:"hostname" required:"false"`
 	// Name of the server used for producing the template.
@@ -47,19 +103,72 @@ type Config struct {
 	// **NOTE**: If `secondary_storage=true`, the template will be built from the second storage.
 	SecondaryStorage bool `mapstructure:"secondary_storage" required:"false"`
 	// A pre-built template UUID. This template is used to produce another template. E.g: Ubuntu template.
-	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `base_template_uuid`.
+	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `iso_file`, `iso_images`, `base_template_uuid`.
 	BaseTemplateUUID string `mapstructure:"base_template_uuid" required:"false"`
 	// A pre-built ISO image is used by the given ISO image UUID. If IsoImageUUID is set, IsoImageURL is ignored.
-	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `base_template_uuid`.
+	// The server boots from this ISO image. It cannot be combined with `iso_images`.
+	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `iso_file`, `iso_images`, `base_template_uuid`.
 	IsoImageUUID string `mapstructure:"isoimage_uuid" required:"false"`
 	// An URL is used to download the image. If IsoImageUUID is set, IsoImageURL is ignored.
-	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `base_template_uuid`.
+	// The server boots from this ISO image. It cannot be combined with `iso_images`.
+	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `iso_file`, `iso_images`, `base_template_uuid`.
 	IsoImageURL string `mapstructure:"isoimage_url" required:"false"`
+	// The checksum of the ISO image downloaded from `isoimage_url`, in the
+	// `type:value` format, e.g. `sha256:0f3...`. The types `md5`, `sha1`,
+	// `sha256` and `sha512` are supported. If the type is omitted, it is
+	// guessed from the length of the value. The ISO image is downloaded
+	// locally to verify the checksum before it is imported. `none` skips
+	// the verification. It cannot be combined with `isoimage_uuid`.
+	ISOChecksum string `mapstructure:"iso_checksum" required:"false"`
+	// The path of a local ISO image. It is uploaded to `iso_upload_target`
+	// and the server boots from it. It cannot be combined with
+	// `isoimage_uuid`, `isoimage_url` or `iso_images`.
+	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `iso_file`, `iso_images`, `base_template_uuid`.
+	ISOFile string `mapstructure:"iso_file" required:"false"`
+	// The ISO images attached to the server, e.g. an installer and a driver
+	// ISO image. They are attached in the order of the list. See the ISO
+	// images section below.
+	// **NOTE**: One of these fields has to be set: `isoimage_uuid`, `isoimage_url`, `iso_file`, `iso_images`, `base_template_uuid`.
+	ISOImages []ISOImageConfig `mapstructure:"iso_images" required:"false"`
+	// If true, an ISO image downloaded from a URL is reused by later builds
+	// instead of being destroyed. An existing ISO image is reused if its
+	// source URL and checksum match and it is labeled as cached by a previous
+	// build. Every ISO image downloaded from a URL needs a checksum. The
+	// CD of `cd_files` and `cd_content` is never cached.
+	ISOCache bool `mapstructure:"iso_cache" required:"false"`
+	// Where local ISO images are uploaded to, so that gridscale can download
+	// them: `file_server` uploads them to the helper file server (see
+	// `files`), `s3` to the bucket of `iso_upload_s3`. Default:
+	// `file_server`. The uploads are removed after the build.
+	ISOUploadTarget string `mapstructure:"iso_upload_target" required:"false"`
+	// The S3-compatible object storage bucket local ISO images are uploaded
+	// to, if `iso_upload_target` is `s3`. See the S3 section below.
+	ISOUploadS3 S3Config `mapstructure:"iso_upload_s3" required:"false"`
+	// A list of files and directories to put onto a CD, e.g. an
+	// `autounattend.xml` file or the `user-data` and `meta-data` files of
+	// cloud-init. Files are put at the root of the CD, directories are copied
+	// recursively. File globbing is allowed. The ISO 9660 image with Joliet
+	// extensions is created on the Packer host, uploaded like `iso_file` and
+	// attached to the server next to the other ISO images.
+	CDFiles []string `mapstructure:"cd_files" required:"false"`
+	// Key/Values to add to the CD. The keys are the paths, the values the
+	// contents. They take precedence over files of `cd_files` with the same
+	// path.
+	CDContent map[string]string `mapstructure:"cd_content" required:"false"`
+	// The label of the CD, e.g. `cidata` for the cloud-init NoCloud data
+	// source. Default: `packer`.
+	CDLabel string `mapstructure:"cd_label" required:"false"`
+	// Generates the answer file of an unattended installation from the ISO
+	// image to boot from. See [Unattended Install](#unattended-install).
+	UnattendedInstall *UnattendedInstallConfig `mapstructure:"unattended_install" required:"false"`
 	// This is an array of commands to type when the server instance is first
 	// booted. The goal of these commands should be to type just enough to
 	// initialize the operating system installer. Special keys can be typed as
 	// well, and are covered in the section below on the boot command. If this
 	// is not specified, it is assumed the installer will start itself.
+	// The template variables `{{ .HTTPIP }}` and `{{ .HTTPPort }}` hold the
+	// address of the file server, `{{ .Name }}` the server's name and
+	// `{{ .SSHPublicKey }}` the public key of the temporary SSH key, if any.
 	BootCommand []string `mapstructure:"boot_command" required:"false"`
 	// The time to wait after booting the initial virtual machine before typing
 	// the `boot_command`. The value of this should be a duration. Examples are
@@ -70,15 +179,116 @@ type Config struct {
 	BootWait time.Duration `mapstructure:"boot_wait" required:"false"`
 	// Time in ms to wait between each key press
 	BootKeyInterval time.Duration `mapstructure:"boot_key_interval" required:"false"`
+	// How to detect the end of an ISO image installation, before the ISO
+	// image is removed and the server is booted from its disk. By default,
+	// this happens right after typing the `boot_command`. With `poweroff`,
+	// the builder waits for the installer to power off the server. It
+	// defaults to `poweroff` with `communicator = "none"`, then the server is
+	// not restarted before creating the template.
+	ISOInstallWait string `mapstructure:"iso_install_wait" required:"false"`
+	// The maximum time to wait for the server to power off, if
+	// `iso_install_wait` is `poweroff`. Default: `60m`.
+	InstallTimeout time.Duration `mapstructure:"install_timeout" required:"false"`
+	// An array of commands to type after the server has been restarted at the
+	// end of an ISO image installation, e.g. for a second stage installer
+	// prompt or for unlocking an encrypted disk. They are typed before
+	// connecting to the server with the communicator. It requires an ISO image
+	// to boot from.
+	PostRebootBootCommand []string `mapstructure:"post_reboot_boot_command" required:"false"`
+	// The time to wait after restarting the server before typing the
+	// `post_reboot_boot_command`. Default: `2m`.
+	PostRebootBootWait time.Duration `mapstructure:"post_reboot_boot_wait" required:"false"`
+	// The keyboard layout of the installer the `boot_command` is typed into.
+	// Each character is translated to the key combination producing it with
+	// that layout. One of `us`, `de`, `fr` or `ch`. Default: `us`.
+	// Characters on the additional key of ISO keyboards, e.g. `<`, `>` and
+	// `|` with the `de` layout, cannot be typed with a non-US layout, the
+	// build fails when the boot command contains them.
+	BootKeymap string `mapstructure:"boot_keymap" required:"false"`
+	// The password used to authenticate against the VNC server of the server's
+	// console. It is used for the standard VNC password challenge as well as for
+	// VeNCrypt authentication, whichever is offered by the VNC server.
+	VNCPassword string `mapstructure:"vnc_password" required:"false"`
+	// The username used for VeNCrypt `Plain` and `X509Plain` authentication.
+	VNCUsername string `mapstructure:"vnc_username" required:"false"`
+	// The IP address the local VNC proxy listens on. Default: "127.0.0.1".
+	VNCBindAddress string `mapstructure:"vnc_bind_address" required:"false"`
+	// The TCP port of a local VNC endpoint bridged to the server's console
+	// for the whole build, e.g. to watch an ISO installation with a desktop
+	// VNC viewer. The VNC viewer has to connect in shared mode, otherwise it
+	// disconnects the connection used for typing the `boot_command`.
+	// If this is not set, no local VNC endpoint is exposed.
+	VNCPort int `mapstructure:"vnc_port" required:"false"`
 	// A list of files' relative paths that need to be served on a HTTP server.
-	// Put this address ({{__HTTP__ADDRESS__}} is a placeholder, do not edit) http://{{__HTTP__ADDRESS__}}/path/to/file
-	// to `boot_command` to use http-served files in boot commands.
+	// Put this address http://{{ .HTTPIP }}:{{ .HTTPPort }}/path/to/file
+	// to `boot_command` to use http-served files in boot commands. The
+	// `{{__HTTP__ADDRESS__}}` placeholder is deprecated, but still replaced
+	// with the same address.
 	Files []string `mapstructure:"files" required:"false"`
-	ctx   interpolate.Context
+	// Builds without internet access. The server gets neither a public IP
+	// address nor a link to the public network. It requires an ISO image to
+	// boot from and `communicator = "none"`: the build installs from the ISO
+	// images, shuts the server down and creates the template. Install inputs
+	// can only be delivered through attached ISO images, e.g. `cd_files`, so
+	// `files`, `unattended_install` and ISO images uploaded to the file
+	// server are not supported.
+	Isolated bool `mapstructure:"isolated" required:"false"`
+	ctx      interpolate.Context
+	// unattendedFiles are the rendered answer files of unattended_install.
+	unattendedFiles map[string]string
+}
+
+// ISOImageConfig is an ISO image attached to the server during the build.
+// Exactly one of `uuid`, `url` and `file` has to be set.
+type ISOImageConfig struct {
+	// The UUID of an existing ISO image. It is kept after the build.
+	UUID string `mapstructure:"uuid" required:"false"`
+	// An URL to download the ISO image from. The ISO image is created for
+	// the build and destroyed afterwards, unless `iso_cache` is set.
+	URL string `mapstructure:"url" required:"false"`
+	// The checksum of the ISO image downloaded from `url`, in the same
+	// format as `iso_checksum`.
+	Checksum string `mapstructure:"checksum" required:"false"`
+	// The path of a local ISO image. It is uploaded to `iso_upload_target`
+	// for the build.
+	File string `mapstructure:"file" required:"false"`
+	// Whether the server boots from this ISO image. At most one ISO image can
+	// be the boot device. If none is, the server keeps booting from its disk.
+	Boot bool `mapstructure:"boot" required:"false"`
+	// cd marks the CD created from cd_files and cd_content.
+	cd bool
+}
+
+// isLocal reports whether the ISO image is created on the Packer host and
+// has to be uploaded.
+func (iso ISOImageConfig) isLocal() bool {
+	return iso.File != "" || iso.cd
+}
+
+// S3Config is an S3-compatible object storage bucket, e.g. a bucket of the
+// gridscale object storage.
+type S3Config struct {
+	// The endpoint of the object storage. Default: `https://gos3.io`.
+	Endpoint string `mapstructure:"endpoint" required:"false"`
+	// The region of the bucket. Default: `us-east-1`.
+	Region string `mapstructure:"region" required:"false"`
+	// The name of the bucket.
+	Bucket string `mapstructure:"bucket" required:"true"`
+	// The access key of the object storage. Environment variable
+	// `AWS_ACCESS_KEY_ID` can be set instead.
+	AccessKey string `mapstructure:"access_key" required:"true"`
+	// The secret key of the object storage. Environment variable
+	// `AWS_SECRET_ACCESS_KEY` can be set instead.
+	SecretKey string `mapstructure:"secret_key" required:"true"`
 }
 
 func NewConfig(raws ...interface{}) (*Config, []string, error) {
 	c := new(Config)
+	// Every field is validated as a template, keep the deprecated file server
+	// address placeholder valid. It is replaced when rendering boot commands.
+	c.ctx.Funcs = map[string]interface{}{
+		"__HTTP__ADDRESS__": func() string { return fileServerAddressPlaceholder },
+	}
 
 	var md mapstructure.Metadata
 	err := config.Decode(c, &config.DecodeOpts{
@@ -88,6 +298,8 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		InterpolateFilter: &interpolate.RenderFilter{
 			Exclude: []string{
 				"run_command",
+				"boot_command",
+				"post_reboot_boot_command",
 			},
 		},
 	}, raws...)
@@ -95,6 +307,7 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		return nil, nil, err
 	}
 
+	var errs *packersdk.MultiError
 	// Defaults
 	if c.APIURL == "" {
 		// Default to environment variable for api_url, if it exists
@@ -124,30 +337,337 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		// Default to packer-{{ unix timestamp (utc) }}
 		c.TemplateName = def
 	}
+	if c.PackerForce {
+		c.ForceDeregister = true
+	}
+	if c.TemplateRetention != nil {
+		if es := c.TemplateRetention.prepare(); len(es) > 0 {
+			errs = packersdk.MultiErrorAppend(errs, es...)
+		}
+	}
+	if c.CheckSnapshot && !c.SkipCreateTemplate {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("check_snapshot requires skip_create_template"))
+	}
+	if c.ArtifactType == "" {
+		c.ArtifactType = artifactTypeTemplate
+	}
+	switch c.ArtifactType {
+	case artifactTypeTemplate:
+	case artifactTypeStorage, artifactTypeSnapshot:
+		if c.SkipCreateTemplate {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("skip_create_template cannot be used with artifact_type %q", c.ArtifactType))
+		}
+		if c.TemplateRetention != nil {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("template_retention cannot be used with artifact_type %q", c.ArtifactType))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("artifact_type %q is not supported, use %q, %q or %q", c.ArtifactType,
+				artifactTypeTemplate, artifactTypeStorage, artifactTypeSnapshot))
+	}
+	if c.ExportS3 != nil {
+		if es := c.ExportS3.Prepare("export_s3"); len(es) > 0 {
+			errs = packersdk.MultiErrorAppend(errs, es...)
+		}
+		if c.SkipCreateTemplate || c.ArtifactType == artifactTypeStorage {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New("export_s3 needs a snapshot, it cannot be used with skip_create_template or artifact_type \"storage\""))
+		}
+		if c.ExportS3Key == "" {
+			c.ExportS3Key = fmt.Sprintf("%s.gz", c.TemplateName)
+		}
+	}
+
+	if c.VNCBindAddress == "" {
+		c.VNCBindAddress = "127.0.0.1"
+	}
+
+	if c.ISOUploadTarget == "" {
+		c.ISOUploadTarget = isoUploadTargetFileServer
+	}
+
+	if c.ISOUploadS3.Endpoint == "" {
+		c.ISOUploadS3.Endpoint = defaultS3Endpoint
+	}
+
+	if c.ISOUploadS3.Region == "" {
+		c.ISOUploadS3.Region = defaultS3Region
+	}
+
+	if c.InstallTimeout == 0 {
+		c.InstallTimeout = defaultInstallTimeout
+	}
+
+	if c.ISOFile != "" && (c.IsoImageUUID != "" || c.IsoImageURL != "") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("iso_file cannot be combined with isoimage_uuid or isoimage_url"))
+	}
+	if len(c.ISOImages) == 0 && c.ISOFile != "" {
+		c.ISOImages = []ISOImageConfig{{File: c.ISOFile, Boot: true}}
+	} else if len(c.ISOImages) > 0 && c.ISOFile != "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("iso_images cannot be combined with iso_file"))
+	}
+	if len(c.ISOImages) == 0 && (c.IsoImageUUID != "" || c.IsoImageURL != "") {
+		// isoimage_uuid and isoimage_url are a single boot ISO image
+		iso := ISOImageConfig{UUID: c.IsoImageUUID, URL: c.IsoImageURL, Checksum: c.ISOChecksum, Boot: true}
+		if iso.UUID != "" {
+			iso.URL = ""
+			iso.Checksum = ""
+		}
+		c.ISOImages = []ISOImageConfig{iso}
+	} else if len(c.ISOImages) > 0 && (c.IsoImageUUID != "" || c.IsoImageURL != "" || c.ISOChecksum != "") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("iso_images cannot be combined with isoimage_uuid, isoimage_url or iso_checksum"))
+	}
+	// isoimage_uuid ignores isoimage_url, and with it the checksum
+	if c.ISOChecksum != "" && (c.IsoImageURL == "" || c.IsoImageUUID != "") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("iso_checksum requires isoimage_url, it cannot be combined with isoimage_uuid"))
+	}
 
 	if c.ServerName == "" {
 		// Default to packer-[time-ordered-uuid]
 		c.ServerName = fmt.Sprintf("packer-%s", uuid.TimeOrderedUUID())
 	}
 
-	var errs *packersdk.MultiError
+	var warnings []string
+	for _, bootCommand := range append(c.BootCommand, c.PostRebootBootCommand...) {
+		if strings.Contains(bootCommand, fileServerAddressPlaceholder) {
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, use {{ .HTTPIP }}:{{ .HTTPPort }} instead", fileServerAddressPlaceholder))
+			break
+		}
+	}
+
 	if es := c.Comm.Prepare(&c.ctx); len(es) > 0 {
 		errs = packersdk.MultiErrorAppend(errs, es...)
 	}
+	if es := c.ShutdownConfig.Prepare(&c.ctx); len(es) > 0 {
+		errs = packersdk.MultiErrorAppend(errs, es...)
+	}
+	if c.ShutdownFallback == "" {
+		c.ShutdownFallback = shutdownFallbackAPIStop
+	}
+	switch c.ShutdownFallback {
+	case shutdownFallbackAPIStop, shutdownFallbackAPI, shutdownFallbackStop:
+	case shutdownFallbackNone:
+		if c.ShutdownCommand == "" {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("shutdown_fallback %q requires shutdown_command", shutdownFallbackNone))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("shutdown_fallback %q is not supported, use %q, %q, %q or %q", c.ShutdownFallback,
+				shutdownFallbackAPIStop, shutdownFallbackAPI, shutdownFallbackStop, shutdownFallbackNone))
+	}
+	if c.ShutdownCommand != "" && !c.hasCommunicator() {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New(`shutdown_command cannot be combined with communicator = "none"`))
+	}
 	if c.APIToken == "" {
 		// Required configurations that will display errors if not set
 		errs = packersdk.MultiErrorAppend(
 			errs, errors.New("api_token for auth must be specified"))
 	}
-	if c.IsoImageURL == "" && c.IsoImageUUID == "" && c.BaseTemplateUUID == "" {
+	if len(c.ISOImages) == 0 && c.BaseTemplateUUID == "" {
 		errs = packersdk.MultiErrorAppend(
-			errs, errors.New("one of these fields has to be set: isoimage_uuid, isoimage_url, base_template_uuid"))
+			errs, errors.New("one of these fields has to be set: isoimage_uuid, isoimage_url, iso_file, iso_images, base_template_uuid"))
+	}
+	bootISOImages := 0
+	for i, iso := range c.ISOImages {
+		sources := 0
+		for _, source := range []string{iso.UUID, iso.URL, iso.File} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("iso_images[%d]: exactly one of uuid, url and file has to be set", i))
+		}
+		if iso.Boot {
+			bootISOImages++
+		}
+		if iso.File != "" {
+			if _, err := os.Stat(iso.File); err != nil {
+				errs = packersdk.MultiErrorAppend(
+					errs, fmt.Errorf("iso_images[%d]: %s", i, err))
+			}
+			if c.ISOCache {
+				errs = packersdk.MultiErrorAppend(
+					errs, fmt.Errorf("iso_images[%d]: iso_cache does not support local ISO images", i))
+			}
+		}
+		if iso.URL == "" {
+			if iso.Checksum != "" {
+				errs = packersdk.MultiErrorAppend(
+					errs, fmt.Errorf("iso_images[%d]: checksum requires url", i))
+			}
+			continue
+		}
+		if iso.Checksum == "" || iso.Checksum == isoChecksumNone {
+			if c.ISOCache {
+				errs = packersdk.MultiErrorAppend(
+					errs, fmt.Errorf("iso_images[%d]: iso_cache requires a checksum", i))
+			}
+			continue
+		}
+		checksum, err := normalizeISOChecksum(iso.Checksum)
+		if err != nil {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("iso_images[%d]: %s", i, err))
+			continue
+		}
+		c.ISOImages[i].Checksum = checksum
+	}
+	if bootISOImages > 1 {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("at most one of iso_images can be the boot device"))
+	}
+	if len(c.CDFiles) > 0 || len(c.CDContent) > 0 {
+		cd := commonsteps.CDConfig{CDFiles: c.CDFiles}
+		if es := cd.Prepare(&c.ctx); len(es) > 0 {
+			errs = packersdk.MultiErrorAppend(errs, es...)
+		}
+		c.CDFiles = cd.CDFiles
+		c.ISOImages = append(c.ISOImages, ISOImageConfig{cd: true})
+	}
+	switch c.ISOUploadTarget {
+	case isoUploadTargetFileServer:
+	case isoUploadTargetS3:
+		if c.hasISOFile() {
+			if es := c.ISOUploadS3.Prepare("iso_upload_s3"); len(es) > 0 {
+				errs = packersdk.MultiErrorAppend(errs, es...)
+			}
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("iso_upload_target %q is not supported, use %q or %q", c.ISOUploadTarget, isoUploadTargetFileServer, isoUploadTargetS3))
+	}
+	if c.UnattendedInstall != nil {
+		if es := c.UnattendedInstall.prepare(c); len(es) > 0 {
+			errs = packersdk.MultiErrorAppend(errs, es...)
+		}
+	}
+	if !c.hasCommunicator() && c.hasBootISOImage() {
+		// Without a communicator, the build continues when the installation
+		// has powered off the server
+		if c.ISOInstallWait == "" {
+			c.ISOInstallWait = isoInstallWaitPowerOff
+		}
+		if len(c.PostRebootBootCommand) > 0 {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New(`post_reboot_boot_command cannot be combined with communicator = "none"`))
+		}
+	}
+	if c.ISOInstallWait != "" && c.ISOInstallWait != isoInstallWaitPowerOff {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("iso_install_wait %q is not supported, use %q", c.ISOInstallWait, isoInstallWaitPowerOff))
+	}
+	if len(c.PostRebootBootCommand) > 0 && !c.hasBootISOImage() {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("post_reboot_boot_command requires an ISO image to boot from"))
+	}
+	if _, ok := keymaps[c.BootKeymap]; !ok && c.BootKeymap != "" && c.BootKeymap != "us" {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("boot_keymap %q is not supported", c.BootKeymap))
+	}
+	if c.VNCPort < 0 || c.VNCPort > 65535 {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("vnc_port must be between 0 and 65535"))
+	}
+
+	if c.Isolated {
+		if c.Comm.Type != "none" {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New(`isolated requires communicator = "none"`))
+		}
+		if !c.hasBootISOImage() {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New("isolated requires an ISO image to boot from"))
+		}
+		if c.needsFileServer() {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New(`isolated cannot be combined with files, unattended_install or local ISO images uploaded to the file server, use iso_upload_target = "s3"`))
+		}
 	}
 
 	if errs != nil && len(errs.Errors) > 0 {
-		return nil, nil, errs
+		return nil, warnings, errs
+	}
+
+	packersdk.LogSecretFilter.Set(c.APIToken, c.VNCPassword, c.ISOUploadS3.SecretKey)
+	if c.ExportS3 != nil {
+		packersdk.LogSecretFilter.Set(c.ExportS3.SecretKey)
+	}
+	if c.UnattendedInstall != nil && c.UnattendedInstall.Password != "" {
+		packersdk.LogSecretFilter.Set(c.UnattendedInstall.Password)
 	}
+	return c, warnings, nil
+}
+
+// hasBootISOImage reports whether the server boots from an ISO image.
+func (c *Config) hasBootISOImage() bool {
+	for _, iso := range c.ISOImages {
+		if iso.Boot {
+			return true
+		}
+	}
+	return false
+}
+
+// bootISOImageSource returns the UUID, the URL or the file of the ISO image
+// the server boots from, or "" if it boots from a template.
+func (c *Config) bootISOImageSource() string {
+	for _, iso := range c.ISOImages {
+		if !iso.Boot {
+			continue
+		}
+		switch {
+		case iso.UUID != "":
+			return iso.UUID
+		case iso.URL != "":
+			return iso.URL
+		}
+		return iso.File
+	}
+	return ""
+}
+
+// createsTemplate reports whether the build produces a template.
+func (c *Config) createsTemplate() bool {
+	return !c.SkipCreateTemplate && c.ArtifactType != artifactTypeStorage && c.ArtifactType != artifactTypeSnapshot
+}
+
+// hasCommunicator reports whether the builder connects to the server, e.g.
+// for provisioning.
+func (c *Config) hasCommunicator() bool {
+	return c.Comm.Type != "none"
+}
+
+// cachesISOImage reports whether the ISO image is kept for later builds by
+// iso_cache. Only ISO images downloaded from a URL are cached, the CD and
+// uploaded ISO images are always destroyed.
+func (c *Config) cachesISOImage(iso ISOImageConfig) bool {
+	return c.ISOCache && iso.URL != ""
+}
+
+// hasISOFile reports whether a local ISO image has to be uploaded.
+func (c *Config) hasISOFile() bool {
+	for _, iso := range c.ISOImages {
+		if iso.isLocal() {
+			return true
+		}
+	}
+	return false
+}
 
-	packersdk.LogSecretFilter.Set(c.APIToken)
-	return c, nil, nil
+// needsFileServer reports whether the helper file server is needed, to serve
+// files, answer files or local ISO images.
+func (c *Config) needsFileServer() bool {
+	return len(c.Files) > 0 || c.UnattendedInstall != nil ||
+		(c.hasISOFile() && c.ISOUploadTarget == isoUploadTargetFileServer)
 }
@@ -10,81 +10,115 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName           *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType         *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion         *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug               *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce               *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError             *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars            map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars       []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	Type                      *string           `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect        *string           `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                   *string           `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                   *int              `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername               *string           `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword               *string           `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName            *string           `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName   *string           `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHTemporaryKeyPairType   *string           `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
-	SSHTemporaryKeyPairBits   *int              `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
-	SSHCiphers                []string          `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys    *bool             `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos               []string          `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile         *string           `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile        *string           `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                    *bool             `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                *string           `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout            *string           `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth              *bool             `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding *bool             `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts      *int              `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost            *string           `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort            *int              `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth       *bool             `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername        *string           `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword        *string           `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive     *bool             `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile  *string           `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile *string           `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod     *string           `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost              *string           `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort              *int              `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername          *string           `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword          *string           `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval      *string           `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout       *string           `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels          []string          `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels           []string          `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey              []byte            `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey             []byte            `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                 *string           `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword             *string           `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                 *string           `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy              *bool             `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                 *int              `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout              *string           `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL               *bool             `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure             *bool             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM              *bool             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
-	APIToken                  *string           `mapstructure:"api_token" required:"true" cty:"api_token" hcl:"api_token"`
-	APIKey                    *string           `mapstructure:"api_key" required:"true" cty:"api_key" hcl:"api_key"`
-	APIURL                    *string           `mapstructure:"api_url" required:"false" cty:"api_url" hcl:"api_url"`
-	APIRequestHeaders         *string           `mapstructure:"api_request_headers" required:"false" cty:"api_request_headers" hcl:"api_request_headers"`
-	TemplateName              *string           `mapstructure:"template_name" required:"false" cty:"template_name" hcl:"template_name"`
-	Hostname                  *string           `mapstructure:"hostname" required:"false" cty:"hostname" hcl:"hostname"`
-	ServerName                *string           `mapstructure:"server_name" required:"false" cty:"server_name" hcl:"server_name"`
-	ServerCores               *int              `mapstructure:"server_cores" required:"true" cty:"server_cores" hcl:"server_cores"`
-	ServerMemory              *int              `mapstructure:"server_memory" required:"true" cty:"server_memory" hcl:"server_memory"`
-	StorageCapacity           *int              `mapstructure:"storage_capacity" required:"true" cty:"storage_capacity" hcl:"storage_capacity"`
-	SecondaryStorage          *bool             `mapstructure:"secondary_storage" required:"false" cty:"secondary_storage" hcl:"secondary_storage"`
-	BaseTemplateUUID          *string           `mapstructure:"base_template_uuid" required:"false" cty:"base_template_uuid" hcl:"base_template_uuid"`
-	IsoImageUUID              *string           `mapstructure:"isoimage_uuid" required:"false" cty:"isoimage_uuid" hcl:"isoimage_uuid"`
-	IsoImageURL               *string           `mapstructure:"isoimage_url" required:"false" cty:"isoimage_url" hcl:"isoimage_url"`
-	BootCommand               []string          `mapstructure:"boot_command" required:"false" cty:"boot_command" hcl:"boot_command"`
-	BootWait                  *string           `mapstructure:"boot_wait" required:"false" cty:"boot_wait" hcl:"boot_wait"`
-	BootKeyInterval           *string           `mapstructure:"boot_key_interval" required:"false" cty:"boot_key_interval" hcl:"boot_key_interval"`
-	Files                     []string          `mapstructure:"files" required:"false" cty:"files" hcl:"files"`
+	PackerBuildName           *string                      `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType         *string                      `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion         *string                      `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug               *bool                        `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce               *bool                        `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError             *string                      `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars            map[string]string            `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars       []string                     `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	Type                      *string                      `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect        *string                      `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                   *string                      `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                   *int                         `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername               *string                      `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword               *string                      `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName            *string                      `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName   *string                      `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType   *string                      `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits   *int                         `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                []string                     `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys    *bool                        `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos               []string                     `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile         *string                      `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile        *string                      `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                    *bool                        `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                *string                      `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout            *string                      `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth              *bool                        `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding *bool                        `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts      *int                         `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost            *string                      `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort            *int                         `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth       *bool                        `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername        *string                      `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword        *string                      `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive     *bool                        `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile  *string                      `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile *string                      `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod     *string                      `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost              *string                      `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort              *int                         `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername          *string                      `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword          *string                      `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval      *string                      `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout       *string                      `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels          []string                     `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels           []string                     `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey              []byte                       `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey             []byte                       `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                 *string                      `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword             *string                      `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                 *string                      `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy              *bool                        `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                 *int                         `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout              *string                      `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL               *bool                        `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure             *bool                        `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM              *bool                        `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	ShutdownCommand           *string                      `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout           *string                      `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	ShutdownFallback          *string                      `mapstructure:"shutdown_fallback" required:"false" cty:"shutdown_fallback" hcl:"shutdown_fallback"`
+	APIToken                  *string                      `mapstructure:"api_token" required:"true" cty:"api_token" hcl:"api_token"`
+	APIKey                    *string                      `mapstructure:"api_key" required:"true" cty:"api_key" hcl:"api_key"`
+	APIURL                    *string                      `mapstructure:"api_url" required:"false" cty:"api_url" hcl:"api_url"`
+	APIRequestHeaders         *string                      `mapstructure:"api_request_headers" required:"false" cty:"api_request_headers" hcl:"api_request_headers"`
+	TemplateName              *string                      `mapstructure:"template_name" required:"false" cty:"template_name" hcl:"template_name"`
+	ForceDeregister           *bool                        `mapstructure:"force_deregister" required:"false" cty:"force_deregister" hcl:"force_deregister"`
+	ForceDeleteInUse          *bool                        `mapstructure:"force_delete_in_use" required:"false" cty:"force_delete_in_use" hcl:"force_delete_in_use"`
+	TemplateLabels            []string                     `mapstructure:"template_labels" required:"false" cty:"template_labels" hcl:"template_labels"`
+	TemplateRetention         *FlatTemplateRetentionConfig `mapstructure:"template_retention" required:"false" cty:"template_retention" hcl:"template_retention"`
+	SkipCreateTemplate        *bool                        `mapstructure:"skip_create_template" required:"false" cty:"skip_create_template" hcl:"skip_create_template"`
+	CheckSnapshot             *bool                        `mapstructure:"check_snapshot" required:"false" cty:"check_snapshot" hcl:"check_snapshot"`
+	ArtifactType              *string                      `mapstructure:"artifact_type" required:"false" cty:"artifact_type" hcl:"artifact_type"`
+	ManifestOutput            *string                      `mapstructure:"manifest_output" required:"false" cty:"manifest_output" hcl:"manifest_output"`
+	ExportS3                  *FlatS3Config                `mapstructure:"export_s3" required:"false" cty:"export_s3" hcl:"export_s3"`
+	ExportS3Key               *string                      `mapstructure:"export_s3_key" required:"false" cty:"export_s3_key" hcl:"export_s3_key"`
+	ExportTimeout             *string                      `mapstructure:"export_timeout" required:"false" cty:"export_timeout" hcl:"export_timeout"`
+	Hostname                  *string                      `mapstructure:"hostname" required:"false" cty:"hostname" hcl:"hostname"`
+	ServerName                *string                      `mapstructure:"server_name" required:"false" cty:"server_name" hcl:"server_name"`
+	ServerCores               *int                         `mapstructure:"server_cores" required:"true" cty:"server_cores" hcl:"server_cores"`
+	ServerMemory              *int                         `mapstructure:"server_memory" required:"true" cty:"server_memory" hcl:"server_memory"`
+	StorageCapacity           *int                         `mapstructure:"storage_capacity" required:"true" cty:"storage_capacity" hcl:"storage_capacity"`
+	SecondaryStorage          *bool                        `mapstructure:"secondary_storage" required:"false" cty:"secondary_storage" hcl:"secondary_storage"`
+	BaseTemplateUUID          *string                      `mapstructure:"base_template_uuid" required:"false" cty:"base_template_uuid" hcl:"base_template_uuid"`
+	IsoImageUUID              *string                      `mapstructure:"isoimage_uuid" required:"false" cty:"isoimage_uuid" hcl:"isoimage_uuid"`
+	IsoImageURL               *string                      `mapstructure:"isoimage_url" required:"false" cty:"isoimage_url" hcl:"isoimage_url"`
+	ISOChecksum               *string                      `mapstructure:"iso_checksum" required:"false" cty:"iso_checksum" hcl:"iso_checksum"`
+	ISOFile                   *string                      `mapstructure:"iso_file" required:"false" cty:"iso_file" hcl:"iso_file"`
+	ISOImages                 []FlatISOImageConfig         `mapstructure:"iso_images" required:"false" cty:"iso_images" hcl:"iso_images"`
+	ISOCache                  *bool                        `mapstructure:"iso_cache" required:"false" cty:"iso_cache" hcl:"iso_cache"`
+	ISOUploadTarget           *string                      `mapstructure:"iso_upload_target" required:"false" cty:"iso_upload_target" hcl:"iso_upload_target"`
+	ISOUploadS3               *FlatS3Config                `mapstructure:"iso_upload_s3" required:"false" cty:"iso_upload_s3" hcl:"iso_upload_s3"`
+	CDFiles                   []string                     `mapstructure:"cd_files" required:"false" cty:"cd_files" hcl:"cd_files"`
+	CDContent                 map[string]string            `mapstructure:"cd_content" required:"false" cty:"cd_content" hcl:"cd_content"`
+	CDLabel                   *string                      `mapstructure:"cd_label" required:"false" cty:"cd_label" hcl:"cd_label"`
+	UnattendedInstall         *FlatUnattendedInstallConfig `mapstructure:"unattended_install" required:"false" cty:"unattended_install" hcl:"unattended_install"`
+	BootCommand               []string                     `mapstructure:"boot_command" required:"false" cty:"boot_command" hcl:"boot_command"`
+	BootWait                  *string                      `mapstructure:"boot_wait" required:"false" cty:"boot_wait" hcl:"boot_wait"`
+	BootKeyInterval           *string                      `mapstructure:"boot_key_interval" required:"false" cty:"boot_key_interval" hcl:"boot_key_interval"`
+	ISOInstallWait            *string                      `mapstructure:"iso_install_wait" required:"false" cty:"iso_install_wait" hcl:"iso_install_wait"`
+	InstallTimeout            *string                      `mapstructure:"install_timeout" required:"false" cty:"install_timeout" hcl:"install_timeout"`
+	PostRebootBootCommand     []string                     `mapstructure:"post_reboot_boot_command" required:"false" cty:"post_reboot_boot_command" hcl:"post_reboot_boot_command"`
+	PostRebootBootWait        *string                      `mapstructure:"post_reboot_boot_wait" required:"false" cty:"post_reboot_boot_wait" hcl:"post_reboot_boot_wait"`
+	BootKeymap                *string                      `mapstructure:"boot_keymap" required:"false" cty:"boot_keymap" hcl:"boot_keymap"`
+	VNCPassword               *string                      `mapstructure:"vnc_password" required:"false" cty:"vnc_password" hcl:"vnc_password"`
+	VNCUsername               *string                      `mapstructure:"vnc_username" required:"false" cty:"vnc_username" hcl:"vnc_username"`
+	VNCBindAddress            *string                      `mapstructure:"vnc_bind_address" required:"false" cty:"vnc_bind_address" hcl:"vnc_bind_address"`
+	VNCPort                   *int                         `mapstructure:"vnc_port" required:"false" cty:"vnc_port" hcl:"vnc_port"`
+	Files                     []string                     `mapstructure:"files" required:"false" cty:"files" hcl:"files"`
+	Isolated                  *bool                        `mapstructure:"isolated" required:"false" cty:"isolated" hcl:"isolated"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -156,11 +190,25 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"winrm_use_ssl":                &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
 		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
 		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		"shutdown_command":             &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
+		"shutdown_timeout":             &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
+		"shutdown_fallback":            &hcldec.AttrSpec{Name: "shutdown_fallback", Type: cty.String, Required: false},
 		"api_token":                    &hcldec.AttrSpec{Name: "api_token", Type: cty.String, Required: false},
 		"api_key":                      &hcldec.AttrSpec{Name: "api_key", Type: cty.String, Required: false},
 		"api_url":                      &hcldec.AttrSpec{Name: "api_url", Type: cty.String, Required: false},
 		"api_request_headers":          &hcldec.AttrSpec{Name: "api_request_headers", Type: cty.String, Required: false},
 		"template_name":                &hcldec.AttrSpec{Name: "template_name", Type: cty.String, Required: false},
+		"force_deregister":             &hcldec.AttrSpec{Name: "force_deregister", Type: cty.Bool, Required: false},
+		"force_delete_in_use":          &hcldec.AttrSpec{Name: "force_delete_in_use", Type: cty.Bool, Required: false},
+		"template_labels":              &hcldec.AttrSpec{Name: "template_labels", Type: cty.List(cty.String), Required: false},
+		"template_retention":           &hcldec.BlockSpec{TypeName: "template_retention", Nested: hcldec.ObjectSpec((*FlatTemplateRetentionConfig)(nil).HCL2Spec())},
+		"skip_create_template":         &hcldec.AttrSpec{Name: "skip_create_template", Type: cty.Bool, Required: false},
+		"check_snapshot":               &hcldec.AttrSpec{Name: "check_snapshot", Type: cty.Bool, Required: false},
+		"artifact_type":                &hcldec.AttrSpec{Name: "artifact_type", Type: cty.String, Required: false},
+		"manifest_output":              &hcldec.AttrSpec{Name: "manifest_output", Type: cty.String, Required: false},
+		"export_s3":                    &hcldec.BlockSpec{TypeName: "export_s3", Nested: hcldec.ObjectSpec((*FlatS3Config)(nil).HCL2Spec())},
+		"export_s3_key":                &hcldec.AttrSpec{Name: "export_s3_key", Type: cty.String, Required: false},
+		"export_timeout":               &hcldec.AttrSpec{Name: "export_timeout", Type: cty.String, Required: false},
 		"hostname":                     &hcldec.AttrSpec{Name: "hostname", Type: cty.String, Required: false},
 		"server_name":                  &hcldec.AttrSpec{Name: "server_name", Type: cty.String, Required: false},
 		"server_cores":                 &hcldec.AttrSpec{Name: "server_cores", Type: cty.Number, Required: false},
@@ -170,10 +218,160 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"base_template_uuid":           &hcldec.AttrSpec{Name: "base_template_uuid", Type: cty.String, Required: false},
 		"isoimage_uuid":                &hcldec.AttrSpec{Name: "isoimage_uuid", Type: cty.String, Required: false},
 		"isoimage_url":                 &hcldec.AttrSpec{Name: "isoimage_url", Type: cty.String, Required: false},
+		"iso_checksum":                 &hcldec.AttrSpec{Name: "iso_checksum", Type: cty.String, Required: false},
+		"iso_file":                     &hcldec.AttrSpec{Name: "iso_file", Type: cty.String, Required: false},
+		"iso_images":                   &hcldec.BlockListSpec{TypeName: "iso_images", Nested: hcldec.ObjectSpec((*FlatISOImageConfig)(nil).HCL2Spec())},
+		"iso_cache":                    &hcldec.AttrSpec{Name: "iso_cache", Type: cty.Bool, Required: false},
+		"iso_upload_target":            &hcldec.AttrSpec{Name: "iso_upload_target", Type: cty.String, Required: false},
+		"iso_upload_s3":                &hcldec.BlockSpec{TypeName: "iso_upload_s3", Nested: hcldec.ObjectSpec((*FlatS3Config)(nil).HCL2Spec())},
+		"cd_files":                     &hcldec.AttrSpec{Name: "cd_files", Type: cty.List(cty.String), Required: false},
+		"cd_content":                   &hcldec.AttrSpec{Name: "cd_content", Type: cty.Map(cty.String), Required: false},
+		"cd_label":                     &hcldec.AttrSpec{Name: "cd_label", Type: cty.String, Required: false},
+		"unattended_install":           &hcldec.BlockSpec{TypeName: "unattended_install", Nested: hcldec.ObjectSpec((*FlatUnattendedInstallConfig)(nil).HCL2Spec())},
 		"boot_command":                 &hcldec.AttrSpec{Name: "boot_command", Type: cty.List(cty.String), Required: false},
 		"boot_wait":                    &hcldec.AttrSpec{Name: "boot_wait", Type: cty.String, Required: false},
 		"boot_key_interval":            &hcldec.AttrSpec{Name: "boot_key_interval", Type: cty.String, Required: false},
+		"iso_install_wait":             &hcldec.AttrSpec{Name: "iso_install_wait", Type: cty.String, Required: false},
+		"install_timeout":              &hcldec.AttrSpec{Name: "install_timeout", Type: cty.String, Required: false},
+		"post_reboot_boot_command":     &hcldec.AttrSpec{Name: "post_reboot_boot_command", Type: cty.List(cty.String), Required: false},
+		"post_reboot_boot_wait":        &hcldec.AttrSpec{Name: "post_reboot_boot_wait", Type: cty.String, Required: false},
+		"boot_keymap":                  &hcldec.AttrSpec{Name: "boot_keymap", Type: cty.String, Required: false},
+		"vnc_password":                 &hcldec.AttrSpec{Name: "vnc_password", Type: cty.String, Required: false},
+		"vnc_username":                 &hcldec.AttrSpec{Name: "vnc_username", Type: cty.String, Required: false},
+		"vnc_bind_address":             &hcldec.AttrSpec{Name: "vnc_bind_address", Type: cty.String, Required: false},
+		"vnc_port":                     &hcldec.AttrSpec{Name: "vnc_port", Type: cty.Number, Required: false},
 		"files":                        &hcldec.AttrSpec{Name: "files", Type: cty.List(cty.String), Required: false},
+		"isolated":                     &hcldec.AttrSpec{Name: "isolated", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatISOImageConfig is an auto-generated flat version of ISOImageConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatISOImageConfig struct {
+	UUID     *string `mapstructure:"uuid" required:"false" cty:"uuid" hcl:"uuid"`
+	URL      *string `mapstructure:"url" required:"false" cty:"url" hcl:"url"`
+	Checksum *string `mapstructure:"checksum" required:"false" cty:"checksum" hcl:"checksum"`
+	File     *string `mapstructure:"file" required:"false" cty:"file" hcl:"file"`
+	Boot     *bool   `mapstructure:"boot" required:"false" cty:"boot" hcl:"boot"`
+}
+
+// FlatMapstructure returns a new FlatISOImageConfig.
+// FlatISOImageConfig is an auto-generated flat version of ISOImageConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ISOImageConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatISOImageConfig)
+}
+
+// HCL2Spec returns the hcl spec of a ISOImageConfig.
+// This spec is used by HCL to read the fields of ISOImageConfig.
+// The decoded values from this spec will then be applied to a FlatISOImageConfig.
+func (*FlatISOImageConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"uuid":     &hcldec.AttrSpec{Name: "uuid", Type: cty.String, Required: false},
+		"url":      &hcldec.AttrSpec{Name: "url", Type: cty.String, Required: false},
+		"checksum": &hcldec.AttrSpec{Name: "checksum", Type: cty.String, Required: false},
+		"file":     &hcldec.AttrSpec{Name: "file", Type: cty.String, Required: false},
+		"boot":     &hcldec.AttrSpec{Name: "boot", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatS3Config is an auto-generated flat version of S3Config.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatS3Config struct {
+	Endpoint  *string `mapstructure:"endpoint" required:"false" cty:"endpoint" hcl:"endpoint"`
+	Region    *string `mapstructure:"region" required:"false" cty:"region" hcl:"region"`
+	Bucket    *string `mapstructure:"bucket" required:"true" cty:"bucket" hcl:"bucket"`
+	AccessKey *string `mapstructure:"access_key" required:"true" cty:"access_key" hcl:"access_key"`
+	SecretKey *string `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
+}
+
+// FlatMapstructure returns a new FlatS3Config.
+// FlatS3Config is an auto-generated flat version of S3Config.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*S3Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatS3Config)
+}
+
+// HCL2Spec returns the hcl spec of a S3Config.
+// This spec is used by HCL to read the fields of S3Config.
+// The decoded values from this spec will then be applied to a FlatS3Config.
+func (*FlatS3Config) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"endpoint":   &hcldec.AttrSpec{Name: "endpoint", Type: cty.String, Required: false},
+		"region":     &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
+		"bucket":     &hcldec.AttrSpec{Name: "bucket", Type: cty.String, Required: false},
+		"access_key": &hcldec.AttrSpec{Name: "access_key", Type: cty.String, Required: false},
+		"secret_key": &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatTemplateRetentionConfig is an auto-generated flat version of TemplateRetentionConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatTemplateRetentionConfig struct {
+	NamePrefix *string  `mapstructure:"name_prefix" required:"false" cty:"name_prefix" hcl:"name_prefix"`
+	Labels     []string `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	KeepLatest *int     `mapstructure:"keep_latest" required:"false" cty:"keep_latest" hcl:"keep_latest"`
+	KeepFor    *string  `mapstructure:"keep_for" required:"false" cty:"keep_for" hcl:"keep_for"`
+}
+
+// FlatMapstructure returns a new FlatTemplateRetentionConfig.
+// FlatTemplateRetentionConfig is an auto-generated flat version of TemplateRetentionConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*TemplateRetentionConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatTemplateRetentionConfig)
+}
+
+// HCL2Spec returns the hcl spec of a TemplateRetentionConfig.
+// This spec is used by HCL to read the fields of TemplateRetentionConfig.
+// The decoded values from this spec will then be applied to a FlatTemplateRetentionConfig.
+func (*FlatTemplateRetentionConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"name_prefix": &hcldec.AttrSpec{Name: "name_prefix", Type: cty.String, Required: false},
+		"labels":      &hcldec.AttrSpec{Name: "labels", Type: cty.List(cty.String), Required: false},
+		"keep_latest": &hcldec.AttrSpec{Name: "keep_latest", Type: cty.Number, Required: false},
+		"keep_for":    &hcldec.AttrSpec{Name: "keep_for", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatUnattendedInstallConfig is an auto-generated flat version of UnattendedInstallConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatUnattendedInstallConfig struct {
+	Family            *string  `mapstructure:"family" required:"true" cty:"family" hcl:"family"`
+	Locale            *string  `mapstructure:"locale" required:"false" cty:"locale" hcl:"locale"`
+	Timezone          *string  `mapstructure:"timezone" required:"false" cty:"timezone" hcl:"timezone"`
+	DiskLayout        *string  `mapstructure:"disk_layout" required:"false" cty:"disk_layout" hcl:"disk_layout"`
+	Hostname          *string  `mapstructure:"hostname" required:"false" cty:"hostname" hcl:"hostname"`
+	Username          *string  `mapstructure:"username" required:"false" cty:"username" hcl:"username"`
+	Password          *string  `mapstructure:"password" required:"false" cty:"password" hcl:"password"`
+	SSHAuthorizedKeys []string `mapstructure:"ssh_authorized_keys" required:"false" cty:"ssh_authorized_keys" hcl:"ssh_authorized_keys"`
+	Packages          []string `mapstructure:"packages" required:"false" cty:"packages" hcl:"packages"`
+}
+
+// FlatMapstructure returns a new FlatUnattendedInstallConfig.
+// FlatUnattendedInstallConfig is an auto-generated flat version of UnattendedInstallConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*UnattendedInstallConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatUnattendedInstallConfig)
+}
+
+// HCL2Spec returns the hcl spec of a UnattendedInstallConfig.
+// This spec is used by HCL to read the fields of UnattendedInstallConfig.
+// The decoded values from this spec will then be applied to a FlatUnattendedInstallConfig.
+func (*FlatUnattendedInstallConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"family":              &hcldec.AttrSpec{Name: "family", Type: cty.String, Required: false},
+		"locale":              &hcldec.AttrSpec{Name: "locale", Type: cty.String, Required: false},
+		"timezone":            &hcldec.AttrSpec{Name: "timezone", Type: cty.String, Required: false},
+		"disk_layout":         &hcldec.AttrSpec{Name: "disk_layout", Type: cty.String, Required: false},
+		"hostname":            &hcldec.AttrSpec{Name: "hostname", Type: cty.String, Required: false},
+		"username":            &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
+		"password":            &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
+		"ssh_authorized_keys": &hcldec.AttrSpec{Name: "ssh_authorized_keys", Type: cty.List(cty.String), Required: false},
+		"packages":            &hcldec.AttrSpec{Name: "packages", Type: cty.List(cty.String), Required: false},
 	}
 	return s
 }
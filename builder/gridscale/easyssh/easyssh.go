@@ -186,20 +186,12 @@ func (ssh_conf *MakeConfig) Run(command string, timeout int) (outStr string, err
 
 // Scp uploads sourceFile to remote machine like native scp console app.
 func (ssh_conf *MakeConfig) Scp(sourceFile string, etargetFile string) error {
-	session, err := ssh_conf.connect()
-
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	targetFile := filepath.Base(etargetFile)
-
 	src, srcErr := os.Open(sourceFile)
 
 	if srcErr != nil {
 		return srcErr
 	}
+	defer src.Close()
 
 	srcStat, statErr := src.Stat()
 
@@ -207,12 +199,26 @@ func (ssh_conf *MakeConfig) Scp(sourceFile string, etargetFile string) error {
 		return statErr
 	}
 
+	return ssh_conf.ScpReader(src, srcStat.Size(), etargetFile)
+}
+
+// ScpReader uploads size bytes read from src to the remote file etargetFile.
+func (ssh_conf *MakeConfig) ScpReader(src io.Reader, size int64, etargetFile string) error {
+	session, err := ssh_conf.connect()
+
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	targetFile := filepath.Base(etargetFile)
+
 	go func() {
 		w, _ := session.StdinPipe()
 
-		fmt.Fprintln(w, "C0644", srcStat.Size(), targetFile)
+		fmt.Fprintln(w, "C0644", size, targetFile)
 
-		if srcStat.Size() > 0 {
+		if size > 0 {
 			io.Copy(w, src)
 			fmt.Fprint(w, "\x00")
 			w.Close()
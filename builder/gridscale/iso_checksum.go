@@ -0,0 +1,93 @@
+package gridscale
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const isoChecksumNone = "none"
+
+// isoChecksumHashes maps the supported checksum types to their hash.
+var isoChecksumHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// parseISOChecksum parses a checksum in the `type:value` format. If the type
+// is omitted, it is guessed from the length of the value.
+func parseISOChecksum(checksum string) (string, []byte, error) {
+	checksumType, value := "", checksum
+	if i := strings.Index(checksum, ":"); i >= 0 {
+		checksumType, value = strings.ToLower(checksum[:i]), checksum[i+1:]
+	}
+	sum, err := hex.DecodeString(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid checksum %q: %s", checksum, err)
+	}
+	if checksumType == "" {
+		for t, h := range isoChecksumHashes {
+			if h().Size() == len(sum) {
+				checksumType = t
+			}
+		}
+	}
+	h, ok := isoChecksumHashes[checksumType]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid checksum %q: unsupported checksum type", checksum)
+	}
+	if h().Size() != len(sum) {
+		return "", nil, fmt.Errorf("invalid checksum %q: a %s checksum has %d hexadecimal digits", checksum, checksumType, 2*h().Size())
+	}
+	return checksumType, sum, nil
+}
+
+// normalizeISOChecksum returns the checksum in the `type:value` format with
+// a lower case value, so that equal checksums compare equal.
+func normalizeISOChecksum(checksum string) (string, error) {
+	checksumType, sum, err := parseISOChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	return checksumType + ":" + hex.EncodeToString(sum), nil
+}
+
+// verifyISOChecksum downloads the ISO image from url and compares its
+// checksum with the expected one. The ISO image is not stored.
+func verifyISOChecksum(ctx context.Context, client *http.Client, url, checksum string) error {
+	checksumType, want, err := parseISOChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+	h := isoChecksumHashes[checksumType]()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return fmt.Errorf("downloading %s: %s", url, err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%s checksum of %s is %x, want %x", checksumType, url, got, want)
+	}
+	return nil
+}
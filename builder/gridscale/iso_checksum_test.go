@@ -0,0 +1,97 @@
+package gridscale
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_normalizeISOChecksum(t *testing.T) {
+	sha256Sum := "ea2b4ab2db2b5a9a3a6ee4ed56b1a4c9ab07b0cd4d0c67bf3dc4a00f5d3ba1e1"
+	tests := []struct {
+		name     string
+		checksum string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "with type",
+			checksum: "sha256:" + sha256Sum,
+			want:     "sha256:" + sha256Sum,
+		},
+		{
+			name:     "upper case",
+			checksum: "SHA256:" + strings.ToUpper(sha256Sum),
+			want:     "sha256:" + sha256Sum,
+		},
+		{
+			name:     "type guessed from the length",
+			checksum: "d41d8cd98f00b204e9800998ecf8427e",
+			want:     "md5:d41d8cd98f00b204e9800998ecf8427e",
+		},
+		{
+			name:     "unsupported type",
+			checksum: "crc32:d41d8cd9",
+			wantErr:  true,
+		},
+		{
+			name:     "wrong length",
+			checksum: "sha1:d41d8cd98f00b204e9800998ecf8427e",
+			wantErr:  true,
+		},
+		{
+			name:     "not hexadecimal",
+			checksum: "sha256:test",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeISOChecksum(tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeISOChecksum() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("normalizeISOChecksum() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyISOChecksum(t *testing.T) {
+	srv := produceTestISOServer(t)
+	tests := []struct {
+		name     string
+		url      string
+		checksum string
+		wantErr  bool
+	}{
+		{
+			name:     "checksum matches",
+			url:      srv.URL,
+			checksum: testISOChecksum,
+		},
+		{
+			name:     "checksum does not match",
+			url:      srv.URL,
+			checksum: "md5:d41d8cd98f00b204e9800998ecf8427e",
+			wantErr:  true,
+		},
+		{
+			name:     "download fails",
+			url:      srv.URL + "/missing",
+			checksum: testISOChecksum,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyISOChecksum(context.Background(), http.DefaultClient, tt.url, tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyISOChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package gridscale
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale/easyssh"
+)
+
+const (
+	isoUploadTargetFileServer = "file_server"
+	isoUploadTargetS3         = "s3"
+	defaultS3Endpoint         = "https://gos3.io"
+	defaultS3Region           = "us-east-1"
+	// isoUploadDir is the directory of the file server ISO images are
+	// uploaded to.
+	isoUploadDir = "iso"
+	// isoUploadURLExpiry is how long gridscale can download an ISO image
+	// uploaded to S3.
+	isoUploadURLExpiry = 6 * time.Hour
+)
+
+// isoFileUploader uploads local ISO images to a location gridscale can
+// download them from.
+type isoFileUploader interface {
+	// Upload uploads size bytes read from body as name and returns the URL
+	// to download it from.
+	Upload(ctx context.Context, name string, size int64, body io.Reader) (string, error)
+	// Remove removes the upload name.
+	Remove(ctx context.Context, name string) error
+}
+
+// fileServerISOUploader uploads ISO images to the helper file server.
+type fileServerISOUploader struct {
+	sshCfg *easyssh.MakeConfig
+	// address is the address the file server serves files at.
+	address string
+}
+
+func (u *fileServerISOUploader) Upload(ctx context.Context, name string, size int64, body io.Reader) (string, error) {
+	err := u.sshCfg.ScpReader(body, size, path.Join(isoUploadDir, name))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://%s/%s/%s", u.address, isoUploadDir, url.PathEscape(name)), nil
+}
+
+func (u *fileServerISOUploader) Remove(ctx context.Context, name string) error {
+	_, stderr, _, err := u.sshCfg.Run(fmt.Sprintf("rm -f \"%s\"", path.Join(isoUploadDir, name)), 60)
+	if err != nil {
+		return err
+	}
+	if stderr != "" && stderr != "\n" {
+		return fmt.Errorf("removing %s: %s", name, stderr)
+	}
+	return nil
+}
+
+// s3ISOUploader uploads ISO images to an S3-compatible object storage bucket.
+type s3ISOUploader struct {
+	client *s3.S3
+	bucket string
+	// prefix is prepended to the object keys, to keep concurrent builds
+	// apart.
+	prefix string
+}
+
+func newS3ISOUploader(cfg S3Config, prefix string) (*s3ISOUploader, error) {
+	client, err := NewS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ISOUploader{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (u *s3ISOUploader) Upload(ctx context.Context, name string, size int64, body io.Reader) (string, error) {
+	key := path.Join(u.prefix, name)
+	uploader := s3manager.NewUploaderWithClient(u.client)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return "", err
+	}
+	// The bucket is not necessarily public, gridscale downloads the ISO
+	// image from a presigned URL
+	req, _ := u.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(isoUploadURLExpiry)
+}
+
+func (u *s3ISOUploader) Remove(ctx context.Context, name string) error {
+	_, err := u.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(path.Join(u.prefix, name)),
+	})
+	return err
+}
@@ -0,0 +1,58 @@
+package gridscale
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_s3ISOUploader(t *testing.T) {
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(content)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+	u, err := newS3ISOUploader(S3Config{
+		Endpoint:  srv.URL,
+		Region:    defaultS3Region,
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+	}, "packer-test")
+	if err != nil {
+		t.Fatalf("newS3ISOUploader() error = %v", err)
+	}
+
+	url, err := u.Upload(context.Background(), "0-test.iso", 3, strings.NewReader("iso"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if got := objects["/bucket/packer-test/0-test.iso"]; got != "iso" {
+		t.Errorf("uploaded object = %q, want %q", got, "iso")
+	}
+	if want := srv.URL + "/bucket/packer-test/0-test.iso?"; !strings.HasPrefix(url, want) {
+		t.Errorf("Upload() url = %v, want prefix %v", url, want)
+	}
+	if !strings.Contains(url, "X-Amz-Signature=") {
+		t.Errorf("Upload() url = %v, want a presigned URL", url)
+	}
+
+	if err := u.Remove(context.Background(), "0-test.iso"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("objects = %v, want none", objects)
+	}
+}
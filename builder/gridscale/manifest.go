@@ -0,0 +1,170 @@
+package gridscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gridscale/packer-plugin-gridscale/version"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+const (
+	manifestResourceServer   = "server"
+	manifestResourceStorage  = "storage"
+	manifestResourceIP       = "ip"
+	manifestResourceSSHKey   = "sshkey"
+	manifestResourceISOImage = "isoimage"
+	manifestResourceSnapshot = "snapshot"
+)
+
+// buildManifest is the machine-readable summary of a build, which is written
+// to manifest_output when the build finishes.
+type buildManifest struct {
+	PluginVersion      string              `json:"plugin_version"`
+	StartedAt          time.Time           `json:"started_at"`
+	FinishedAt         time.Time           `json:"finished_at"`
+	Error              string              `json:"error,omitempty"`
+	ArtifactType       string              `json:"artifact_type"`
+	TemplateName       string              `json:"template_name"`
+	TemplateUUID       string              `json:"template_uuid,omitempty"`
+	StorageUUID        string              `json:"storage_uuid,omitempty"`
+	SnapshotUUID       string              `json:"snapshot_uuid,omitempty"`
+	LocationUUID       string              `json:"location_uuid,omitempty"`
+	LocationName       string              `json:"location_name,omitempty"`
+	SourceTemplateUUID string              `json:"source_template_uuid,omitempty"`
+	SourceISOImage     string              `json:"source_iso_image,omitempty"`
+	Resources          []*manifestResource `json:"resources"`
+	Steps              []manifestStep      `json:"steps"`
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+}
+
+// manifestResource is a temporary resource created during the build. A
+// resource without DeletedAt has been kept, e.g. as the artifact, or could
+// not be deleted.
+type manifestResource struct {
+	Type      string     `json:"type"`
+	UUID      string     `json:"uuid"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type manifestStep struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func newBuildManifest(c *Config, now func() time.Time) *buildManifest {
+	return &buildManifest{
+		PluginVersion:      version.PluginVersion.String(),
+		StartedAt:          now(),
+		ArtifactType:       c.ArtifactType,
+		TemplateName:       c.TemplateName,
+		SourceTemplateUUID: c.BaseTemplateUUID,
+		SourceISOImage:     c.bootISOImageSource(),
+		Resources:          []*manifestResource{},
+		Steps:              []manifestStep{},
+		now:                now,
+	}
+}
+
+// manifestFrom returns the manifest of the build, or nil if no manifest is
+// written. The methods of a nil manifest do nothing.
+func manifestFrom(state multistep.StateBag) *buildManifest {
+	m, _ := state.Get("manifest").(*buildManifest)
+	return m
+}
+
+// created records a temporary resource.
+func (m *buildManifest) created(resourceType, uuid string) {
+	if m == nil {
+		return
+	}
+	m.Resources = append(m.Resources, &manifestResource{
+		Type:      resourceType,
+		UUID:      uuid,
+		CreatedAt: m.now(),
+	})
+}
+
+// deleted records the deletion of a temporary resource.
+func (m *buildManifest) deleted(resourceType, uuid string) {
+	if m == nil {
+		return
+	}
+	for _, r := range m.Resources {
+		if r.Type == resourceType && r.UUID == uuid && r.DeletedAt == nil {
+			now := m.now()
+			r.DeletedAt = &now
+			return
+		}
+	}
+}
+
+// wrap returns the steps recording their durations in the manifest. The
+// runner of -on-error abort, ask and run-cleanup-provisioner wraps the steps
+// itself and tells them apart by their types, so their durations are not
+// recorded then.
+func (m *buildManifest) wrap(steps []multistep.Step, onError string) []multistep.Step {
+	if onError != "" && onError != "cleanup" {
+		return steps
+	}
+	wrapped := make([]multistep.Step, len(steps))
+	for i, step := range steps {
+		wrapped[i] = &manifestTimedStep{Step: step, manifest: m}
+	}
+	return wrapped
+}
+
+// finish records the outcome of the build.
+func (m *buildManifest) finish(artifact *Artifact, err error) {
+	m.FinishedAt = m.now()
+	if err != nil {
+		m.Error = err.Error()
+	}
+	if artifact == nil {
+		return
+	}
+	m.ArtifactType = artifact.ArtifactType
+	m.TemplateUUID = artifact.TemplateUUID
+	m.StorageUUID = artifact.StorageUUID
+	m.SnapshotUUID = artifact.SnapshotUUID
+	m.LocationUUID = artifact.LocationUUID
+	m.LocationName = artifact.LocationName
+}
+
+func (m *buildManifest) write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// manifestTimedStep records the duration of a step in the manifest.
+type manifestTimedStep struct {
+	multistep.Step
+	manifest *buildManifest
+}
+
+// InnerStepName returns the name of the step, which the debug runner shows
+// when it pauses after the step.
+func (s *manifestTimedStep) InnerStepName() string {
+	return reflect.Indirect(reflect.ValueOf(s.Step)).Type().Name()
+}
+
+func (s *manifestTimedStep) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	start := s.manifest.now()
+	action := s.Step.Run(ctx, state)
+	s.manifest.Steps = append(s.manifest.Steps, manifestStep{
+		Name:            strings.TrimPrefix(fmt.Sprintf("%T", s.Step), "*"),
+		DurationSeconds: s.manifest.now().Sub(start).Seconds(),
+	})
+	return action
+}
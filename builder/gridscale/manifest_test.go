@@ -0,0 +1,180 @@
+package gridscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// produceTestManifest returns a manifest whose clock advances by a second
+// on each reading.
+func produceTestManifest(c *Config) *buildManifest {
+	now := time.Date(2021, 6, 10, 0, 0, 0, 0, time.UTC)
+	return newBuildManifest(c, func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+}
+
+type manifestTestStep struct {
+	action multistep.StepAction
+}
+
+func (s *manifestTestStep) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	return s.action
+}
+
+func (s *manifestTestStep) Cleanup(state multistep.StateBag) {}
+
+func TestBuildManifest_resources(t *testing.T) {
+	m := produceTestManifest(produceTestConfig(map[string]interface{}{}))
+	state := StateBagMock{state: map[string]interface{}{"manifest": m}}
+	manifestFrom(state).created(manifestResourceServer, "server")
+	manifestFrom(state).created(manifestResourceStorage, "storage")
+	manifestFrom(state).deleted(manifestResourceServer, "server")
+	// Unknown resources are ignored
+	manifestFrom(state).deleted(manifestResourceIP, "server")
+
+	deletedAt := time.Date(2021, 6, 10, 0, 0, 4, 0, time.UTC)
+	want := []*manifestResource{
+		{
+			Type:      manifestResourceServer,
+			UUID:      "server",
+			CreatedAt: time.Date(2021, 6, 10, 0, 0, 2, 0, time.UTC),
+			DeletedAt: &deletedAt,
+		},
+		{
+			Type:      manifestResourceStorage,
+			UUID:      "storage",
+			CreatedAt: time.Date(2021, 6, 10, 0, 0, 3, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(m.Resources, want) {
+		t.Errorf("Resources = %+v, want %+v", m.Resources, want)
+	}
+
+	// Without a manifest, nothing is recorded
+	state = StateBagMock{state: map[string]interface{}{}}
+	manifestFrom(state).created(manifestResourceServer, "server")
+	manifestFrom(state).deleted(manifestResourceServer, "server")
+}
+
+func TestBuildManifest_wrap(t *testing.T) {
+	m := produceTestManifest(produceTestConfig(map[string]interface{}{}))
+	steps := m.wrap([]multistep.Step{
+		&manifestTestStep{action: multistep.ActionContinue},
+		&manifestTestStep{action: multistep.ActionHalt},
+	}, "")
+	state := StateBagMock{state: map[string]interface{}{}}
+	for _, step := range steps {
+		if got := step.Run(context.Background(), state); got != step.(*manifestTimedStep).Step.(*manifestTestStep).action {
+			t.Errorf("Run() = %v, want the action of the wrapped step", got)
+		}
+	}
+	want := []manifestStep{
+		{Name: "gridscale.manifestTestStep", DurationSeconds: 1},
+		{Name: "gridscale.manifestTestStep", DurationSeconds: 1},
+	}
+	if !reflect.DeepEqual(m.Steps, want) {
+		t.Errorf("Steps = %+v, want %+v", m.Steps, want)
+	}
+}
+
+func TestBuildManifest_wrapDebug(t *testing.T) {
+	// The debug runner pauses with the names of the wrapped steps
+	m := produceTestManifest(produceTestConfig(map[string]interface{}{}))
+	var paused []string
+	runner := &multistep.DebugRunner{
+		Steps: m.wrap([]multistep.Step{&manifestTestStep{action: multistep.ActionContinue}}, "cleanup"),
+		PauseFn: func(loc multistep.DebugLocation, name string, state multistep.StateBag) {
+			paused = append(paused, name)
+		},
+	}
+	runner.Run(context.Background(), new(multistep.BasicStateBag))
+	if want := []string{"manifestTestStep", "manifestTestStep"}; !reflect.DeepEqual(paused, want) {
+		t.Errorf("paused = %v, want %v", paused, want)
+	}
+	if len(m.Steps) != 1 {
+		t.Errorf("Steps = %+v, want the step recorded", m.Steps)
+	}
+
+	// The steps wrapped by -on-error are left alone
+	steps := []multistep.Step{&manifestTestStep{action: multistep.ActionContinue}}
+	if got := m.wrap(steps, "abort"); !reflect.DeepEqual(got, steps) {
+		t.Errorf("wrap() = %v, want the steps unwrapped", got)
+	}
+}
+
+func TestBuildManifest_write(t *testing.T) {
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		err      error
+		want     map[string]interface{}
+	}{
+		{
+			name: "template",
+			artifact: &Artifact{
+				ArtifactType: artifactTypeTemplate,
+				TemplateName: "packer",
+				TemplateUUID: "template UUID",
+				LocationUUID: "location UUID",
+				LocationName: "de/fra",
+			},
+			want: map[string]interface{}{
+				"artifact_type":        "template",
+				"template_name":        "packer",
+				"template_uuid":        "template UUID",
+				"location_uuid":        "location UUID",
+				"location_name":        "de/fra",
+				"source_template_uuid": "test",
+			},
+		},
+		{
+			name: "failed build",
+			err:  errors.New("error"),
+			want: map[string]interface{}{
+				"artifact_type":        "template",
+				"template_name":        "packer",
+				"source_template_uuid": "test",
+				"error":                "error",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := produceTestManifest(produceTestConfig(map[string]interface{}{
+				"template_name": "packer",
+			}))
+			m.finish(tt.artifact, tt.err)
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			if err := m.write(path); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["plugin_version"] == "" || got["started_at"] == nil || got["finished_at"] == nil {
+				t.Errorf("manifest = %v, want the plugin version and the build times", got)
+			}
+			for _, key := range []string{"plugin_version", "started_at", "finished_at", "resources", "steps"} {
+				delete(got, key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("manifest = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package gridscale
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gridscale/gsclient-go/v3"
+)
+
+const (
+	// defaultExportTimeout is how long an export to S3 may take.
+	defaultExportTimeout = 2 * time.Hour
+	// exportPollInterval is the interval of checking whether an export has
+	// finished.
+	exportPollInterval = 10 * time.Second
+)
+
+// ExportClient is the part of the API client needed to export snapshots and
+// templates.
+type ExportClient interface {
+	CreateStorage(ctx context.Context, body gsclient.StorageCreateRequest) (gsclient.CreateResponse, error)
+	DeleteStorage(ctx context.Context, id string) error
+	CreateStorageSnapshot(ctx context.Context, id string, body gsclient.StorageSnapshotCreateRequest) (gsclient.StorageSnapshotCreateResponse, error)
+	DeleteStorageSnapshot(ctx context.Context, storageID, snapshotID string) error
+	ExportStorageSnapshotToS3(ctx context.Context, storageID, snapshotID string, body gsclient.StorageSnapshotExportToS3Request) error
+}
+
+// S3Exporter exports snapshots and templates as gzipped images to an
+// S3-compatible object storage, through the snapshot export of the API.
+type S3Exporter struct {
+	Client ExportClient
+	Config S3Config
+	// Timeout of an export. Default: 2h.
+	Timeout time.Duration
+	// WaitForObject waits until the exported object exists. The default
+	// polls the bucket.
+	WaitForObject func(ctx context.Context, key string) error
+}
+
+// Prepare sets the defaults of the S3 config and validates it. name is the
+// name of the config block in the errors.
+func (s *S3Config) Prepare(name string) []error {
+	if s.Endpoint == "" {
+		s.Endpoint = defaultS3Endpoint
+	}
+	if s.Region == "" {
+		s.Region = defaultS3Region
+	}
+	if s.AccessKey == "" {
+		s.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if s.SecretKey == "" {
+		s.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	var errs []error
+	if s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+		errs = append(errs, fmt.Errorf("%s: bucket, access_key and secret_key have to be set", name))
+	}
+	return errs
+}
+
+// ObjectStoragePath returns the path of the object key, e.g. for
+// marketplace applications.
+func (e *S3Exporter) ObjectStoragePath(key string) string {
+	return fmt.Sprintf("s3://%s/%s", e.Config.Bucket, key)
+}
+
+// ObjectURL returns the URL of the object key in the bucket.
+func (e *S3Exporter) ObjectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(e.Config.Endpoint, "/"), e.Config.Bucket, key)
+}
+
+// ExportSnapshot exports the snapshot of the storage as the object key and
+// waits until the export has finished. An existing object key, e.g. of a
+// previous build, and its checksum are removed first.
+func (e *S3Exporter) ExportSnapshot(ctx context.Context, storageUUID, snapshotUUID, key string) error {
+	host, err := s3Host(e.Config.Endpoint)
+	if err != nil {
+		return err
+	}
+	// The wait would pass right away on a previous export under the key
+	if err := e.removeObjects(ctx, key, key+".sha256"); err != nil {
+		return err
+	}
+	err = e.Client.ExportStorageSnapshotToS3(ctx, storageUUID, snapshotUUID, gsclient.StorageSnapshotExportToS3Request{
+		S3auth: gsclient.S3auth{
+			Host:      host,
+			AccessKey: e.Config.AccessKey,
+			SecretKey: e.Config.SecretKey,
+		},
+		S3data: gsclient.S3data{
+			Host:     host,
+			Bucket:   e.Config.Bucket,
+			Filename: key,
+			Private:  true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("exporting the snapshot %s: %s", snapshotUUID, err)
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = defaultExportTimeout
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	wait := e.WaitForObject
+	if wait == nil {
+		wait = e.waitForS3Object
+	}
+	if err := wait(waitCtx, key); err != nil {
+		return fmt.Errorf("waiting for the export of the snapshot %s: %s", snapshotUUID, err)
+	}
+	return nil
+}
+
+// ExportStorage exports the storage as the object key, through a temporary
+// snapshot, which is removed afterwards.
+func (e *S3Exporter) ExportStorage(ctx context.Context, storageUUID, name, key string) (err error) {
+	snapshot, err := e.Client.CreateStorageSnapshot(ctx, storageUUID, gsclient.StorageSnapshotCreateRequest{
+		Name: name,
+	})
+	if err != nil {
+		return fmt.Errorf("creating a snapshot of the storage %s: %s", storageUUID, err)
+	}
+	defer func() {
+		if deleteErr := e.Client.DeleteStorageSnapshot(context.Background(), storageUUID, snapshot.ObjectUUID); deleteErr != nil && err == nil {
+			err = fmt.Errorf("removing the snapshot %s: %s", snapshot.ObjectUUID, deleteErr)
+		}
+	}()
+	return e.ExportSnapshot(ctx, storageUUID, snapshot.ObjectUUID, key)
+}
+
+// ExportTemplate exports the template as the object key. Templates cannot be
+// exported directly, the template is exported through a temporary storage,
+// which is removed afterwards.
+func (e *S3Exporter) ExportTemplate(ctx context.Context, template gsclient.Template, key string) (err error) {
+	props := template.Properties
+	storage, err := e.Client.CreateStorage(ctx, gsclient.StorageCreateRequest{
+		Capacity:    props.Capacity,
+		Name:        fmt.Sprintf("packer-export-%s", randString(10)),
+		StorageType: gsclient.InsaneStorageType,
+		Template: &gsclient.StorageTemplate{
+			TemplateUUID: props.ObjectUUID,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating a storage from the template %s: %s", props.ObjectUUID, err)
+	}
+	defer func() {
+		if deleteErr := e.Client.DeleteStorage(context.Background(), storage.ObjectUUID); deleteErr != nil && err == nil {
+			err = fmt.Errorf("removing the storage %s: %s", storage.ObjectUUID, deleteErr)
+		}
+	}()
+	return e.ExportStorage(ctx, storage.ObjectUUID, props.Name, key)
+}
+
+// WriteChecksum computes the SHA-256 checksum of the object key and writes
+// it to the sidecar object `<key>.sha256`, in the format of sha256sum. It
+// returns the checksum.
+func (e *S3Exporter) WriteChecksum(ctx context.Context, key string) (string, error) {
+	client, err := NewS3Client(e.Config)
+	if err != nil {
+		return "", err
+	}
+	object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(e.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %s", key, err)
+	}
+	defer object.Body.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, object.Body); err != nil {
+		return "", fmt.Errorf("reading %s: %s", key, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(e.Config.Bucket),
+		Key:    aws.String(key + ".sha256"),
+		Body:   strings.NewReader(fmt.Sprintf("%s  %s\n", sum, path.Base(key))),
+	})
+	if err != nil {
+		return "", fmt.Errorf("writing %s.sha256: %s", key, err)
+	}
+	return sum, nil
+}
+
+// removeObjects removes the objects keys from the bucket. Missing objects
+// are no error.
+func (e *S3Exporter) removeObjects(ctx context.Context, keys ...string) error {
+	client, err := NewS3Client(e.Config)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		_, err := client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(e.Config.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("removing the previous export %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// waitForS3Object polls the bucket until the object key exists.
+func (e *S3Exporter) waitForS3Object(ctx context.Context, key string) error {
+	client, err := NewS3Client(e.Config)
+	if err != nil {
+		return err
+	}
+	// The context limits the waiting, not the number of attempts
+	return client.WaitUntilObjectExistsWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(e.Config.Bucket),
+		Key:    aws.String(key),
+	},
+		request.WithWaiterDelay(request.ConstantWaiterDelay(exportPollInterval)),
+		request.WithWaiterMaxAttempts(0),
+	)
+}
+
+// NewS3Client returns a client of the bucket of cfg.
+func NewS3Client(cfg S3Config) (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(cfg.Endpoint),
+		Region:           aws.String(cfg.Region),
+		Credentials:      credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// s3Host returns the host of the endpoint, as expected by the snapshot
+// export.
+func s3Host(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("the endpoint has no host: " + endpoint)
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,351 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gridscale/gsclient-go/v3"
+)
+
+// exportClientMock records the calls of an export. The calls fail for the
+// object "fail".
+type exportClientMock struct {
+	calls         *[]string
+	export        gsclient.StorageSnapshotExportToS3Request
+	exportStorage string
+	// exported is called after a successful export, e.g. to put the object
+	// into an S3 stand-in.
+	exported func(key string)
+}
+
+func (m *exportClientMock) CreateStorage(ctx context.Context, body gsclient.StorageCreateRequest) (gsclient.CreateResponse, error) {
+	*m.calls = append(*m.calls, "CreateStorage "+body.Template.TemplateUUID)
+	if body.Template.TemplateUUID == "fail" {
+		return gsclient.CreateResponse{}, errors.New("error")
+	}
+	return gsclient.CreateResponse{ObjectUUID: "storage"}, nil
+}
+
+func (m *exportClientMock) DeleteStorage(ctx context.Context, id string) error {
+	*m.calls = append(*m.calls, "DeleteStorage "+id)
+	return nil
+}
+
+func (m *exportClientMock) CreateStorageSnapshot(ctx context.Context, id string, body gsclient.StorageSnapshotCreateRequest) (gsclient.StorageSnapshotCreateResponse, error) {
+	*m.calls = append(*m.calls, "CreateStorageSnapshot "+id)
+	return gsclient.StorageSnapshotCreateResponse{ObjectUUID: "snapshot"}, nil
+}
+
+func (m *exportClientMock) DeleteStorageSnapshot(ctx context.Context, storageID, snapshotID string) error {
+	*m.calls = append(*m.calls, "DeleteStorageSnapshot "+snapshotID)
+	return nil
+}
+
+func (m *exportClientMock) ExportStorageSnapshotToS3(ctx context.Context, storageID, snapshotID string, body gsclient.StorageSnapshotExportToS3Request) error {
+	*m.calls = append(*m.calls, "ExportStorageSnapshotToS3 "+snapshotID)
+	m.export = body
+	m.exportStorage = storageID
+	if body.S3data.Filename == "fail" {
+		return errors.New("error")
+	}
+	if m.exported != nil {
+		m.exported(body.S3data.Filename)
+	}
+	return nil
+}
+
+// newS3StandIn returns a local stand-in of an S3-compatible object storage,
+// with the path-style objects by path, e.g. `/bucket/key`.
+func newS3StandIn(t *testing.T) (*httptest.Server, map[string]string) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(content)
+		case http.MethodHead, http.MethodGet:
+			content, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, content)
+			}
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, objects
+}
+
+func TestS3Exporter_ExportTemplate(t *testing.T) {
+	tests := []struct {
+		name         string
+		templateUUID string
+		key          string
+		waitErr      error
+		wantCalls    []string
+		wantErr      bool
+	}{
+		{
+			name:         "success",
+			templateUUID: "template",
+			key:          "template.gz",
+			wantCalls: []string{
+				"CreateStorage template",
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 snapshot",
+				"wait template.gz",
+				"DeleteStorageSnapshot snapshot",
+				"DeleteStorage storage",
+			},
+		},
+		{
+			name:         "export fail",
+			templateUUID: "template",
+			key:          "fail",
+			wantCalls: []string{
+				"CreateStorage template",
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 snapshot",
+				"DeleteStorageSnapshot snapshot",
+				"DeleteStorage storage",
+			},
+			wantErr: true,
+		},
+		{
+			name:         "wait fail",
+			templateUUID: "template",
+			key:          "template.gz",
+			waitErr:      context.DeadlineExceeded,
+			wantCalls: []string{
+				"CreateStorage template",
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 snapshot",
+				"wait template.gz",
+				"DeleteStorageSnapshot snapshot",
+				"DeleteStorage storage",
+			},
+			wantErr: true,
+		},
+		{
+			name:         "create storage fail",
+			templateUUID: "fail",
+			key:          "template.gz",
+			wantCalls:    []string{"CreateStorage fail"},
+			wantErr:      true,
+		},
+	}
+	srv, _ := newS3StandIn(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			client := &exportClientMock{calls: &calls}
+			e := &S3Exporter{
+				Client: client,
+				Config: S3Config{
+					Endpoint:  srv.URL,
+					Region:    defaultS3Region,
+					Bucket:    "packer",
+					AccessKey: "access",
+					SecretKey: "secret",
+				},
+				WaitForObject: func(ctx context.Context, key string) error {
+					calls = append(calls, "wait "+key)
+					return tt.waitErr
+				},
+			}
+			template := gsclient.Template{Properties: gsclient.TemplateProperties{
+				ObjectUUID: tt.templateUUID,
+				Name:       "test",
+				Capacity:   10,
+			}}
+			err := e.ExportTemplate(context.Background(), template, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExportTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if tt.templateUUID == "fail" {
+				return
+			}
+			want := gsclient.StorageSnapshotExportToS3Request{
+				S3auth: gsclient.S3auth{Host: host, AccessKey: "access", SecretKey: "secret"},
+				S3data: gsclient.S3data{Host: host, Bucket: "packer", Filename: tt.key, Private: true},
+			}
+			if !reflect.DeepEqual(client.export, want) {
+				t.Errorf("export = %+v, want %+v", client.export, want)
+			}
+		})
+	}
+}
+
+func TestS3Exporter_ExportStorage(t *testing.T) {
+	srv, _ := newS3StandIn(t)
+	var calls []string
+	e := &S3Exporter{
+		Client: &exportClientMock{calls: &calls},
+		Config: S3Config{
+			Endpoint:  srv.URL,
+			Region:    defaultS3Region,
+			Bucket:    "packer",
+			AccessKey: "access",
+			SecretKey: "secret",
+		},
+		WaitForObject: func(ctx context.Context, key string) error {
+			calls = append(calls, "wait "+key)
+			return nil
+		},
+	}
+	if err := e.ExportStorage(context.Background(), "storage", "test", "storage.gz"); err != nil {
+		t.Errorf("ExportStorage() error = %v", err)
+	}
+	want := []string{
+		"CreateStorageSnapshot storage",
+		"ExportStorageSnapshotToS3 snapshot",
+		"wait storage.gz",
+		"DeleteStorageSnapshot snapshot",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestS3Exporter_ExportSnapshot(t *testing.T) {
+	// The object and its checksum of a previous build are at the key
+	srv, objects := newS3StandIn(t)
+	objects["/packer/packer.gz"] = "previous image"
+	objects["/packer/packer.gz.sha256"] = "previous checksum"
+	var calls []string
+	e := &S3Exporter{
+		Client: &exportClientMock{
+			calls: &calls,
+			exported: func(key string) {
+				if _, ok := objects["/packer/"+key]; ok {
+					t.Errorf("the previous export exists during the export")
+				}
+				if _, ok := objects["/packer/"+key+".sha256"]; ok {
+					t.Errorf("the previous checksum exists during the export")
+				}
+				objects["/packer/"+key] = "image"
+			},
+		},
+		Config: S3Config{
+			Endpoint:  srv.URL,
+			Region:    defaultS3Region,
+			Bucket:    "packer",
+			AccessKey: "access",
+			SecretKey: "secret",
+		},
+	}
+	if err := e.ExportSnapshot(context.Background(), "storage", "snapshot", "packer.gz"); err != nil {
+		t.Fatalf("ExportSnapshot() error = %v", err)
+	}
+	sum, err := e.WriteChecksum(context.Background(), "packer.gz")
+	if err != nil {
+		t.Fatalf("WriteChecksum() error = %v", err)
+	}
+	// sha256sum of "image"
+	if want := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"; sum != want {
+		t.Errorf("WriteChecksum() = %v, want %v", sum, want)
+	}
+}
+
+func TestS3Exporter_WriteChecksum(t *testing.T) {
+	srv, objects := newS3StandIn(t)
+	objects["/packer/images/test.gz"] = "image"
+	e := &S3Exporter{Config: S3Config{
+		Endpoint:  srv.URL,
+		Region:    defaultS3Region,
+		Bucket:    "packer",
+		AccessKey: "access",
+		SecretKey: "secret",
+	}}
+	if err := e.waitForS3Object(context.Background(), "images/test.gz"); err != nil {
+		t.Fatalf("waitForS3Object() error = %v", err)
+	}
+	sum, err := e.WriteChecksum(context.Background(), "images/test.gz")
+	if err != nil {
+		t.Fatalf("WriteChecksum() error = %v", err)
+	}
+	// sha256sum of "image"
+	want := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	if sum != want {
+		t.Errorf("WriteChecksum() = %v, want %v", sum, want)
+	}
+	if got := objects["/packer/images/test.gz.sha256"]; got != want+"  test.gz\n" {
+		t.Errorf("sidecar = %q, want %q", got, want+"  test.gz\n")
+	}
+	if _, err := e.WriteChecksum(context.Background(), "missing.gz"); err == nil {
+		t.Errorf("WriteChecksum() of a missing object error = nil, want an error")
+	}
+	if got, want := e.ObjectURL("images/test.gz"), srv.URL+"/packer/images/test.gz"; got != want {
+		t.Errorf("ObjectURL() = %v, want %v", got, want)
+	}
+}
+
+func TestS3Config_Prepare(t *testing.T) {
+	s := S3Config{Bucket: "packer", AccessKey: "access", SecretKey: "secret"}
+	if errs := s.Prepare("s3"); len(errs) != 0 {
+		t.Errorf("Prepare() errors = %v, want none", errs)
+	}
+	if s.Endpoint != defaultS3Endpoint || s.Region != defaultS3Region {
+		t.Errorf("Prepare() = %+v, want the default endpoint and region", s)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	s = S3Config{Bucket: "packer"}
+	if errs := s.Prepare("s3"); len(errs) != 1 {
+		t.Errorf("Prepare() errors = %v, want 1 error", errs)
+	}
+	// The keys can be set in the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s = S3Config{Bucket: "packer"}
+	if errs := s.Prepare("s3"); len(errs) != 0 {
+		t.Errorf("Prepare() errors = %v, want none", errs)
+	}
+	if s.AccessKey != "access" || s.SecretKey != "secret" {
+		t.Errorf("Prepare() = %+v, want the keys of the environment", s)
+	}
+}
+
+func Test_s3Host(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "https://gos3.io", want: "gos3.io"},
+		{endpoint: "http://127.0.0.1:9000", want: "127.0.0.1:9000"},
+		{endpoint: "gos3.io", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := s3Host(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("s3Host(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("s3Host(%q) = %v, want %v", tt.endpoint, got, tt.want)
+		}
+	}
+}
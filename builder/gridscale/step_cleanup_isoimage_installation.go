@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/gridscale/gsclient-go/v3"
@@ -11,33 +12,29 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
-const serverShutdownPostInstallationTimeoutSecs = 30
+const (
+	serverShutdownPostInstallationTimeoutSecs = 30
+	isoInstallWaitPowerOff                    = "poweroff"
+	defaultInstallTimeout                     = 60 * time.Minute
+	defaultServerPowerPollInterval            = 10 * time.Second
+)
 
 type stepCleanupISOImageInstallation struct {
 	relClient gsclient.ServerIsoImageRelationOperator
 	sClient   gsclient.ServerOperator
 	config    *Config
 	ui        packer.Ui
+	// pollInterval is the interval of checking whether the server has been
+	// powered off, if iso_install_wait is poweroff.
+	pollInterval time.Duration
 }
 
 func (s stepCleanupISOImageInstallation) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	relClient := s.relClient
 	ui := s.ui
 	c := s.config
-	if c.IsoImageUUID == "" && c.IsoImageURL == "" {
-		ui.Say("No ISO image is requested. Skipping cleaning ISO image installation...")
-		return multistep.ActionContinue
-	}
-	// Get ISO image UUID
-	isoImageUUID, ok := state.Get("iso_image_uuid").(string)
-	if !ok {
-		err := errors.New("cannot convert iso_image_uuid to string")
-		ui.Error(err.Error())
-		state.Put("error", err)
-		return multistep.ActionHalt
-	}
-	if isoImageUUID == "" {
-		ui.Say("No ISO image UUID detected. Skipping cleaning ISO image installation...")
+	if !c.hasBootISOImage() {
+		ui.Say("No boot ISO image is requested. Skipping cleaning ISO image installation...")
 		return multistep.ActionContinue
 	}
 	// Get server UUID
@@ -55,59 +52,120 @@ func (s stepCleanupISOImageInstallation) Run(ctx context.Context, state multiste
 		return multistep.ActionHalt
 	}
 
-	// Unlink server and ISO image
-	serverISOImageLinked, ok := state.Get("server_iso_image_linked").(bool)
+	// Wait for the installer to power off the server
+	serverOff := false
+	if c.ISOInstallWait == isoInstallWaitPowerOff {
+		ui.Say(fmt.Sprintf("Waiting up to %s for the installation to power off server (%s)...", c.InstallTimeout, serverUUID))
+		pollInterval := s.pollInterval
+		if pollInterval == 0 {
+			pollInterval = defaultServerPowerPollInterval
+		}
+		err := waitForServerPowerOff(ctx, s.sClient, serverUUID, pollInterval, c.InstallTimeout)
+		if err != nil {
+			err := fmt.Errorf("Error waiting for the installation to finish: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Server (%s) has been powered off by the installation", serverUUID))
+		serverOff = true
+	}
+
+	// Unlink server and ISO images
+	linked, ok := state.Get("server_iso_images_linked").([]string)
 	if !ok {
-		err := errors.New("cannot convert server_iso_image_linked to boolean")
+		err := errors.New("cannot convert server_iso_images_linked to []string")
 		ui.Error(err.Error())
 		state.Put("error", err)
 		return multistep.ActionHalt
 	}
-	if !serverISOImageLinked {
-		ui.Say("the server is not linked with the ISO image.")
+	if len(linked) == 0 {
+		ui.Say("the server is not linked with any ISO image.")
 		return multistep.ActionContinue
 	}
-	ui.Say(fmt.Sprintf("Removing ISO image (%s) from server (%s)...", isoImageUUID, serverUUID))
-	err := relClient.UnlinkIsoImage(context.Background(), serverUUID, isoImageUUID)
-	if err != nil {
-		ui.Error(fmt.Sprintf(
-			"Could not unlink server (%s) and ISO image (%s). Please unlink them manually: %s", serverUUID, isoImageUUID, err))
-		return multistep.ActionHalt
+	for len(linked) > 0 {
+		isoImageUUID := linked[0]
+		ui.Say(fmt.Sprintf("Removing ISO image (%s) from server (%s)...", isoImageUUID, serverUUID))
+		err := relClient.UnlinkIsoImage(context.Background(), serverUUID, isoImageUUID)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Could not unlink server (%s) and ISO image (%s). Please unlink them manually: %s", serverUUID, isoImageUUID, err))
+			return multistep.ActionHalt
+		}
+		linked = linked[1:]
+		state.Put("server_iso_images_linked", linked)
+	}
+
+	if !c.hasCommunicator() {
+		ui.Say(fmt.Sprintf("Removed ISO images from server (%s). No communicator is used, skipping restarting the server...", serverUUID))
+		return multistep.ActionContinue
 	}
 
 	// Restart the server
 	sClient := s.sClient
-	// set the shutdown timeout specifically
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownPostInstallationTimeoutSecs*time.Second)
-	defer cancel()
-	err = sClient.ShutdownServer(shutdownCtx, serverUUID)
-	if err != nil && err != shutdownCtx.Err() {
-		err := fmt.Errorf("Error shutting down server: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-	// if the server cannot be shutdown gracefully, try to turn it off
-	if err != nil && err == shutdownCtx.Err() {
-		err := sClient.StopServer(context.Background(), serverUUID)
-		if err != nil {
+	if !serverOff {
+		// set the shutdown timeout specifically
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownPostInstallationTimeoutSecs*time.Second)
+		defer cancel()
+		err := sClient.ShutdownServer(shutdownCtx, serverUUID)
+		if err != nil && err != shutdownCtx.Err() {
+			err := fmt.Errorf("Error shutting down server: %s", err)
 			state.Put("error", err)
-			ui.Error(fmt.Sprintf(
-				"Error shutdown server: %s", err))
+			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
+		// if the server cannot be shutdown gracefully, try to turn it off
+		if err != nil && err == shutdownCtx.Err() {
+			err := sClient.StopServer(context.Background(), serverUUID)
+			if err != nil {
+				state.Put("error", err)
+				ui.Error(fmt.Sprintf(
+					"Error shutdown server: %s", err))
+				return multistep.ActionHalt
+			}
+		}
 	}
 	// Start the server
-	err = sClient.StartServer(context.Background(), serverUUID)
+	err := sClient.StartServer(context.Background(), serverUUID)
 	if err != nil {
 		err := fmt.Errorf("Error starting server: %s", err)
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
-	ui.Say(fmt.Sprintf("Successfully removed ISO image (%s)and restarted server (%s)", isoImageUUID, serverUUID))
+	ui.Say(fmt.Sprintf("Successfully removed ISO images and restarted server (%s)", serverUUID))
 	return multistep.ActionContinue
 }
 
+// waitForServerPowerOff polls the power state of the server until it is
+// off, the timeout expires or the build is cancelled.
+func waitForServerPowerOff(ctx context.Context, client gsclient.ServerOperator, serverUUID string, interval, timeout time.Duration) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Errors are retried, a single failing API call must not abort
+		// a long running installation
+		server, err := client.GetServer(context.Background(), serverUUID)
+		if err != nil {
+			log.Printf("[DEBUG] error getting power state of server (%s): %s", serverUUID, err)
+		} else if !server.Properties.Power {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				return fmt.Errorf("server (%s) is not powered off after %s: %s", serverUUID, timeout, err)
+			}
+			return fmt.Errorf("server (%s) is still powered on after %s", serverUUID, timeout)
+		}
+	}
+}
+
 func (s stepCleanupISOImageInstallation) Cleanup(state multistep.StateBag) {
 }
@@ -0,0 +1,204 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// poweringOffServerOperatorMock reports the server as powered on until it
+// has been polled offAfter times.
+type poweringOffServerOperatorMock struct {
+	ServerOperatorMock
+	polls    *int
+	offAfter int
+}
+
+func (s poweringOffServerOperatorMock) GetServer(ctx context.Context, id string) (gsclient.Server, error) {
+	*s.polls++
+	if *s.polls == 1 {
+		// A failing API call is retried
+		return gsclient.Server{}, errors.New("error")
+	}
+	return gsclient.Server{
+		Properties: gsclient.ServerProperties{
+			ObjectUUID: id,
+			Power:      *s.polls <= s.offAfter,
+		},
+	}, nil
+}
+
+func Test_stepCleanupISOImageInstallation_Run(t *testing.T) {
+	type fields struct {
+		config   *Config
+		offAfter int
+	}
+	type args struct {
+		ctx   context.Context
+		state multistep.StateBag
+	}
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		want      multistep.StepAction
+		wantPolls int
+		message   string
+	}{
+		{
+			name: "restart right away",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid": "test",
+				}),
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "ShutdownSuccessStartSuccess",
+					"server_iso_images_linked": []string{"success"},
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantPolls: 0,
+			message:   "Successfully removed ISO images and restarted server (ShutdownSuccessStartSuccess)",
+		},
+		{
+			name: "no boot ISO image",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"iso_images": []map[string]interface{}{
+						{"uuid": "test"},
+					},
+				}),
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "ShutdownSuccessStartSuccess",
+					"server_iso_images_linked": []string{},
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantPolls: 0,
+			message:   "No boot ISO image is requested. Skipping cleaning ISO image installation...",
+		},
+		{
+			name: "wait for poweroff",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid":    "test",
+					"iso_install_wait": "poweroff",
+				}),
+				offAfter: 3,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "StartSuccess",
+					"server_iso_images_linked": []string{"success"},
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantPolls: 4,
+			message:   "Successfully removed ISO images and restarted server (StartSuccess)",
+		},
+		{
+			name: "no communicator",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid": "test",
+					"communicator":  "none",
+				}),
+				offAfter: 1,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "StartFail",
+					"server_iso_images_linked": []string{"success"},
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantPolls: 2,
+			message:   "Removed ISO images from server (StartFail). No communicator is used, skipping restarting the server...",
+		},
+		{
+			name: "install_timeout expires",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid":    "test",
+					"iso_install_wait": "poweroff",
+					"install_timeout":  "20ms",
+				}),
+				offAfter: 1 << 30,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "StartSuccess",
+					"server_iso_images_linked": []string{"success"},
+				}},
+			},
+			want:    multistep.ActionHalt,
+			message: "Error waiting for the installation to finish: server (StartSuccess) is still powered on after 20ms",
+		},
+		{
+			name: "build cancelled",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid":    "test",
+					"iso_install_wait": "poweroff",
+				}),
+				offAfter: 1 << 30,
+			},
+			args: args{
+				ctx: cancelledCtx,
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":              "StartSuccess",
+					"server_iso_images_linked": []string{"success"},
+				}},
+			},
+			want:    multistep.ActionHalt,
+			message: "Error waiting for the installation to finish: context canceled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			ui := &uiMock{}
+			s := stepCleanupISOImageInstallation{
+				relClient: ServerIsoImageRelationOperatorMock{},
+				sClient: poweringOffServerOperatorMock{
+					polls:    &polls,
+					offAfter: tt.fields.offAfter,
+				},
+				config:       tt.fields.config,
+				ui:           ui,
+				pollInterval: time.Millisecond,
+			}
+			if got := s.Run(tt.args.ctx, tt.args.state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			message := ui.sayMessage
+			if tt.want == multistep.ActionHalt {
+				message = ui.errorMessage
+			}
+			if message != tt.message {
+				t.Errorf("message = %v, want %v", message, tt.message)
+			}
+			if tt.want == multistep.ActionContinue && polls != tt.wantPolls {
+				t.Errorf("polls = %v, want %v", polls, tt.wantPolls)
+			}
+			if linked := tt.args.state.Get("server_iso_images_linked").([]string); tt.want == multistep.ActionContinue && len(linked) != 0 {
+				t.Errorf("server_iso_images_linked = %v, want none", linked)
+			}
+		})
+	}
+}
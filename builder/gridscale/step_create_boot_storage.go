@@ -7,6 +7,7 @@ import (
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 type stepCreateBootStorage struct {
@@ -30,24 +31,33 @@ func (s *stepCreateBootStorage) Run(ctx context.Context, state multistep.StateBa
 		Name:        c.ServerName,
 		StorageType: gsclient.InsaneStorageType,
 	}
+	if c.ArtifactType == artifactTypeStorage && !c.SecondaryStorage {
+		// The storage is the artifact
+		storageCreateReq.Name = c.TemplateName
+	}
 	if c.BaseTemplateUUID != "" {
-		sshKeyUUID, ok := state.Get("ssh_key_uuid").(string)
-		if !ok {
-			err := errors.New("cannot convert ssh_key_uuid to string")
-			ui.Error(err.Error())
-			state.Put("error", err)
-			return multistep.ActionHalt
-		}
-		if sshKeyUUID == "" {
-			ui.Error("No SSH key UUID detected.")
-			state.Put("error", "No SSH key UUID detected.")
-			return multistep.ActionHalt
+		var sshKeys []string
+		// Without a communicator, no temporary SSH key is created
+		if c.hasCommunicator() {
+			sshKeyUUID, ok := state.Get("ssh_key_uuid").(string)
+			if !ok {
+				err := errors.New("cannot convert ssh_key_uuid to string")
+				ui.Error(err.Error())
+				state.Put("error", err)
+				return multistep.ActionHalt
+			}
+			if sshKeyUUID == "" {
+				ui.Error("No SSH key UUID detected.")
+				state.Put("error", "No SSH key UUID detected.")
+				return multistep.ActionHalt
+			}
+			sshKeys = []string{sshKeyUUID}
 		}
 		storageCreateReq.Template = &gsclient.StorageTemplate{
 			Password:     c.Comm.SSHPassword,
 			PasswordType: gsclient.PlainPasswordType,
 			Hostname:     c.Hostname,
-			Sshkeys:      []string{sshKeyUUID},
+			Sshkeys:      sshKeys,
 			TemplateUUID: c.BaseTemplateUUID,
 		}
 	}
@@ -60,6 +70,14 @@ func (s *stepCreateBootStorage) Run(ctx context.Context, state multistep.StateBa
 		return multistep.ActionHalt
 	}
 	state.Put("boot_storage_uuid", storage.ObjectUUID)
+	// The build output is on the boot storage, unless a secondary storage is
+	// created
+	state.Put("artifact_storage_uuid", storage.ObjectUUID)
+	manifestFrom(state).created(manifestResourceStorage, storage.ObjectUUID)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("StorageUUID", storage.ObjectUUID)
+	generatedData.Put("SourceTemplateUUID", c.BaseTemplateUUID)
+	generatedData.Put("Password", c.Comm.SSHPassword)
 	ui.Say(fmt.Sprintf("a boot storage (%s) has been created", storage.ObjectUUID))
 	return multistep.ActionContinue
 }
@@ -79,6 +97,11 @@ func (s *stepCreateBootStorage) Cleanup(state multistep.StateBag) {
 		ui.Say("No boot storage UUID detected.")
 		return
 	}
+	if keepsStorage(s.config, state, bootStorageUUID) {
+		// The storage is the artifact, or holds the snapshot
+		ui.Say(fmt.Sprintf("Keeping the boot storage (%s)", bootStorageUUID))
+		return
+	}
 	ui.Say(fmt.Sprintf("Destroying the boot storage (%s)...", bootStorageUUID))
 	err := client.DeleteStorage(context.Background(), bootStorageUUID)
 	if err != nil {
@@ -86,5 +109,6 @@ func (s *stepCreateBootStorage) Cleanup(state multistep.StateBag) {
 			"Error destroying boot storage (%s). Please destroy it manually: %s", bootStorageUUID, err))
 		return
 	}
+	manifestFrom(state).deleted(manifestResourceStorage, bootStorageUUID)
 	ui.Say(fmt.Sprintf("Destroyed the boot storage (%s)", bootStorageUUID))
 }
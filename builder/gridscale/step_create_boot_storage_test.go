@@ -86,6 +86,61 @@ func Test_stepCreateBootStorage_Cleanup(t *testing.T) {
 			success: true,
 			message: "No boot storage UUID detected.",
 		},
+		{
+			name: "keep the storage artifact",
+			fields: fields{
+				client: StorageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"artifact_type": "storage",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"boot_storage_uuid":     "fail",
+					"artifact_storage_uuid": "fail",
+				}},
+			},
+			success: true,
+			message: "Keeping the boot storage (fail)",
+		},
+		{
+			name: "destroy the boot storage if the secondary storage is the artifact",
+			fields: fields{
+				client: StorageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"artifact_type":     "storage",
+					"secondary_storage": true,
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"boot_storage_uuid":     "success",
+					"artifact_storage_uuid": "secondary",
+				}},
+			},
+			success: true,
+			message: "Destroyed the boot storage (success)",
+		},
+		{
+			name: "destroy the storage of a halted build",
+			fields: fields{
+				client: StorageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"artifact_type": "snapshot",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"boot_storage_uuid":   "success",
+					multistep.StateHalted: true,
+				}},
+			},
+			success: true,
+			message: "Destroyed the boot storage (success)",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -142,6 +197,25 @@ func Test_stepCreateBootStorage_Run(t *testing.T) {
 			},
 			want: multistep.ActionContinue,
 		},
+		{
+			name: "storage artifact named after the template",
+			fields: fields{
+				client: StorageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"server_name":   "fail",
+					"template_name": "success",
+					"artifact_type": "storage",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"ssh_key_uuid": "test",
+				}},
+			},
+			want: multistep.ActionContinue,
+		},
 		{
 			name: "API call fail",
 			fields: fields{
@@ -210,6 +284,13 @@ func Test_stepCreateBootStorage_Run(t *testing.T) {
 				if uuid != "test" {
 					t.Errorf("boot_storage_uuid = %v, want test", uuid)
 				}
+				generatedData := tt.args.state.Get("generated_data").(map[string]interface{})
+				if generatedData["StorageUUID"] != "test" {
+					t.Errorf("StorageUUID = %v, want test", generatedData["StorageUUID"])
+				}
+				if generatedData["SourceTemplateUUID"] != tt.fields.config.BaseTemplateUUID {
+					t.Errorf("SourceTemplateUUID = %v, want %v", generatedData["SourceTemplateUUID"], tt.fields.config.BaseTemplateUUID)
+				}
 			}
 		})
 	}
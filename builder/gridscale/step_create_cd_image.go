@@ -0,0 +1,75 @@
+package gridscale
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type stepCreateCDImage struct {
+	config *Config
+	ui     packer.Ui
+}
+
+func (s *stepCreateCDImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	c := s.config
+	ui := s.ui
+	if len(c.CDFiles) == 0 && len(c.CDContent) == 0 {
+		ui.Say("No CD is requested. Skipping creating a CD image...")
+		return multistep.ActionContinue
+	}
+	ui.Say("Creating a CD image...")
+	path, err := createCDImage(c)
+	if err != nil {
+		err := fmt.Errorf("Error creating CD image: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("cd_image_path", path)
+	ui.Say(fmt.Sprintf("a CD image (%s) has been created", path))
+	return multistep.ActionContinue
+}
+
+// createCDImage writes the CD of cd_files and cd_content to a temporary file
+// and returns its path.
+func createCDImage(c *Config) (string, error) {
+	img := newCDImage(c.CDLabel)
+	for _, file := range c.CDFiles {
+		if err := img.addLocalPath(file); err != nil {
+			return "", err
+		}
+	}
+	for p, content := range c.CDContent {
+		if err := img.addContent(p, []byte(content)); err != nil {
+			return "", err
+		}
+	}
+	f, err := os.CreateTemp("", "packer-cd-*.iso")
+	if err != nil {
+		return "", err
+	}
+	err = img.writeTo(f, time.Now())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (s *stepCreateCDImage) Cleanup(state multistep.StateBag) {
+	path, _ := state.Get("cd_image_path").(string)
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		s.ui.Error(fmt.Sprintf("Error removing CD image (%s): %s", path, err))
+	}
+}
@@ -0,0 +1,78 @@
+package gridscale
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func Test_stepCreateCDImage(t *testing.T) {
+	isoFile := produceTestISOFile(t, "vendor-data")
+	tests := []struct {
+		name    string
+		config  *Config
+		files   map[string]string
+		message string
+	}{
+		{
+			name:    "no CD",
+			config:  produceTestConfig(map[string]interface{}{}),
+			message: "No CD is requested. Skipping creating a CD image...",
+		},
+		{
+			name: "cd_files and cd_content",
+			config: produceTestConfig(map[string]interface{}{
+				"cd_files": []string{isoFile},
+				"cd_content": map[string]string{
+					"user-data": "#cloud-config",
+				},
+				"cd_label": "cidata",
+			}),
+			files: map[string]string{
+				"vendor-data": "iso",
+				"user-data":   "#cloud-config",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := &uiMock{}
+			s := &stepCreateCDImage{
+				config: tt.config,
+				ui:     ui,
+			}
+			state := StateBagMock{state: map[string]interface{}{}}
+			if got := s.Run(context.Background(), state); got != multistep.ActionContinue {
+				t.Fatalf("Run() = %v, want %v, error = %v", got, multistep.ActionContinue, ui.errorMessage)
+			}
+			path, _ := state.Get("cd_image_path").(string)
+			if tt.files == nil {
+				if ui.sayMessage != tt.message {
+					t.Errorf("message = %v, want %v", ui.sayMessage, tt.message)
+				}
+				if path != "" {
+					t.Errorf("cd_image_path = %v, want none", path)
+				}
+				return
+			}
+			img, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading the CD image: %v", err)
+			}
+			label, files := readTestCDImage(t, img, true)
+			if label != "cidata" {
+				t.Errorf("label = %v, want cidata", label)
+			}
+			if !reflect.DeepEqual(files, tt.files) {
+				t.Errorf("files = %v, want %v", files, tt.files)
+			}
+			s.Cleanup(state)
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("CD image %s is not removed: %v", path, err)
+			}
+		})
+	}
+}
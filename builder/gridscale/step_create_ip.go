@@ -7,6 +7,7 @@ import (
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 type stepCreateIPAddr struct {
@@ -35,7 +36,10 @@ func (s *stepCreateIPAddr) Run(ctx context.Context, state multistep.StateBag) mu
 		return multistep.ActionHalt
 	}
 	state.Put("ip_addr_uuid", ip.ObjectUUID)
+	manifestFrom(state).created(manifestResourceIP, ip.ObjectUUID)
 	state.Put("server_ip", ip.IP)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("ServerIP", ip.IP)
 	ui.Say(fmt.Sprintf("an IP address %s (%s) has been created", ip.IP, ip.ObjectUUID))
 	return multistep.ActionContinue
 }
@@ -62,5 +66,6 @@ func (s *stepCreateIPAddr) Cleanup(state multistep.StateBag) {
 			"Error destroying IP address (%s). Please destroy it manually: %s", ipAddrUUID, err))
 		return
 	}
+	manifestFrom(state).deleted(manifestResourceIP, ipAddrUUID)
 	ui.Say(fmt.Sprintf("Destroyed the IP address (%s)", ipAddrUUID))
 }
@@ -229,6 +229,10 @@ func Test_stepCreateIPAddr_Run(t *testing.T) {
 				if ip != "test" {
 					t.Errorf("server_ip = %v, want test", uuid)
 				}
+				generatedData := tt.args.state.Get("generated_data").(map[string]interface{})
+				if generatedData["ServerIP"] != "test" {
+					t.Errorf("ServerIP = %v, want test", generatedData["ServerIP"])
+				}
 			}
 		})
 	}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
+const (
+	// isoCacheLabel marks the ISO images kept for later builds by iso_cache.
+	isoCacheLabel = "packer-iso-cache"
+	// isoChecksumLabelPrefix prefixes the label holding the checksum of a
+	// cached ISO image.
+	isoChecksumLabelPrefix = "packer-iso-checksum:"
+)
+
 type stepCreateISOImage struct {
 	client gsclient.ISOImageOperator
 	config *Config
@@ -20,17 +29,67 @@ func (s *stepCreateISOImage) Run(ctx context.Context, state multistep.StateBag)
 	client := s.client
 	c := s.config
 	ui := s.ui
-	// If IsoImageUUID is set, use it instead of creating a new ISO image
-	if c.IsoImageUUID != "" {
-		ui.Say("Getting ISO image UUID from config...")
-		state.Put("iso_image_uuid", c.IsoImageUUID)
+	if len(c.ISOImages) == 0 {
+		ui.Say("No ISO image is requested. Skipping creating an ISO image...")
 		return multistep.ActionContinue
 	}
-	if c.IsoImageURL != "" {
+	// The UUIDs are in the order of the requested ISO images. The list is
+	// updated after each ISO image, so that cleanup finds the created ones.
+	isoImageUUIDs := []string{}
+	state.Put("iso_image_uuids", isoImageUUIDs)
+	isoFileURLs, _ := state.Get("iso_file_urls").([]string)
+	for i, iso := range c.ISOImages {
+		// If the UUID is set, use it instead of creating a new ISO image
+		if iso.UUID != "" {
+			ui.Say(fmt.Sprintf("Getting ISO image UUID (%s) from config...", iso.UUID))
+			isoImageUUIDs = append(isoImageUUIDs, iso.UUID)
+			state.Put("iso_image_uuids", isoImageUUIDs)
+			continue
+		}
+		// Reuse an ISO image cached by a previous build
+		if c.cachesISOImage(iso) {
+			isoImageUUID, err := findCachedISOImage(client, iso)
+			if err != nil {
+				ui.Error(fmt.Sprintf(
+					"Error looking up cached ISO image: %s", err))
+				state.Put("error", err)
+				return multistep.ActionHalt
+			}
+			if isoImageUUID != "" {
+				ui.Say(fmt.Sprintf("Reusing the cached ISO image (%s) of %s", isoImageUUID, iso.URL))
+				isoImageUUIDs = append(isoImageUUIDs, isoImageUUID)
+				state.Put("iso_image_uuids", isoImageUUIDs)
+				continue
+			}
+		}
+		if iso.Checksum != "" && iso.Checksum != isoChecksumNone {
+			ui.Say(fmt.Sprintf("Verifying the checksum of %s...", iso.URL))
+			err := verifyISOChecksum(ctx, http.DefaultClient, iso.URL, iso.Checksum)
+			if err != nil {
+				err := fmt.Errorf("Error verifying ISO image checksum: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+		// Local ISO images are created from their upload
+		sourceURL := iso.URL
+		if iso.isLocal() {
+			if i >= len(isoFileURLs) || isoFileURLs[i] == "" {
+				err := fmt.Errorf("the local ISO image (%d) has not been uploaded", i)
+				ui.Error(err.Error())
+				state.Put("error", err)
+				return multistep.ActionHalt
+			}
+			sourceURL = isoFileURLs[i]
+		}
 		ui.Say("Creating an ISO image...")
 		isoImageCreateRequest := gsclient.ISOImageCreateRequest{
 			Name:      c.ServerName,
-			SourceURL: c.IsoImageURL,
+			SourceURL: sourceURL,
+		}
+		if c.cachesISOImage(iso) {
+			isoImageCreateRequest.Labels = isoCacheLabels(iso)
 		}
 		isoImage, err := client.CreateISOImage(context.Background(), isoImageCreateRequest)
 		if err != nil {
@@ -40,45 +99,87 @@ func (s *stepCreateISOImage) Run(ctx context.Context, state multistep.StateBag)
 			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
-		state.Put("iso_image_uuid", isoImage.ObjectUUID)
+		isoImageUUIDs = append(isoImageUUIDs, isoImage.ObjectUUID)
+		state.Put("iso_image_uuids", isoImageUUIDs)
+		manifestFrom(state).created(manifestResourceISOImage, isoImage.ObjectUUID)
 		ui.Say(fmt.Sprintf("an ISO image (%s) has been created", isoImage.ObjectUUID))
-		return multistep.ActionContinue
 	}
-	ui.Say("No ISO image is requested. Skipping creating an ISO image...")
 	return multistep.ActionContinue
 }
 
+// isoCacheLabels returns the labels of a cached ISO image.
+func isoCacheLabels(iso ISOImageConfig) []string {
+	return []string{isoCacheLabel, isoChecksumLabelPrefix + iso.Checksum}
+}
+
+// findCachedISOImage returns the UUID of an ISO image cached by a previous
+// build with the same source URL and checksum, or "" if there is none.
+func findCachedISOImage(client gsclient.ISOImageOperator, iso ISOImageConfig) (string, error) {
+	isoImages, err := client.GetISOImageList(context.Background())
+	if err != nil {
+		return "", err
+	}
+	for _, isoImage := range isoImages {
+		props := isoImage.Properties
+		if props.SourceURL != iso.URL {
+			continue
+		}
+		matches := true
+		for _, label := range isoCacheLabels(iso) {
+			matches = matches && containsString(props.Labels, label)
+		}
+		if matches {
+			return props.ObjectUUID, nil
+		}
+	}
+	return "", nil
+}
+
 func (s *stepCreateISOImage) Cleanup(state multistep.StateBag) {
 	client := s.client
 	ui := s.ui
 	c := s.config
-	// If IsoImageUUID is set, skip removing ISO image
-	if c.IsoImageUUID != "" {
-		return
-	}
 	// If ISO image is not used, skip.
-	if c.IsoImageUUID == "" && c.IsoImageURL == "" {
+	if len(c.ISOImages) == 0 {
 		ui.Say("No ISO image is requested. Skipping removing ISO image...")
 		return
 	}
-	// Destroy the requested ISO image
-	isoImageUUID, ok := state.Get("iso_image_uuid").(string)
+	// If only existing ISO images are used, skip removing them
+	created := false
+	for _, iso := range c.ISOImages {
+		created = created || iso.UUID == ""
+	}
+	if !created {
+		return
+	}
+	// Destroy the requested ISO images
+	isoImageUUIDs, ok := state.Get("iso_image_uuids").([]string)
 	if !ok {
-		err := errors.New("cannot convert iso_image_uuid to string")
+		err := errors.New("cannot convert iso_image_uuids to []string")
 		ui.Error(err.Error())
 		state.Put("error", err)
 		return
 	}
-	if isoImageUUID == "" {
+	if len(isoImageUUIDs) == 0 {
 		ui.Say("No ISO image UUID detected.")
 		return
 	}
-	ui.Say(fmt.Sprintf("Destroying the ISO image (%s)...", isoImageUUID))
-	err := client.DeleteISOImage(context.Background(), isoImageUUID)
-	if err != nil {
-		ui.Error(fmt.Sprintf(
-			"Error destroying ISO image (%s). Please destroy it manually: %s", isoImageUUID, err))
-		return
+	for i, isoImageUUID := range isoImageUUIDs {
+		if c.ISOImages[i].UUID != "" {
+			continue
+		}
+		if c.cachesISOImage(c.ISOImages[i]) {
+			ui.Say(fmt.Sprintf("Keeping the cached ISO image (%s)", isoImageUUID))
+			continue
+		}
+		ui.Say(fmt.Sprintf("Destroying the ISO image (%s)...", isoImageUUID))
+		err := client.DeleteISOImage(context.Background(), isoImageUUID)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error destroying ISO image (%s). Please destroy it manually: %s", isoImageUUID, err))
+			continue
+		}
+		manifestFrom(state).deleted(manifestResourceISOImage, isoImageUUID)
+		ui.Say(fmt.Sprintf("Destroyed the ISO image (%s)", isoImageUUID))
 	}
-	ui.Say(fmt.Sprintf("Destroyed the ISO image (%s)", isoImageUUID))
 }
@@ -2,17 +2,54 @@ package gridscale
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
+// testISOChecksum is the checksum of the ISO image served by
+// produceTestISOServer.
+var testISOChecksum = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("iso")))
+
+// produceTestISOServer serves an ISO image with the content "iso" at "/".
+func produceTestISOServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("iso"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
 type ISOImageOperatorMock struct{}
 
 func (I ISOImageOperatorMock) GetISOImageList(ctx context.Context) ([]gsclient.ISOImage, error) {
-	panic("implement me")
+	return []gsclient.ISOImage{
+		{
+			Properties: gsclient.ISOImageProperties{
+				ObjectUUID: "not cached",
+				SourceURL:  "cached URL",
+				Labels:     []string{isoChecksumLabelPrefix + testISOChecksum},
+			},
+		},
+		{
+			Properties: gsclient.ISOImageProperties{
+				ObjectUUID: "cached",
+				SourceURL:  "cached URL",
+				Labels:     []string{isoCacheLabel, isoChecksumLabelPrefix + testISOChecksum},
+			},
+		},
+	}, nil
 }
 
 func (I ISOImageOperatorMock) GetISOImage(ctx context.Context, id string) (gsclient.ISOImage, error) {
@@ -20,6 +57,10 @@ func (I ISOImageOperatorMock) GetISOImage(ctx context.Context, id string) (gscli
 }
 
 func (I ISOImageOperatorMock) CreateISOImage(ctx context.Context, body gsclient.ISOImageCreateRequest) (gsclient.ISOImageCreateResponse, error) {
+	cacheLabels := []string{isoCacheLabel, isoChecksumLabelPrefix + testISOChecksum}
+	if body.Labels != nil && !reflect.DeepEqual(body.Labels, cacheLabels) {
+		return gsclient.ISOImageCreateResponse{}, errors.New("unexpected labels")
+	}
 	if body.Name == "success" {
 		return gsclient.ISOImageCreateResponse{
 			ObjectUUID:  "test",
@@ -109,7 +150,68 @@ func Test_stepCreateISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"iso_image_uuid": "success",
+					"iso_image_uuids": []string{"success"},
+				}},
+			},
+			success: true,
+			message: "Destroyed the ISO image (success)",
+		},
+		{
+			name: "existing ISO images are kept",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"iso_images": []map[string]interface{}{
+						{"url": "test URL", "boot": true},
+						{"uuid": "fail"},
+					},
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"iso_image_uuids": []string{"success", "fail"},
+				}},
+			},
+			success: true,
+			message: "Destroyed the ISO image (success)",
+		},
+		{
+			name: "cached ISO images are kept",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": "test URL",
+					"iso_checksum": testISOChecksum,
+					"iso_cache":    true,
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"iso_image_uuids": []string{"fail"},
+				}},
+			},
+			success: true,
+			message: "Keeping the cached ISO image (fail)",
+		},
+		{
+			name: "the CD is destroyed with cached ISO images",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": "test URL",
+					"iso_checksum": testISOChecksum,
+					"iso_cache":    true,
+					"cd_content": map[string]string{
+						"meta-data": "",
+					},
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"iso_image_uuids": []string{"fail", "success"},
 				}},
 			},
 			success: true,
@@ -126,14 +228,14 @@ func Test_stepCreateISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"iso_image_uuid": "fail",
+					"iso_image_uuids": []string{"fail"},
 				}},
 			},
 			success: false,
 			message: "Error destroying ISO image (fail). Please destroy it manually: error",
 		},
 		{
-			name: "convert iso_image_uuid to string fail",
+			name: "convert iso_image_uuids to []string fail",
 			fields: fields{
 				client: ISOImageOperatorMock{},
 				config: produceTestConfig(map[string]interface{}{
@@ -145,7 +247,7 @@ func Test_stepCreateISOImage_Cleanup(t *testing.T) {
 				state: StateBagMock{state: make(map[string]interface{})},
 			},
 			success: false,
-			message: "cannot convert iso_image_uuid to string",
+			message: "cannot convert iso_image_uuids to []string",
 		},
 		{
 			name: "no ISO image UUID detected",
@@ -158,7 +260,7 @@ func Test_stepCreateISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"iso_image_uuid": "",
+					"iso_image_uuids": []string{},
 				}},
 			},
 			success: true,
@@ -198,11 +300,14 @@ func Test_stepCreateISOImage_Run(t *testing.T) {
 	}
 	ui := &uiMock{}
 	testConfig := produceTestConfig(make(map[string]interface{}))
+	isoURL := produceTestISOServer(t).URL
+	isoFile := produceTestISOFile(t, "test.iso")
 	tests := []struct {
 		name   string
 		fields fields
 		args   args
 		want   multistep.StepAction
+		uuids  []string
 	}{
 		{
 			name: "No ISO image is requested",
@@ -230,7 +335,8 @@ func Test_stepCreateISOImage_Run(t *testing.T) {
 				ctx:   context.Background(),
 				state: StateBagMock{state: make(map[string]interface{})},
 			},
-			want: multistep.ActionContinue,
+			want:  multistep.ActionContinue,
+			uuids: []string{"test UUID"},
 		},
 		{
 			name: "Creat an ISO image success",
@@ -246,7 +352,160 @@ func Test_stepCreateISOImage_Run(t *testing.T) {
 				ctx:   context.Background(),
 				state: StateBagMock{state: make(map[string]interface{})},
 			},
-			want: multistep.ActionContinue,
+			want:  multistep.ActionContinue,
+			uuids: []string{"test"},
+		},
+		{
+			name: "several ISO images",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"iso_images": []map[string]interface{}{
+						{"uuid": "test UUID"},
+						{"url": "test URL", "boot": true},
+					},
+					"server_name": "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"test UUID", "test"},
+		},
+		{
+			name: "checksum matches",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": isoURL,
+					"iso_checksum": testISOChecksum,
+					"server_name":  "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"test"},
+		},
+		{
+			name: "checksum does not match",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": isoURL,
+					"iso_checksum": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other"))),
+					"server_name":  "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want: multistep.ActionHalt,
+		},
+		{
+			name: "reuse a cached ISO image",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": "cached URL",
+					"iso_checksum": testISOChecksum,
+					"iso_cache":    true,
+					"server_name":  "fail",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"cached"},
+		},
+		{
+			name: "cache an ISO image",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": isoURL,
+					"iso_checksum": testISOChecksum,
+					"iso_cache":    true,
+					"server_name":  "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"test"},
+		},
+		{
+			name: "the CD is not cached",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_url": "cached URL",
+					"iso_checksum": testISOChecksum,
+					"iso_cache":    true,
+					"server_name":  "success",
+					"cd_content": map[string]string{
+						"meta-data": "",
+					},
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"iso_file_urls": []string{"", "http://test/cd.iso"},
+				}},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"cached", "test"},
+		},
+		{
+			name: "create an ISO image from an uploaded ISO file",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"iso_file":    isoFile,
+					"server_name": "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"iso_file_urls": []string{"http://test/0-test.iso"},
+				}},
+			},
+			want:  multistep.ActionContinue,
+			uuids: []string{"test"},
+		},
+		{
+			name: "ISO file is not uploaded",
+			fields: fields{
+				client: ISOImageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"iso_file":    isoFile,
+					"server_name": "success",
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx:   context.Background(),
+				state: StateBagMock{state: make(map[string]interface{})},
+			},
+			want: multistep.ActionHalt,
 		},
 		{
 			name: "Creat an ISO image fail",
@@ -275,15 +534,13 @@ func Test_stepCreateISOImage_Run(t *testing.T) {
 			if got := s.Run(tt.args.ctx, tt.args.state); got != tt.want {
 				t.Errorf("Run() = %v, want %v", got, tt.want)
 			}
-			if tt.want == multistep.ActionContinue &&
-				tt.fields.config.IsoImageUUID == "" &&
-				tt.fields.config.IsoImageURL != "" {
-				uuid, ok := tt.args.state.Get("iso_image_uuid").(string)
+			if tt.uuids != nil {
+				uuids, ok := tt.args.state.Get("iso_image_uuids").([]string)
 				if !ok {
-					t.Error("cannot convert iso_image_uuid to string")
+					t.Error("cannot convert iso_image_uuids to []string")
 				}
-				if uuid != "test" {
-					t.Errorf("iso_image_uuid = %v, want test", uuid)
+				if !reflect.DeepEqual(uuids, tt.uuids) {
+					t.Errorf("iso_image_uuids = %v, want %v", uuids, tt.uuids)
 				}
 			}
 		})
@@ -23,13 +23,16 @@ func (s *stepCreateSecondaryStorage) Run(ctx context.Context, state multistep.St
 	if c.SecondaryStorage {
 		client := s.client
 		ui.Say("Creating a secondary storage...")
-		storage, err := client.CreateStorage(
-			context.Background(),
-			gsclient.StorageCreateRequest{
-				Capacity:    c.StorageCapacity,
-				Name:        fmt.Sprintf("%s-secondary", c.ServerName),
-				StorageType: gsclient.InsaneStorageType,
-			})
+		storageCreateReq := gsclient.StorageCreateRequest{
+			Capacity:    c.StorageCapacity,
+			Name:        fmt.Sprintf("%s-secondary", c.ServerName),
+			StorageType: gsclient.InsaneStorageType,
+		}
+		if c.ArtifactType == artifactTypeStorage {
+			// The storage is the artifact
+			storageCreateReq.Name = c.TemplateName
+		}
+		storage, err := client.CreateStorage(context.Background(), storageCreateReq)
 
 		if err != nil {
 			ui.Error(fmt.Sprintf(
@@ -39,6 +42,9 @@ func (s *stepCreateSecondaryStorage) Run(ctx context.Context, state multistep.St
 			return multistep.ActionHalt
 		}
 		state.Put("secondary_storage_uuid", storage.ObjectUUID)
+		// The build output is on the secondary storage
+		state.Put("artifact_storage_uuid", storage.ObjectUUID)
+		manifestFrom(state).created(manifestResourceStorage, storage.ObjectUUID)
 		ui.Say(fmt.Sprintf("a secondary storage (%s) has been created", storage.ObjectUUID))
 		return multistep.ActionContinue
 	}
@@ -63,6 +69,11 @@ func (s *stepCreateSecondaryStorage) Cleanup(state multistep.StateBag) {
 			ui.Say("No secondary storage UUID detected.")
 			return
 		}
+		if keepsStorage(c, state, secondaryStorageUUID) {
+			// The storage is the artifact, or holds the snapshot
+			ui.Say(fmt.Sprintf("Keeping the secondary storage (%s)", secondaryStorageUUID))
+			return
+		}
 		ui.Say(fmt.Sprintf("Destroying the secondary storage (%s)...", secondaryStorageUUID))
 		err := client.DeleteStorage(context.Background(), secondaryStorageUUID)
 		if err != nil {
@@ -70,6 +81,7 @@ func (s *stepCreateSecondaryStorage) Cleanup(state multistep.StateBag) {
 				"Error destroying secondary storage (%s). Please destroy it manually: %s", secondaryStorageUUID, err))
 			return
 		}
+		manifestFrom(state).deleted(manifestResourceStorage, secondaryStorageUUID)
 		ui.Say(fmt.Sprintf("Destroyed the secondary storage (%s)", secondaryStorageUUID))
 	}
 }
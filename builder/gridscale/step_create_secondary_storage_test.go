@@ -106,6 +106,25 @@ func Test_stepCreateSecondaryStorage_Cleanup(t *testing.T) {
 			success: true,
 			message: "No secondary storage UUID detected.",
 		},
+		{
+			name: "keep the storage artifact",
+			fields: fields{
+				client: StorageOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"secondary_storage": true,
+					"artifact_type":     "snapshot",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"secondary_storage_uuid": "fail",
+					"artifact_storage_uuid":  "fail",
+				}},
+			},
+			success: true,
+			message: "Keeping the secondary storage (fail)",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
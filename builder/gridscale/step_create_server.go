@@ -10,6 +10,7 @@ import (
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 type stepCreateServer struct {
@@ -42,6 +43,11 @@ func (s *stepCreateServer) Run(ctx context.Context, state multistep.StateBag) mu
 	}
 
 	state.Put("server_uuid", server.ObjectUUID)
+	manifestFrom(state).created(manifestResourceServer, server.ObjectUUID)
+	// instance_id is read by the provision hook
+	state.Put("instance_id", server.ObjectUUID)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("ServerUUID", server.ObjectUUID)
 	ui.Say(fmt.Sprintf("a server (%s) has been created", server.ObjectUUID))
 	return multistep.ActionContinue
 }
@@ -77,5 +83,6 @@ func (s *stepCreateServer) Cleanup(state multistep.StateBag) {
 			"Error destroying server. Please destroy it manually: %s", err))
 		return
 	}
+	manifestFrom(state).deleted(manifestResourceServer, serverUUID)
 	ui.Say(fmt.Sprintf("Destroyed the server (%s)", serverUUID))
 }
@@ -14,7 +14,15 @@ import (
 type ServerOperatorMock struct{}
 
 func (s ServerOperatorMock) GetServer(ctx context.Context, id string) (gsclient.Server, error) {
-	panic("implement me")
+	if strings.Contains(id, "GetSuccess") {
+		return gsclient.Server{
+			Properties: gsclient.ServerProperties{
+				ObjectUUID:   id,
+				ConsoleToken: "token",
+			},
+		}, nil
+	}
+	return gsclient.Server{}, errors.New("error")
 }
 
 func (s ServerOperatorMock) GetServerList(ctx context.Context) ([]gsclient.Server, error) {
@@ -64,6 +72,10 @@ func (s ServerOperatorMock) ShutdownServer(ctx context.Context, id string) error
 	if strings.Contains(id, "ShutdownSuccess") {
 		return nil
 	}
+	if strings.Contains(id, "ShutdownHang") {
+		<-ctx.Done()
+		return ctx.Err()
+	}
 	return errors.New("error")
 }
 
@@ -262,6 +274,13 @@ func Test_stepCreateServer_Run(t *testing.T) {
 				if uuid != "test" {
 					t.Errorf("server_uuid = %v, want test", uuid)
 				}
+				if id := tt.args.state.Get("instance_id"); id != "test" {
+					t.Errorf("instance_id = %v, want test", id)
+				}
+				generatedData := tt.args.state.Get("generated_data").(map[string]interface{})
+				if generatedData["ServerUUID"] != "test" {
+					t.Errorf("ServerUUID = %v, want test", generatedData["ServerUUID"])
+				}
 			}
 		})
 	}
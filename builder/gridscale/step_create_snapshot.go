@@ -58,8 +58,23 @@ func (s *stepCreateSnapshot) Run(ctx context.Context, state multistep.StateBag)
 		return multistep.ActionHalt
 	}
 	state.Put("snapshot_uuid", snapshot.ObjectUUID)
+	manifestFrom(state).created(manifestResourceSnapshot, snapshot.ObjectUUID)
 	ui.Say(fmt.Sprintf("Created snapshot %v with uuid: %v", c.TemplateName, snapshot.ObjectUUID))
 
+	if c.SkipCreateTemplate {
+		// The snapshot only checks that the storage can be snapshotted
+		ui.Say(fmt.Sprintf("Destroying the snapshot (%s) of storage (%s)...", snapshot.ObjectUUID, storageUUID))
+		err := client.DeleteStorageSnapshot(context.Background(), storageUUID, snapshot.ObjectUUID)
+		if err != nil {
+			err := fmt.Errorf("Error destroying snapshot: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		manifestFrom(state).deleted(manifestResourceSnapshot, snapshot.ObjectUUID)
+		ui.Say(fmt.Sprintf("Destroyed the snapshot (%s) of storage (%s)", snapshot.ObjectUUID, storageUUID))
+		state.Put("snapshot_deleted", true)
+	}
 	return multistep.ActionContinue
 }
 
@@ -94,6 +109,17 @@ func (s *stepCreateSnapshot) Cleanup(state multistep.StateBag) {
 		state.Put("error", err)
 		return
 	}
+	if deleted, _ := state.Get("snapshot_deleted").(bool); deleted {
+		return
+	}
+	// The snapshot is taken from the 2nd storage if it is used
+	if secondStorageUUID, _ := state.Get("secondary_storage_uuid").(string); secondStorageUUID != "" {
+		storageUUID = secondStorageUUID
+	}
+	if s.config.ArtifactType == artifactTypeSnapshot && buildSucceeded(state) {
+		ui.Say(fmt.Sprintf("Keeping the snapshot (%s) of storage (%s)", snapshotUUID, storageUUID))
+		return
+	}
 	// remove snapshot
 	ui.Say(fmt.Sprintf("Destroying the snapshot (%s) of storage (%s)...", snapshotUUID, storageUUID))
 	err := client.DeleteStorageSnapshot(context.Background(), storageUUID, snapshotUUID)
@@ -102,5 +128,6 @@ func (s *stepCreateSnapshot) Cleanup(state multistep.StateBag) {
 			"Error destroying snapshot. Please destroy it manually: %s", err))
 		return
 	}
+	manifestFrom(state).deleted(manifestResourceSnapshot, snapshotUUID)
 	ui.Say(fmt.Sprintf("Destroyed the snapshot (%s) of storage (%s)", snapshotUUID, storageUUID))
 }
@@ -31,6 +31,12 @@ func (s SnapshotOperatorMock) CreateStorageSnapshot(ctx context.Context, id stri
 			ObjectUUID:  "test",
 		}, nil
 	}
+	if id == "deletable" {
+		return gsclient.StorageSnapshotCreateResponse{
+			RequestUUID: "test",
+			ObjectUUID:  "success",
+		}, nil
+	}
 	return gsclient.StorageSnapshotCreateResponse{}, errors.New("error")
 }
 
@@ -107,6 +113,24 @@ func Test_stepCreateSnapshot_Cleanup(t *testing.T) {
 			success: false,
 			message: "Error destroying snapshot. Please destroy it manually: error",
 		},
+		{
+			name: "keep the snapshot artifact",
+			fields: fields{
+				client: SnapshotOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"artifact_type": "snapshot",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"boot_storage_uuid": "test UUID",
+					"snapshot_uuid":     "fail",
+				}},
+			},
+			success: true,
+			message: "Keeping the snapshot (fail) of storage (test UUID)",
+		},
 		{
 			name: "convert boot_storage_uuid to string fail",
 			fields: fields{
@@ -287,3 +311,49 @@ func Test_stepCreateSnapshot_Run(t *testing.T) {
 		})
 	}
 }
+
+func Test_stepCreateSnapshot_Run_skipCreateTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageUUID string
+		want        multistep.StepAction
+	}{
+		{
+			name:        "snapshot deleted",
+			storageUUID: "deletable",
+			want:        multistep.ActionContinue,
+		},
+		{
+			name:        "delete snapshot fail",
+			storageUUID: "success",
+			want:        multistep.ActionHalt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stepCreateSnapshot{
+				client: SnapshotOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"skip_create_template": true,
+					"check_snapshot":       true,
+				}),
+				ui: &uiMock{},
+			}
+			state := StateBagMock{state: map[string]interface{}{
+				"boot_storage_uuid": tt.storageUUID,
+			}}
+			if got := s.Run(context.Background(), state); got != tt.want {
+				t.Fatalf("Run() = %v, want %v", got, tt.want)
+			}
+			if tt.want != multistep.ActionContinue {
+				return
+			}
+			if deleted, _ := state.Get("snapshot_deleted").(bool); !deleted {
+				t.Error("snapshot_deleted is not set")
+			}
+			// The snapshot is not deleted a second time
+			s.client = nil
+			s.Cleanup(state)
+		})
+	}
+}
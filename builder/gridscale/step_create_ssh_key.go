@@ -52,10 +52,11 @@ func (s *stepCreateSSHKey) Run(ctx context.Context, state multistep.StateBag) mu
 
 	c.Comm.SSHPrivateKey = pem.EncodeToMemory(&priv_blk)
 	pub, _ := ssh.NewPublicKey(&priv.PublicKey)
+	authorizedKey := string(bytes.Trim(ssh.MarshalAuthorizedKey(pub), "\n"))
 
 	sshKey, err := client.CreateSshkey(context.Background(), gsclient.SshkeyCreateRequest{
 		Name:   fmt.Sprintf("packer-%s", uuid.TimeOrderedUUID()),
-		Sshkey: string(bytes.Trim(ssh.MarshalAuthorizedKey(pub), "\n")),
+		Sshkey: authorizedKey,
 	})
 	if err != nil {
 		err := fmt.Errorf("Error getting temporary SSH key: %s", err)
@@ -64,6 +65,8 @@ func (s *stepCreateSSHKey) Run(ctx context.Context, state multistep.StateBag) mu
 		return multistep.ActionHalt
 	}
 	state.Put("ssh_key_uuid", sshKey.ObjectUUID)
+	manifestFrom(state).created(manifestResourceSSHKey, sshKey.ObjectUUID)
+	state.Put("ssh_public_key", authorizedKey)
 	ui.Say(fmt.Sprintf("a SSH-key (%s) has been created", sshKey.ObjectUUID))
 	if s.Debug {
 		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
@@ -119,5 +122,6 @@ func (s *stepCreateSSHKey) Cleanup(state multistep.StateBag) {
 			"Error destroying SSH key (%s). Please destroy it manually: %s", sshKeyUUID, err))
 		return
 	}
+	manifestFrom(state).deleted(manifestResourceSSHKey, sshKeyUUID)
 	ui.Say(fmt.Sprintf("Destroyed the SSH-key (%s)", sshKeyUUID))
 }
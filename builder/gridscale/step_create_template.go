@@ -9,6 +9,7 @@ import (
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 type stepCreateTemplate struct {
@@ -42,6 +43,7 @@ func (s *stepCreateTemplate) Run(ctx context.Context, state multistep.StateBag)
 		gsclient.TemplateCreateRequest{
 			Name:         c.TemplateName,
 			SnapshotUUID: snapshotUUID,
+			Labels:       c.TemplateLabels,
 		})
 
 	if err != nil {
@@ -51,7 +53,31 @@ func (s *stepCreateTemplate) Run(ctx context.Context, state multistep.StateBag)
 		return multistep.ActionHalt
 	}
 	state.Put("template_uuid", template.ObjectUUID)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("TemplateUUID", template.ObjectUUID)
 	ui.Say(fmt.Sprintf("Created template %v with uuid: %v", c.TemplateName, template.ObjectUUID))
+
+	// The template details, e.g. the location, are only needed for the
+	// artifact
+	details, err := client.GetTemplate(context.Background(), template.ObjectUUID)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error getting the details of template %v: %s", template.ObjectUUID, err))
+	} else {
+		state.Put("template", details)
+	}
+
+	// Remove the templates replaced by the new one
+	existingUUIDs, _ := state.Get("existing_template_uuids").([]string)
+	for _, existingUUID := range existingUUIDs {
+		ui.Say(fmt.Sprintf("Removing the existing template %v (%v)...", c.TemplateName, existingUUID))
+		err := client.DeleteTemplate(context.Background(), existingUUID)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error removing the existing template %v (%v). Please remove it manually: %s", c.TemplateName, existingUUID, err))
+			continue
+		}
+		ui.Say(fmt.Sprintf("Removed the existing template %v (%v)", c.TemplateName, existingUUID))
+	}
 	return multistep.ActionContinue
 }
 
@@ -25,10 +25,11 @@ func Test_stepCreateTemplate_Run(t *testing.T) {
 	ui := &uiMock{}
 	//testConfig := produceTestConfig(make(map[string]interface{}))
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   multistep.StepAction
+		name    string
+		fields  fields
+		args    args
+		want    multistep.StepAction
+		message string
 	}{
 		{
 			name: "success",
@@ -47,6 +48,26 @@ func Test_stepCreateTemplate_Run(t *testing.T) {
 			},
 			want: multistep.ActionContinue,
 		},
+		{
+			name: "replace existing templates",
+			fields: fields{
+				client: TemplateOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"template_name":    "success",
+					"force_deregister": true,
+				}),
+				ui: ui,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"snapshot_uuid":           "test UUID",
+					"existing_template_uuids": []string{"fail", "success"},
+				}},
+			},
+			want:    multistep.ActionContinue,
+			message: "Removed the existing template success (success)",
+		},
 		{
 			name: "API call fail",
 			fields: fields{
@@ -115,6 +136,17 @@ func Test_stepCreateTemplate_Run(t *testing.T) {
 				if uuid != "test" {
 					t.Errorf("template_uuid = %v, want test", uuid)
 				}
+				generatedData := tt.args.state.Get("generated_data").(map[string]interface{})
+				if generatedData["TemplateUUID"] != "test" {
+					t.Errorf("TemplateUUID = %v, want test", generatedData["TemplateUUID"])
+				}
+				template, _ := tt.args.state.Get("template").(gsclient.Template)
+				if template.Properties.LocationUUID != "location UUID" {
+					t.Errorf("template location = %v, want location UUID", template.Properties.LocationUUID)
+				}
+			}
+			if tt.message != "" && ui.sayMessage != tt.message {
+				t.Errorf("message = %v, want %v", ui.sayMessage, tt.message)
 			}
 		})
 	}
@@ -10,7 +10,6 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
-	"github.com/mitchellh/go-vnc"
 )
 
 const defaultBootWaitSecs = 120
@@ -18,22 +17,44 @@ const defaultBootWaitSecs = 120
 type StepExecuteBootCommand struct {
 	config *Config
 	ui     packer.Ui
+	// postReboot selects the post_reboot_boot_command, typed after the
+	// server has been restarted at the end of an ISO image installation.
+	postReboot bool
+}
+
+// bootCommandTemplateData is the data available to the boot commands as
+// template variables.
+type bootCommandTemplateData struct {
+	HTTPIP       string
+	HTTPPort     int
+	Name         string
+	SSHPublicKey string
+}
+
+// bootPhase returns the option name, the commands and the wait time of the
+// boot command typed at the given phase.
+func bootPhase(c *Config, postReboot bool) (string, []string, time.Duration) {
+	if postReboot {
+		return "post_reboot_boot_command", c.PostRebootBootCommand, c.PostRebootBootWait
+	}
+	return "boot_command", c.BootCommand, c.BootWait
 }
 
 func (s StepExecuteBootCommand) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := s.ui
 	c := s.config
-	if len(c.BootCommand) == 0 {
-		ui.Say("boot_command is not set. Skipping executing VNC boot commands...")
+	name, bootCommand, wait := bootPhase(c, s.postReboot)
+	if len(bootCommand) == 0 {
+		ui.Say(fmt.Sprintf("%s is not set. Skipping executing VNC boot commands...", name))
 		return multistep.ActionContinue
 	}
-	conn := state.Get("vnc_conn").(*vnc.ClientConn)
+	conn := state.Get("vnc_conn").(*vncConnection)
 	defer conn.Close()
 
 	// Wait the for the vm to boot.
 	bootWait := defaultBootWaitSecs * time.Second
-	if int64(c.BootWait) > 0 {
-		bootWait = c.BootWait
+	if int64(wait) > 0 {
+		bootWait = wait
 	}
 	ui.Say(fmt.Sprintf("Waiting %s for boot...", bootWait.String()))
 	select {
@@ -42,11 +63,28 @@ func (s StepExecuteBootCommand) Run(ctx context.Context, state multistep.StateBa
 	case <-ctx.Done():
 		return multistep.ActionHalt
 	}
-	d := bootcommand.NewVNCDriver(conn, c.BootKeyInterval)
+	// The console connection may have been dropped while waiting
+	if err := conn.ensureConnected(); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	d := newVNCBootDriver(conn, c.BootKeyInterval, c.BootKeymap)
 
-	ui.Say("Typing the boot command over VNC...")
-	flatBootCommand := strings.Join(c.BootCommand, "")
-	command, err := interpolate.Render(flatBootCommand, &c.ctx)
+	ui.Say(fmt.Sprintf("Typing the %s over VNC...", name))
+	flatBootCommand := replaceFileServerPlaceholder(strings.Join(bootCommand, ""))
+	// Render with a copy of the context, the config is shared between steps
+	ictx := c.ctx
+	httpIP, _ := state.Get("http_ip").(string)
+	httpPort, _ := state.Get("http_port").(int)
+	sshPublicKey, _ := state.Get("ssh_public_key").(string)
+	ictx.Data = &bootCommandTemplateData{
+		HTTPIP:       httpIP,
+		HTTPPort:     httpPort,
+		Name:         c.ServerName,
+		SSHPublicKey: sshPublicKey,
+	}
+	command, err := interpolate.Render(flatBootCommand, &ictx)
 	if err != nil {
 		err := fmt.Errorf("Error preparing boot command: %s", err)
 		state.Put("error", err)
@@ -68,7 +106,7 @@ func (s StepExecuteBootCommand) Run(ctx context.Context, state multistep.StateBa
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
-	ui.Say("Finished executing boot command")
+	ui.Say(fmt.Sprintf("Finished executing %s", name))
 	return multistep.ActionContinue
 }
 
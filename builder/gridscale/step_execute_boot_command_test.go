@@ -0,0 +1,149 @@
+package gridscale
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepExecuteBootCommand_Run(t *testing.T) {
+	type fields struct {
+		config     *Config
+		postReboot bool
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		state   map[string]interface{}
+		want    multistep.StepAction
+		keys    []uint32
+		message string
+	}{
+		{
+			name: "boot_command",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid":            "test",
+					"boot_command":             []string{"ab"},
+					"boot_wait":                "1ms",
+					"post_reboot_boot_command": []string{"cd"},
+				}),
+				postReboot: false,
+			},
+			want:    multistep.ActionContinue,
+			keys:    []uint32{'a', 'b'},
+			message: "Finished executing boot_command",
+		},
+		{
+			name: "post_reboot_boot_command",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid":            "test",
+					"boot_command":             []string{"ab"},
+					"post_reboot_boot_command": []string{"cd"},
+					"post_reboot_boot_wait":    "1ms",
+				}),
+				postReboot: true,
+			},
+			want:    multistep.ActionContinue,
+			keys:    []uint32{'c', 'd'},
+			message: "Finished executing post_reboot_boot_command",
+		},
+		{
+			name: "post_reboot_boot_command is not set",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"isoimage_uuid": "test",
+					"boot_command":  []string{"ab"},
+				}),
+				postReboot: true,
+			},
+			want:    multistep.ActionContinue,
+			keys:    []uint32{},
+			message: "post_reboot_boot_command is not set. Skipping executing VNC boot commands...",
+		},
+		{
+			name: "template variables",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"server_name":  "n",
+					"boot_command": []string{"{{ .HTTPIP }}{{ .HTTPPort }}{{ .Name }}{{ .SSHPublicKey }}"},
+					"boot_wait":    "1ms",
+				}),
+				postReboot: false,
+			},
+			state: map[string]interface{}{
+				"http_ip":        "1",
+				"http_port":      2,
+				"ssh_public_key": "k",
+			},
+			want:    multistep.ActionContinue,
+			keys:    []uint32{'1', '2', 'n', 'k'},
+			message: "Finished executing boot_command",
+		},
+		{
+			name: "deprecated file server address placeholder",
+			fields: fields{
+				config: produceTestConfig(map[string]interface{}{
+					"boot_command": []string{"{{__HTTP__ADDRESS__}}"},
+					"boot_wait":    "1ms",
+				}),
+				postReboot: false,
+			},
+			state: map[string]interface{}{
+				"http_ip":   "1",
+				"http_port": 2,
+			},
+			want:    multistep.ActionContinue,
+			keys:    []uint32{'1', bootcommand.KeyLeftShift, ':', '2'},
+			message: "Finished executing boot_command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyEvents := make(chan uint32, 32)
+			conn, _ := produceTestVNCConnection(t, []int{0}, keyEvents)
+			if err := conn.connect(); err != nil {
+				t.Fatalf("connect() error = %v", err)
+			}
+			defer conn.Close()
+			ui := &uiMock{}
+			s := StepExecuteBootCommand{
+				config:     tt.fields.config,
+				ui:         ui,
+				postReboot: tt.fields.postReboot,
+			}
+			s.config.BootKeyInterval = time.Millisecond
+			state := StateBagMock{state: map[string]interface{}{"vnc_conn": conn}}
+			for k, v := range tt.state {
+				state.Put(k, v)
+			}
+			bootCommand := append([]string{}, s.config.BootCommand...)
+			if got := s.Run(context.Background(), state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			if ui.sayMessage != tt.message {
+				t.Errorf("message = %v, want %v", ui.sayMessage, tt.message)
+			}
+			got := []uint32{}
+			for len(got) < len(tt.keys) {
+				select {
+				case key := <-keyEvents:
+					got = append(got, key)
+				case <-time.After(time.Second):
+					t.Fatalf("key events = %v, want %v", got, tt.keys)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.keys) {
+				t.Errorf("key events = %v, want %v", got, tt.keys)
+			}
+			if !reflect.DeepEqual(s.config.BootCommand, bootCommand) {
+				t.Errorf("boot_command = %v, want it unchanged %v", s.config.BootCommand, bootCommand)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type stepExportSnapshot struct {
+	client ExportClient
+	config *Config
+	ui     packer.Ui
+}
+
+func (s *stepExportSnapshot) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	c := s.config
+	ui := s.ui
+	// Get the storage UUID, the snapshot is taken from the 2nd storage if it
+	// is used
+	storageUUID, _ := state.Get("boot_storage_uuid").(string)
+	if secondStorageUUID, _ := state.Get("secondary_storage_uuid").(string); secondStorageUUID != "" {
+		storageUUID = secondStorageUUID
+	}
+	snapshotUUID, _ := state.Get("snapshot_uuid").(string)
+	if storageUUID == "" || snapshotUUID == "" {
+		err := errors.New("boot_storage_uuid or snapshot_uuid is empty")
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	exporter := &S3Exporter{
+		Client:  s.client,
+		Config:  *c.ExportS3,
+		Timeout: c.ExportTimeout,
+	}
+	url := exporter.ObjectURL(c.ExportS3Key)
+	ui.Say(fmt.Sprintf("Exporting the snapshot (%s) to %s...", snapshotUUID, url))
+	if err := exporter.ExportSnapshot(ctx, storageUUID, snapshotUUID, c.ExportS3Key); err != nil {
+		err := fmt.Errorf("Error exporting snapshot: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	sum, err := exporter.WriteChecksum(ctx, c.ExportS3Key)
+	if err != nil {
+		err := fmt.Errorf("Error writing the checksum of the export: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("export_url", url)
+	state.Put("export_sha256", sum)
+	ui.Say(fmt.Sprintf("Exported the snapshot (%s) to %s (SHA-256: %s)", snapshotUUID, url, sum))
+	return multistep.ActionContinue
+}
+
+// Cleanup keeps the export, it is not a temporary resource.
+func (s *stepExportSnapshot) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,115 @@
+package gridscale
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func Test_stepExportSnapshot_Run(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		state       map[string]interface{}
+		wantCalls   []string
+		wantStorage string
+		want        multistep.StepAction
+	}{
+		{
+			name: "success",
+			key:  "packer.gz",
+			state: map[string]interface{}{
+				"boot_storage_uuid": "storage",
+				"snapshot_uuid":     "snapshot",
+			},
+			wantCalls:   []string{"ExportStorageSnapshotToS3 snapshot"},
+			wantStorage: "storage",
+			want:        multistep.ActionContinue,
+		},
+		{
+			name: "secondary storage",
+			key:  "packer.gz",
+			state: map[string]interface{}{
+				"boot_storage_uuid":      "storage",
+				"secondary_storage_uuid": "secondary",
+				"snapshot_uuid":          "snapshot",
+			},
+			wantCalls:   []string{"ExportStorageSnapshotToS3 snapshot"},
+			wantStorage: "secondary",
+			want:        multistep.ActionContinue,
+		},
+		{
+			name: "export fail",
+			key:  "fail",
+			state: map[string]interface{}{
+				"boot_storage_uuid": "storage",
+				"snapshot_uuid":     "snapshot",
+			},
+			wantCalls: []string{"ExportStorageSnapshotToS3 snapshot"},
+			want:      multistep.ActionHalt,
+		},
+		{
+			name: "no snapshot",
+			key:  "packer.gz",
+			state: map[string]interface{}{
+				"boot_storage_uuid": "storage",
+			},
+			want: multistep.ActionHalt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, objects := newS3StandIn(t)
+			var calls []string
+			client := &exportClientMock{
+				calls: &calls,
+				exported: func(key string) {
+					objects["/packer/"+key] = "image"
+				},
+			}
+			c := produceTestConfig(map[string]interface{}{
+				"export_s3": map[string]interface{}{
+					"endpoint":   srv.URL,
+					"bucket":     "packer",
+					"access_key": "access",
+					"secret_key": "secret",
+				},
+				"export_s3_key": tt.key,
+			})
+			s := &stepExportSnapshot{
+				client: client,
+				config: c,
+				ui:     &uiMock{},
+			}
+			state := StateBagMock{state: tt.state}
+			if got := s.Run(context.Background(), state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if tt.want == multistep.ActionHalt {
+				if _, ok := state.GetOk("error"); !ok {
+					t.Errorf("Run() error = nil, want an error")
+				}
+				return
+			}
+			if client.exportStorage != tt.wantStorage {
+				t.Errorf("exported storage = %v, want %v", client.exportStorage, tt.wantStorage)
+			}
+			if got, want := state.Get("export_url"), srv.URL+"/packer/packer.gz"; got != want {
+				t.Errorf("export_url = %v, want %v", got, want)
+			}
+			// sha256sum of "image"
+			sum := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+			if got := state.Get("export_sha256"); got != sum {
+				t.Errorf("export_sha256 = %v, want %v", got, sum)
+			}
+			if got := objects["/packer/packer.gz.sha256"]; got != sum+"  packer.gz\n" {
+				t.Errorf("sidecar = %q, want the checksum", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package gridscale
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type templateStorageLister interface {
+	GetTemplateList(ctx context.Context) ([]gsclient.Template, error)
+	GetStorageList(ctx context.Context) ([]gsclient.Storage, error)
+}
+
+// stepFindExistingTemplates finds the templates named template_name, which
+// are replaced by the new template if force_deregister is set.
+type stepFindExistingTemplates struct {
+	client templateStorageLister
+	config *Config
+	ui     packer.Ui
+}
+
+func (s *stepFindExistingTemplates) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	client := s.client
+	c := s.config
+	ui := s.ui
+	if !c.ForceDeregister {
+		return multistep.ActionContinue
+	}
+	ui.Say(fmt.Sprintf("Looking for existing templates named %s...", c.TemplateName))
+	templates, err := client.GetTemplateList(context.Background())
+	if err != nil {
+		err := fmt.Errorf("Error getting templates: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	var existing []gsclient.Template
+	for _, template := range templates {
+		// Public templates cannot be deleted
+		if template.Properties.Name == c.TemplateName && template.Properties.Private {
+			existing = append(existing, template)
+		}
+	}
+	if len(existing) == 0 {
+		ui.Say(fmt.Sprintf("No template named %s exists", c.TemplateName))
+		return multistep.ActionContinue
+	}
+	storages, err := client.GetStorageList(context.Background())
+	if err != nil {
+		err := fmt.Errorf("Error getting storages: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	var uuids []string
+	for _, template := range existing {
+		templateUUID := template.Properties.ObjectUUID
+		inUse := 0
+		for _, storage := range storages {
+			if storage.Properties.LastUsedTemplate == templateUUID {
+				inUse++
+			}
+		}
+		if inUse > 0 && !c.ForceDeleteInUse {
+			err := fmt.Errorf("the template %s (%s) is used by %d storage(s), set force_delete_in_use to replace it anyway",
+				c.TemplateName, templateUUID, inUse)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		uuids = append(uuids, templateUUID)
+	}
+	state.Put("existing_template_uuids", uuids)
+	ui.Say(fmt.Sprintf("Found existing templates named %s, they are removed after creating the new template: %v", c.TemplateName, uuids))
+	return multistep.ActionContinue
+}
+
+func (s *stepFindExistingTemplates) Cleanup(state multistep.StateBag) {
+	// no cleanup
+}
@@ -0,0 +1,116 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+type templateStorageListerMock struct {
+	err error
+}
+
+func (m templateStorageListerMock) GetTemplateList(ctx context.Context) ([]gsclient.Template, error) {
+	template := func(name, uuid string, private bool) gsclient.Template {
+		return gsclient.Template{Properties: gsclient.TemplateProperties{Name: name, ObjectUUID: uuid, Private: private}}
+	}
+	return []gsclient.Template{
+		template("existing", "existing-1", true),
+		template("existing", "public", false),
+		template("existing", "existing-2", true),
+		template("in-use", "in-use", true),
+		template("other", "other", true),
+	}, m.err
+}
+
+func (m templateStorageListerMock) GetStorageList(ctx context.Context) ([]gsclient.Storage, error) {
+	return []gsclient.Storage{
+		{Properties: gsclient.StorageProperties{ObjectUUID: "storage", LastUsedTemplate: "in-use"}},
+	}, nil
+}
+
+func Test_stepFindExistingTemplates_Run(t *testing.T) {
+	tests := []struct {
+		name      string
+		raws      map[string]interface{}
+		err       error
+		want      multistep.StepAction
+		wantUUIDs []string
+		message   string
+	}{
+		{
+			name: "not requested",
+			raws: map[string]interface{}{"template_name": "existing"},
+			want: multistep.ActionContinue,
+		},
+		{
+			name:      "existing templates",
+			raws:      map[string]interface{}{"template_name": "existing", "force_deregister": true},
+			want:      multistep.ActionContinue,
+			wantUUIDs: []string{"existing-1", "existing-2"},
+			message:   "Found existing templates named existing, they are removed after creating the new template: [existing-1 existing-2]",
+		},
+		{
+			name:    "no existing template",
+			raws:    map[string]interface{}{"template_name": "new", "force_deregister": true},
+			want:    multistep.ActionContinue,
+			message: "No template named new exists",
+		},
+		{
+			name:    "template in use",
+			raws:    map[string]interface{}{"template_name": "in-use", "force_deregister": true},
+			want:    multistep.ActionHalt,
+			message: "the template in-use (in-use) is used by 1 storage(s), set force_delete_in_use to replace it anyway",
+		},
+		{
+			name:      "template in use with force_delete_in_use",
+			raws:      map[string]interface{}{"template_name": "in-use", "force_deregister": true, "force_delete_in_use": true},
+			want:      multistep.ActionContinue,
+			wantUUIDs: []string{"in-use"},
+			message:   "Found existing templates named in-use, they are removed after creating the new template: [in-use]",
+		},
+		{
+			name:    "API call fail",
+			raws:    map[string]interface{}{"template_name": "existing", "force_deregister": true},
+			err:     errors.New("error"),
+			want:    multistep.ActionHalt,
+			message: "Error getting templates: error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := &uiMock{}
+			state := StateBagMock{state: map[string]interface{}{}}
+			s := &stepFindExistingTemplates{
+				client: templateStorageListerMock{err: tt.err},
+				config: produceTestConfig(tt.raws),
+				ui:     ui,
+			}
+			if got := s.Run(context.Background(), state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			message := ui.sayMessage
+			if tt.want == multistep.ActionHalt {
+				message = ui.errorMessage
+			}
+			if message != tt.message {
+				t.Errorf("message = %v, want %v", message, tt.message)
+			}
+			uuids, _ := state.Get("existing_template_uuids").([]string)
+			if !reflect.DeepEqual(uuids, tt.wantUUIDs) {
+				t.Errorf("existing_template_uuids = %v, want %v", uuids, tt.wantUUIDs)
+			}
+		})
+	}
+}
+
+func TestNewConfig_packerForce(t *testing.T) {
+	c := produceTestConfig(map[string]interface{}{"packer_force": true})
+	if !c.ForceDeregister {
+		t.Error("ForceDeregister = false, want true with -force")
+	}
+}
@@ -21,19 +21,19 @@ func (s *stepLinkServerISOImage) Run(ctx context.Context, state multistep.StateB
 	client := s.client
 	ui := s.ui
 	c := s.config
-	if c.IsoImageUUID == "" && c.IsoImageURL == "" {
+	if len(c.ISOImages) == 0 {
 		ui.Say("No ISO image is requested. Skipping linking the server with an ISO image...")
 		return multistep.ActionContinue
 	}
-	// Get ISO image UUID
-	isoImageUUID, ok := state.Get("iso_image_uuid").(string)
+	// Get ISO image UUIDs
+	isoImageUUIDs, ok := state.Get("iso_image_uuids").([]string)
 	if !ok {
-		err := errors.New("cannot convert iso_image_uuid to string")
+		err := errors.New("cannot convert iso_image_uuids to []string")
 		ui.Error(err.Error())
 		state.Put("error", err)
 		return multistep.ActionHalt
 	}
-	if isoImageUUID == "" {
+	if len(isoImageUUIDs) == 0 {
 		ui.Say("No ISO image UUID detected. Skipping linking the server with an ISO image...")
 		return multistep.ActionContinue
 	}
@@ -51,41 +51,66 @@ func (s *stepLinkServerISOImage) Run(ctx context.Context, state multistep.StateB
 		state.Put("error", err)
 		return multistep.ActionHalt
 	}
-	// Link server with ISO image
-	ui.Say(fmt.Sprintf("Linking the server (%s) and the ISO image (%s)...", serverUUID, isoImageUUID))
-	err := client.LinkIsoImage(context.Background(), serverUUID, isoImageUUID)
-	if err != nil {
-		ui.Error(fmt.Sprintf(
-			"Error linking Server with Storage: %s", err))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	// Link server with the ISO images, in the requested order
+	linked := []string{}
+	state.Put("server_iso_images_linked", linked)
+	for _, isoImageUUID := range isoImageUUIDs {
+		ui.Say(fmt.Sprintf("Linking the server (%s) and the ISO image (%s)...", serverUUID, isoImageUUID))
+		err := client.LinkIsoImage(context.Background(), serverUUID, isoImageUUID)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error linking Server with Storage: %s", err))
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		linked = append(linked, isoImageUUID)
+		state.Put("server_iso_images_linked", linked)
+		ui.Say(fmt.Sprintf("Linked the server (%s) and the ISO image (%s)", serverUUID, isoImageUUID))
+	}
+	// Set the boot device of every ISO image. The boot ISO image is set last,
+	// as there is only one boot device.
+	for _, boot := range []bool{false, true} {
+		for i, isoImageUUID := range isoImageUUIDs {
+			if c.ISOImages[i].Boot != boot {
+				continue
+			}
+			err := setServerISOImageBootDevice(client, serverUUID, isoImageUUID, boot)
+			if err != nil {
+				err := fmt.Errorf("Error setting the boot device of ISO image (%s): %s", isoImageUUID, err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
 	}
-	state.Put("server_iso_image_linked", true)
-	ui.Say(fmt.Sprintf("Linked the server (%s) and the ISO image (%s)", serverUUID, isoImageUUID))
 	return multistep.ActionContinue
 }
 
+// setServerISOImageBootDevice sets whether the server boots from the linked
+// ISO image.
+func setServerISOImageBootDevice(client gsclient.ServerIsoImageRelationOperator, serverUUID, isoImageUUID string, boot bool) error {
+	rel, err := client.GetServerIsoImage(context.Background(), serverUUID, isoImageUUID)
+	if err != nil {
+		return err
+	}
+	if rel.Bootdevice == boot {
+		return nil
+	}
+	return client.UpdateServerIsoImage(context.Background(), serverUUID, isoImageUUID, gsclient.ServerIsoImageRelationUpdateRequest{
+		BootDevice: boot,
+		Name:       rel.ObjectName,
+	})
+}
+
 func (s *stepLinkServerISOImage) Cleanup(state multistep.StateBag) {
 	client := s.client
 	ui := s.ui
 	c := s.config
-	if c.IsoImageUUID == "" && c.IsoImageURL == "" {
+	if len(c.ISOImages) == 0 {
 		ui.Say("No ISO image is requested. Skipping unlinking server with an ISO image...")
 		return
 	}
-	// Get ISO image UUID
-	isoImageUUID, ok := state.Get("iso_image_uuid").(string)
-	if !ok {
-		err := errors.New("cannot convert iso_image_uuid to string")
-		ui.Error(err.Error())
-		state.Put("error", err)
-		return
-	}
-	if isoImageUUID == "" {
-		ui.Say("No ISO image UUID detected. Skipping unlinking the server with an ISO image...")
-		return
-	}
 	// Get server UUID
 	serverUUID, ok := state.Get("server_uuid").(string)
 	if !ok {
@@ -100,28 +125,30 @@ func (s *stepLinkServerISOImage) Cleanup(state multistep.StateBag) {
 		state.Put("error", err)
 		return
 	}
-	// Unlink server and ISO image
-	serverISOImageLinked, ok := state.Get("server_iso_image_linked").(bool)
+	// Unlink server and ISO images
+	linked, ok := state.Get("server_iso_images_linked").([]string)
 	if !ok {
-		err := errors.New("cannot convert server_iso_image_linked to boolean")
+		err := errors.New("cannot convert server_iso_images_linked to []string")
 		ui.Error(err.Error())
 		state.Put("error", err)
 		return
 	}
-	if !serverISOImageLinked {
-		ui.Say("the server is not linked with the ISO image.")
+	if len(linked) == 0 {
+		ui.Say("the server is not linked with any ISO image.")
 		return
 	}
-	ui.Say(fmt.Sprintf("Unlinking the server (%s) and the ISO image (%s)...", serverUUID, isoImageUUID))
-	err := suppressHTTPErrorCodes(
-		client.UnlinkIsoImage(context.Background(), serverUUID, isoImageUUID),
-		http.StatusConflict,
-		http.StatusNotFound,
-	)
-	if err != nil {
-		ui.Error(fmt.Sprintf(
-			"Error unlink server (%s) and ISO image (%s). Please unlink them manually: %s", serverUUID, isoImageUUID, err))
-		return
+	for _, isoImageUUID := range linked {
+		ui.Say(fmt.Sprintf("Unlinking the server (%s) and the ISO image (%s)...", serverUUID, isoImageUUID))
+		err := suppressHTTPErrorCodes(
+			client.UnlinkIsoImage(context.Background(), serverUUID, isoImageUUID),
+			http.StatusConflict,
+			http.StatusNotFound,
+		)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error unlink server (%s) and ISO image (%s). Please unlink them manually: %s", serverUUID, isoImageUUID, err))
+			continue
+		}
+		ui.Say(fmt.Sprintf("Unlinked the server (%s) and the ISO image (%s)", serverUUID, isoImageUUID))
 	}
-	ui.Say(fmt.Sprintf("Unlinked the server (%s) and the ISO image (%s)", serverUUID, isoImageUUID))
 }
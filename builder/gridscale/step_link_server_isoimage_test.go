@@ -3,6 +3,9 @@ package gridscale
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gridscale/gsclient-go/v3"
@@ -10,14 +13,24 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
-type ServerIsoImageRelationOperatorMock struct{}
+type ServerIsoImageRelationOperatorMock struct {
+	// bootDevices records the boot device updates, if set
+	bootDevices *[]string
+}
 
 func (s ServerIsoImageRelationOperatorMock) GetServerIsoImageList(ctx context.Context, id string) ([]gsclient.ServerIsoImageRelationProperties, error) {
 	panic("implement me")
 }
 
 func (s ServerIsoImageRelationOperatorMock) GetServerIsoImage(ctx context.Context, serverID, isoImageID string) (gsclient.ServerIsoImageRelationProperties, error) {
-	panic("implement me")
+	if strings.HasPrefix(isoImageID, "success") {
+		return gsclient.ServerIsoImageRelationProperties{
+			ObjectUUID: isoImageID,
+			ObjectName: "test",
+			Bootdevice: strings.Contains(isoImageID, "Boot"),
+		}, nil
+	}
+	return gsclient.ServerIsoImageRelationProperties{}, errors.New("error")
 }
 
 func (s ServerIsoImageRelationOperatorMock) CreateServerIsoImage(ctx context.Context, id string, body gsclient.ServerIsoImageRelationCreateRequest) error {
@@ -25,7 +38,13 @@ func (s ServerIsoImageRelationOperatorMock) CreateServerIsoImage(ctx context.Con
 }
 
 func (s ServerIsoImageRelationOperatorMock) UpdateServerIsoImage(ctx context.Context, serverID, isoImageID string, body gsclient.ServerIsoImageRelationUpdateRequest) error {
-	panic("implement me")
+	if !strings.HasPrefix(isoImageID, "success") || body.Name != "test" {
+		return errors.New("error")
+	}
+	if s.bootDevices != nil {
+		*s.bootDevices = append(*s.bootDevices, fmt.Sprintf("%s=%v", isoImageID, body.BootDevice))
+	}
+	return nil
 }
 
 func (s ServerIsoImageRelationOperatorMock) DeleteServerIsoImage(ctx context.Context, serverID, isoImageID string) error {
@@ -33,14 +52,14 @@ func (s ServerIsoImageRelationOperatorMock) DeleteServerIsoImage(ctx context.Con
 }
 
 func (s ServerIsoImageRelationOperatorMock) LinkIsoImage(ctx context.Context, serverID string, isoimageID string) error {
-	if isoimageID == "success" {
+	if strings.HasPrefix(isoimageID, "success") {
 		return nil
 	}
 	return errors.New("error")
 }
 
 func (s ServerIsoImageRelationOperatorMock) UnlinkIsoImage(ctx context.Context, serverID string, isoimageID string) error {
-	if isoimageID == "success" {
+	if strings.HasPrefix(isoimageID, "success") {
 		return nil
 	}
 	return errors.New("error")
@@ -76,50 +95,47 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": true,
+					"server_uuid":              "test UUID",
+					"server_iso_images_linked": []string{"success"},
 				}},
 			},
 			success: true,
 			message: "Unlinked the server (test UUID) and the ISO image (success)",
 		},
 		{
-			name: "skip due to no ISO image",
+			name: "several ISO images",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
-				config: testConfigNoISOImage,
+				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": true,
+					"server_uuid":              "test UUID",
+					"server_iso_images_linked": []string{"success", "successDriver"},
 				}},
 			},
 			success: true,
-			message: "No ISO image is requested. Skipping unlinking server with an ISO image...",
+			message: "Unlinked the server (test UUID) and the ISO image (successDriver)",
 		},
 		{
-			name: "HTTP call fail",
+			name: "skip due to no ISO image",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
-				config: testConfigISOImage,
+				config: testConfigNoISOImage,
 				ui:     ui,
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "fail",
-					"server_iso_image_linked": true,
+					"server_uuid":              "test UUID",
+					"server_iso_images_linked": []string{"success"},
 				}},
 			},
-			success: false,
-			message: "Error unlink server (test UUID) and ISO image (fail). Please unlink them manually: error",
+			success: true,
+			message: "No ISO image is requested. Skipping unlinking server with an ISO image...",
 		},
 		{
-			name: "convert server_uuid to string fail",
+			name: "HTTP call fail",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
@@ -127,15 +143,15 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": true,
+					"server_uuid":              "test UUID",
+					"server_iso_images_linked": []string{"fail"},
 				}},
 			},
 			success: false,
-			message: "cannot convert server_uuid to string",
+			message: "Error unlink server (test UUID) and ISO image (fail). Please unlink them manually: error",
 		},
 		{
-			name: "empty server_uuid",
+			name: "convert server_uuid to string fail",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
@@ -143,16 +159,14 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "",
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": true,
+					"server_iso_images_linked": []string{"success"},
 				}},
 			},
 			success: false,
-			message: "serverUUID is empty",
+			message: "cannot convert server_uuid to string",
 		},
 		{
-			name: "convert iso_image_uuid to string fail",
+			name: "empty server_uuid",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
@@ -160,32 +174,15 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"server_iso_image_linked": true,
+					"server_uuid":              "",
+					"server_iso_images_linked": []string{"success"},
 				}},
 			},
 			success: false,
-			message: "cannot convert iso_image_uuid to string",
-		},
-		{
-			name: "empty iso_image_uuid",
-			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
-				config: testConfigISOImage,
-				ui:     ui,
-			},
-			args: args{
-				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "",
-					"server_iso_image_linked": true,
-				}},
-			},
-			success: true,
-			message: "No ISO image UUID detected. Skipping unlinking the server with an ISO image...",
+			message: "serverUUID is empty",
 		},
 		{
-			name: "convert server_iso_image_linked to boolean fail",
+			name: "convert server_iso_images_linked to []string fail",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
@@ -193,15 +190,14 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":    "test UUID",
-					"iso_image_uuid": "success",
+					"server_uuid": "test UUID",
 				}},
 			},
 			success: false,
-			message: "cannot convert server_iso_image_linked to boolean",
+			message: "cannot convert server_iso_images_linked to []string",
 		},
 		{
-			name: "server_iso_image_linked is false",
+			name: "no ISO image is linked",
 			fields: fields{
 				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
@@ -209,13 +205,12 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": false,
+					"server_uuid":              "test UUID",
+					"server_iso_images_linked": []string{},
 				}},
 			},
 			success: true,
-			message: "the server is not linked with the ISO image.",
+			message: "the server is not linked with any ISO image.",
 		},
 	}
 	for _, tt := range tests {
@@ -241,7 +236,6 @@ func Test_stepLinkServerISOImage_Cleanup(t *testing.T) {
 
 func Test_stepLinkServerISOImage_Run(t *testing.T) {
 	type fields struct {
-		client gsclient.ServerIsoImageRelationOperator
 		config *Config
 		ui     packer.Ui
 	}
@@ -253,41 +247,66 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 	testConfigISOImage := produceTestConfig(map[string]interface{}{
 		"isoimage_uuid": "test",
 	})
+	testConfigISOImages := produceTestConfig(map[string]interface{}{
+		"iso_images": []map[string]interface{}{
+			{"uuid": "test"},
+			{"uuid": "test", "boot": true},
+			{"uuid": "test"},
+		},
+	})
 	testConfigNoISOImage := produceTestConfig(make(map[string]interface{}))
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   multistep.StepAction
+		name        string
+		fields      fields
+		args        args
+		want        multistep.StepAction
+		linked      []string
+		bootDevices []string
 	}{
 		{
 			name: "success",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				ctx: context.Background(),
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":    "test UUID",
-					"iso_image_uuid": "success",
+					"server_uuid":     "test UUID",
+					"iso_image_uuids": []string{"success"},
 				}},
 			},
-			want: multistep.ActionContinue,
+			want:        multistep.ActionContinue,
+			linked:      []string{"success"},
+			bootDevices: []string{"success=true"},
+		},
+		{
+			name: "several ISO images, the boot device is set last",
+			fields: fields{
+				config: testConfigISOImages,
+				ui:     ui,
+			},
+			args: args{
+				ctx: context.Background(),
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":     "test UUID",
+					"iso_image_uuids": []string{"successBoot1", "success2", "success3"},
+				}},
+			},
+			want:        multistep.ActionContinue,
+			linked:      []string{"successBoot1", "success2", "success3"},
+			bootDevices: []string{"successBoot1=false", "success2=true"},
 		},
 		{
 			name: "skip due to no ISO image",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigNoISOImage,
 				ui:     ui,
 			},
 			args: args{
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":             "test UUID",
-					"iso_image_uuid":          "success",
-					"server_iso_image_linked": true,
+					"server_uuid":     "test UUID",
+					"iso_image_uuids": []string{"success"},
 				}},
 			},
 			want: multistep.ActionContinue,
@@ -295,23 +314,21 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 		{
 			name: "API call fail",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				ctx: context.Background(),
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":    "test UUID",
-					"iso_image_uuid": "fail",
+					"server_uuid":     "test UUID",
+					"iso_image_uuids": []string{"fail"},
 				}},
 			},
 			want: multistep.ActionHalt,
 		},
 		{
-			name: "convert iso_image_uuid to string fail",
+			name: "convert iso_image_uuids to []string fail",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
@@ -324,17 +341,16 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 			want: multistep.ActionHalt,
 		},
 		{
-			name: "empty iso_image_uuid",
+			name: "empty iso_image_uuids",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				ctx: context.Background(),
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":    "test UUID",
-					"iso_image_uuid": "",
+					"server_uuid":     "test UUID",
+					"iso_image_uuids": []string{},
 				}},
 			},
 			want: multistep.ActionContinue,
@@ -342,14 +358,13 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 		{
 			name: "convert server_uuid to string fail",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				ctx: context.Background(),
 				state: StateBagMock{state: map[string]interface{}{
-					"iso_image_uuid": "success",
+					"iso_image_uuids": []string{"success"},
 				}},
 			},
 			want: multistep.ActionHalt,
@@ -357,15 +372,14 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 		{
 			name: "empty server_uuid",
 			fields: fields{
-				client: ServerIsoImageRelationOperatorMock{},
 				config: testConfigISOImage,
 				ui:     ui,
 			},
 			args: args{
 				ctx: context.Background(),
 				state: StateBagMock{state: map[string]interface{}{
-					"server_uuid":    "",
-					"iso_image_uuid": "success",
+					"server_uuid":     "",
+					"iso_image_uuids": []string{"success"},
 				}},
 			},
 			want: multistep.ActionHalt,
@@ -373,26 +387,27 @@ func Test_stepLinkServerISOImage_Run(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			bootDevices := []string{}
 			s := &stepLinkServerISOImage{
-				client: tt.fields.client,
+				client: ServerIsoImageRelationOperatorMock{bootDevices: &bootDevices},
 				config: tt.fields.config,
 				ui:     tt.fields.ui,
 			}
 			if got := s.Run(tt.args.ctx, tt.args.state); got != tt.want {
 				t.Errorf("Run() = %v, want %v", got, tt.want)
 			}
-			if tt.want == multistep.ActionContinue &&
-				tt.args.state.Get("iso_image_uuid").(string) != "" &&
-				(tt.fields.config.IsoImageUUID != "" ||
-					tt.fields.config.IsoImageURL != "") {
-				linked, ok := tt.args.state.Get("server_iso_image_linked").(bool)
+			if tt.linked != nil {
+				linked, ok := tt.args.state.Get("server_iso_images_linked").([]string)
 				if !ok {
-					t.Error("cannot convert server_iso_image_linked to boolean")
+					t.Error("cannot convert server_iso_images_linked to []string")
 				}
-				if !linked {
-					t.Errorf("server_iso_image_linked = %v, want true", linked)
+				if !reflect.DeepEqual(linked, tt.linked) {
+					t.Errorf("server_iso_images_linked = %v, want %v", linked, tt.linked)
 				}
 			}
+			if tt.bootDevices != nil && !reflect.DeepEqual(bootDevices, tt.bootDevices) {
+				t.Errorf("boot devices = %v, want %v", bootDevices, tt.bootDevices)
+			}
 		})
 	}
 }
@@ -0,0 +1,58 @@
+package gridscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepRemoveOldTemplates applies the template_retention to the templates of
+// the build.
+type stepRemoveOldTemplates struct {
+	client gsclient.TemplateOperator
+	config *Config
+	ui     packer.Ui
+}
+
+func (s *stepRemoveOldTemplates) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	client := s.client
+	c := s.config
+	ui := s.ui
+	if c.TemplateRetention == nil {
+		return multistep.ActionContinue
+	}
+	templateUUID, _ := state.Get("template_uuid").(string)
+	ui.Say("Applying the template retention...")
+	// The template has been created, a failing retention does not fail
+	// the build
+	templates, err := client.GetTemplateList(context.Background())
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error getting templates, no old template has been removed: %s", err))
+		return multistep.ActionContinue
+	}
+	expired := c.TemplateRetention.expiredTemplates(templates, templateUUID, time.Now())
+	if len(expired) == 0 {
+		ui.Say("No old template has to be removed")
+		return multistep.ActionContinue
+	}
+	for _, template := range expired {
+		props := template.Properties
+		err := client.DeleteTemplate(context.Background(), props.ObjectUUID)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error removing the old template %v (%v). Please remove it manually: %s", props.Name, props.ObjectUUID, err))
+			continue
+		}
+		ui.Say(fmt.Sprintf("Removed the old template %v (%v), created at %v",
+			props.Name, props.ObjectUUID, props.CreateTime.Format(time.RFC3339)))
+	}
+	return multistep.ActionContinue
+}
+
+func (s *stepRemoveOldTemplates) Cleanup(state multistep.StateBag) {
+	// no cleanup
+}
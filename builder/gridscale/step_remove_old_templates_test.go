@@ -0,0 +1,82 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+type listingTemplateOperatorMock struct {
+	TemplateOperatorMock
+	err error
+}
+
+func (t listingTemplateOperatorMock) GetTemplateList(ctx context.Context) ([]gsclient.Template, error) {
+	return []gsclient.Template{
+		produceTestTemplate("test", "packer-new", 0),
+		produceTestTemplate("fail", "packer-2", 2),
+		produceTestTemplate("success", "packer-1", 1),
+	}, t.err
+}
+
+func Test_stepRemoveOldTemplates_Run(t *testing.T) {
+	tests := []struct {
+		name         string
+		raws         map[string]interface{}
+		err          error
+		sayMessage   string
+		errorMessage string
+	}{
+		{
+			name: "no retention",
+			raws: map[string]interface{}{},
+		},
+		{
+			name: "remove old templates",
+			raws: map[string]interface{}{
+				"template_retention": map[string]interface{}{"name_prefix": "packer-", "keep_latest": 1},
+			},
+			sayMessage:   "Removed the old template packer-1 (success), created at 2021-06-09T00:00:00Z",
+			errorMessage: "Error removing the old template packer-2 (fail). Please remove it manually: error",
+		},
+		{
+			name: "nothing to remove",
+			raws: map[string]interface{}{
+				"template_retention": map[string]interface{}{"name_prefix": "packer-", "keep_latest": 3},
+			},
+			sayMessage: "No old template has to be removed",
+		},
+		{
+			name: "API call fail",
+			raws: map[string]interface{}{
+				"template_retention": map[string]interface{}{"name_prefix": "packer-", "keep_latest": 1},
+			},
+			err:          errors.New("error"),
+			sayMessage:   "Applying the template retention...",
+			errorMessage: "Error getting templates, no old template has been removed: error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := &uiMock{}
+			s := &stepRemoveOldTemplates{
+				client: listingTemplateOperatorMock{err: tt.err},
+				config: produceTestConfig(tt.raws),
+				ui:     ui,
+			}
+			state := StateBagMock{state: map[string]interface{}{"template_uuid": "test"}}
+			if got := s.Run(context.Background(), state); got != multistep.ActionContinue {
+				t.Errorf("Run() = %v, want %v", got, multistep.ActionContinue)
+			}
+			if ui.sayMessage != tt.sayMessage {
+				t.Errorf("say message = %v, want %v", ui.sayMessage, tt.sayMessage)
+			}
+			if ui.errorMessage != tt.errorMessage {
+				t.Errorf("error message = %v, want %v", ui.errorMessage, tt.errorMessage)
+			}
+		})
+	}
+}
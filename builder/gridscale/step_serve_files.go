@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -48,7 +49,7 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 	c := s.config
 	ui := s.ui
 	// If a list of file is set, serve all files in the list
-	if len(c.Files) > 0 {
+	if c.needsFileServer() {
 		client := s.client
 		ui.Say("Creating a HTTP server to serve files...")
 		// Create a server
@@ -68,6 +69,7 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 			return multistep.ActionHalt
 		}
 		state.Put("file_server_uuid", serverRes.ObjectUUID)
+		manifestFrom(state).created(manifestResourceServer, serverRes.ObjectUUID)
 		// Get ubuntu 20.04 template
 		template, err := client.GetTemplateByName(context.Background(), ubuntuTemplateName)
 		if err != nil {
@@ -100,6 +102,7 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 			return multistep.ActionHalt
 		}
 		state.Put("file_server_storage_uuid", storageRes.ObjectUUID)
+		manifestFrom(state).created(manifestResourceStorage, storageRes.ObjectUUID)
 		// Create an IPv4 address
 		ipAddrRes, err := client.CreateIP(
 			context.Background(),
@@ -116,6 +119,7 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 			return multistep.ActionHalt
 		}
 		state.Put("file_server_ip_uuid", ipAddrRes.ObjectUUID)
+		manifestFrom(state).created(manifestResourceIP, ipAddrRes.ObjectUUID)
 		// Get public network UUID
 		pubNetUUID, ok := state.Get("public_network_uuid").(string)
 		if !ok {
@@ -183,6 +187,15 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
+		// Upload the answer files of the unattended installation
+		err = uploadUnattendedFilesToServer(sshCfg, ui, c.unattendedFiles)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error uploading answer files to file server: %s", err))
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
 		// SSH to the file server to start serving files
 		_, stderr, _, err := sshCfg.Run("nohup python3 -u -m http.server 8080 </dev/null >/dev/null 2>&1 &", 60)
 		// Handle errors
@@ -206,8 +219,6 @@ func (s *stepServeHTTPFiles) Run(ctx context.Context, state multistep.StateBag)
 		state.Put("http_ip", ipAddrRes.IP)
 		state.Put("http_port", 8080)
 
-		replaceFileServerPlaceholder(c, ipAddrRes.IP+":8080")
-
 		ui.Say(fmt.Sprintf("a file server is ready at address: %s:8080", ipAddrRes.IP))
 		return multistep.ActionContinue
 	}
@@ -219,7 +230,7 @@ func (s *stepServeHTTPFiles) Cleanup(state multistep.StateBag) {
 	client := s.client
 	ui := s.ui
 	c := s.config
-	if len(c.Files) > 0 {
+	if c.needsFileServer() {
 		removeFileServerResources(client, state, ui)
 	}
 }
@@ -264,6 +275,23 @@ func uploadFilesToServer(sshCfg *easyssh.MakeConfig, ui packer.Ui, files []strin
 	return nil
 }
 
+// uploadUnattendedFilesToServer uploads the rendered answer files to the
+// unattended directory of the file server.
+func uploadUnattendedFilesToServer(sshCfg *easyssh.MakeConfig, ui packer.Ui, files map[string]string) error {
+	for _, name := range unattendedInstallFiles(files) {
+		content := files[name]
+		remotePath := path.Join(unattendedInstallDir, name)
+		ui.Say(fmt.Sprintf("Uploading answer file \"%s\"...", remotePath))
+		err := sshCfg.ScpReader(strings.NewReader(content), int64(len(content)), remotePath)
+		if err != nil {
+			ui.Say(fmt.Sprintf("Failed to upload answer file \"%s\"!", remotePath))
+			return err
+		}
+		ui.Say(fmt.Sprintf("Uploaded answer file \"%s\" successfully", remotePath))
+	}
+	return nil
+}
+
 func removeFileServerResources(client fileHTTPServerCreator, state multistep.StateBag, ui packer.Ui) {
 	ui.Say("Destroying all resources of the file server...")
 	if fileServerUUID, _ := state.Get("file_server_uuid").(string); fileServerUUID != "" {
@@ -274,29 +302,33 @@ func removeFileServerResources(client fileHTTPServerCreator, state multistep.Sta
 		if err := client.DeleteServer(context.Background(), fileServerUUID); err != nil {
 			ui.Error(fmt.Sprintf(
 				"Error removing file server: %s, please go to gridscale panel to remove it", err))
+		} else {
+			manifestFrom(state).deleted(manifestResourceServer, fileServerUUID)
+			ui.Say(fmt.Sprintf("Destroyed the file server (%s)", fileServerUUID))
 		}
-		ui.Say(fmt.Sprintf("Destroyed the file server (%s)", fileServerUUID))
 	}
 	if fileServerStorageUUID, _ := state.Get("file_server_storage_uuid").(string); fileServerStorageUUID != "" {
 		if err := client.DeleteStorage(context.Background(), fileServerStorageUUID); err != nil {
 			ui.Error(fmt.Sprintf(
 				"Error removing file server's storage: %s, please go to gridscale panel to remove it", err))
+		} else {
+			manifestFrom(state).deleted(manifestResourceStorage, fileServerStorageUUID)
+			ui.Say(fmt.Sprintf("Destroyed the file server's storage (%s)", fileServerStorageUUID))
 		}
-		ui.Say(fmt.Sprintf("Destroyed the file server's storage (%s)", fileServerStorageUUID))
 	}
 	if fileServerIPAddrUUID, _ := state.Get("file_server_ip_uuid").(string); fileServerIPAddrUUID != "" {
 		if err := client.DeleteIP(context.Background(), fileServerIPAddrUUID); err != nil {
 			ui.Error(fmt.Sprintf(
 				"Error removing file server's IP address: %s, please go to gridscale panel to remove it", err))
+		} else {
+			manifestFrom(state).deleted(manifestResourceIP, fileServerIPAddrUUID)
+			ui.Say(fmt.Sprintf("Destroyed the file server's IP address (%s)", fileServerIPAddrUUID))
 		}
-		ui.Say(fmt.Sprintf("Destroyed the file server's IP address (%s)", fileServerIPAddrUUID))
 	}
 }
 
-func replaceFileServerPlaceholder(cfg *Config, serverAddr string) {
-	newBootCommands := []string{}
-	for _, bootCmd := range cfg.BootCommand {
-		newBootCommands = append(newBootCommands, strings.ReplaceAll(bootCmd, fileServerAddressPlaceholder, serverAddr))
-	}
-	cfg.BootCommand = newBootCommands
+// replaceFileServerPlaceholder replaces the deprecated file server address
+// placeholder with the template variables of the file server's address.
+func replaceFileServerPlaceholder(bootCommand string) string {
+	return strings.ReplaceAll(bootCommand, fileServerAddressPlaceholder, "{{ .HTTPIP }}:{{ .HTTPPort }}")
 }
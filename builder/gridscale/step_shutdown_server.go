@@ -13,13 +13,25 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
+const (
+	shutdownFallbackAPIStop = "api_stop"
+	shutdownFallbackAPI     = "api"
+	shutdownFallbackStop    = "stop"
+	shutdownFallbackNone    = "none"
+)
+
 type stepShutdownServer struct {
 	client gsclient.ServerOperator
+	config *Config
 	ui     packer.Ui
+	// pollInterval is the interval of checking whether the server has been
+	// powered off by the shutdown_command.
+	pollInterval time.Duration
 }
 
 func (s *stepShutdownServer) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	client := s.client
+	c := s.config
 	ui := s.ui
 	// Get server UUID
 	serverUUID, ok := state.Get("server_uuid").(string)
@@ -35,39 +47,69 @@ func (s *stepShutdownServer) Run(ctx context.Context, state multistep.StateBag)
 		state.Put("error", err)
 		return multistep.ActionHalt
 	}
-	ui.Say(fmt.Sprintf("Gracefully shutting down server (%s)...", serverUUID))
-	//set the shutdown timeout specifically
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeoutSecs*time.Second)
-	defer cancel()
-	err := suppressHTTPErrorCodes(
-		client.ShutdownServer(shutdownCtx, serverUUID),
-		http.StatusBadRequest,
-	)
-	if err != nil && err != shutdownCtx.Err() {
-		err := fmt.Errorf("Error shutting down server: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+
+	// Run the shutdown_command through the communicator
+	if comm, _ := state.Get("communicator").(packer.Communicator); comm != nil && c.ShutdownCommand != "" {
+		ui.Say(fmt.Sprintf("Running the shutdown command on server (%s)...", serverUUID))
+		err := comm.Start(ctx, &packer.RemoteCmd{Command: c.ShutdownCommand})
+		if err != nil {
+			err := fmt.Errorf("Error running the shutdown command: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		pollInterval := s.pollInterval
+		if pollInterval == 0 {
+			pollInterval = defaultServerPowerPollInterval
+		}
+		err = waitForServerPowerOff(ctx, client, serverUUID, pollInterval, c.ShutdownTimeout)
+		if err == nil {
+			ui.Say(fmt.Sprintf("Server (%s) has been shut down by the shutdown command", serverUUID))
+			return multistep.ActionContinue
+		}
+		if ctx.Err() != nil || c.ShutdownFallback == shutdownFallbackNone {
+			err := fmt.Errorf("Error waiting for the shutdown command: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Server (%s) has not been shut down by the shutdown command: %s", serverUUID, err))
 	}
-	// if the server cannot be shutdown gracefully, try to turn it off
-	if err != nil && err == shutdownCtx.Err() {
-		ui.Say(fmt.Sprintf("Could not gracefully shutdown server (%s). Trying to turn it off instead...", serverUUID))
+
+	if c.ShutdownFallback != shutdownFallbackStop {
+		ui.Say(fmt.Sprintf("Gracefully shutting down server (%s)...", serverUUID))
+		// The server gets shutdown_timeout to shut down
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+		defer cancel()
 		err := suppressHTTPErrorCodes(
-			client.StopServer(context.Background(), serverUUID),
+			client.ShutdownServer(shutdownCtx, serverUUID),
 			http.StatusBadRequest,
 		)
-		if err != nil {
+		if err == nil {
+			ui.Say(fmt.Sprintf("Gracefully shut down server (%s)", serverUUID))
+			return multistep.ActionContinue
+		}
+		if err != shutdownCtx.Err() || c.ShutdownFallback == shutdownFallbackAPI {
+			err := fmt.Errorf("Error shutting down server: %s", err)
 			state.Put("error", err)
-			ui.Error(fmt.Sprintf(
-				"Error shutdown server: %s", err))
+			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
-		ui.Say(fmt.Sprintf("Successfully turned off server (%s).", serverUUID))
-		return multistep.ActionContinue
+		// if the server cannot be shutdown gracefully, try to turn it off
+		ui.Say(fmt.Sprintf("Could not gracefully shutdown server (%s). Trying to turn it off instead...", serverUUID))
 	}
-	ui.Say(fmt.Sprintf("Gracefully shut down server (%s)", serverUUID))
+	err := suppressHTTPErrorCodes(
+		client.StopServer(context.Background(), serverUUID),
+		http.StatusBadRequest,
+	)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(fmt.Sprintf(
+			"Error shutdown server: %s", err))
+		return multistep.ActionHalt
+	}
+	ui.Say(fmt.Sprintf("Successfully turned off server (%s).", serverUUID))
 	return multistep.ActionContinue
-
 }
 
 func (s *stepShutdownServer) Cleanup(state multistep.StateBag) {
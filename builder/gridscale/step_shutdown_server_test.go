@@ -3,6 +3,7 @@ package gridscale
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/gridscale/gsclient-go/v3"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -12,23 +13,26 @@ import (
 func Test_stepShutdownServer_Run(t *testing.T) {
 	type fields struct {
 		client gsclient.ServerOperator
+		config *Config
 		ui     packer.Ui
 	}
 	type args struct {
-		ctx   context.Context
 		state multistep.StateBag
 	}
 	ui := &uiMock{}
+	polls := 0
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   multistep.StepAction
+		name    string
+		fields  fields
+		args    args
+		want    multistep.StepAction
+		message string
 	}{
 		{
 			name: "success",
 			fields: fields{
 				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{}),
 				ui:     ui,
 			},
 			args: args{
@@ -36,12 +40,14 @@ func Test_stepShutdownServer_Run(t *testing.T) {
 					"server_uuid": "ShutdownSuccess",
 				}},
 			},
-			want: multistep.ActionContinue,
+			want:    multistep.ActionContinue,
+			message: "Gracefully shut down server (ShutdownSuccess)",
 		},
 		{
 			name: "API call fail",
 			fields: fields{
 				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{}),
 				ui:     ui,
 			},
 			args: args{
@@ -49,23 +55,27 @@ func Test_stepShutdownServer_Run(t *testing.T) {
 					"server_uuid": "fail",
 				}},
 			},
-			want: multistep.ActionHalt,
+			want:    multistep.ActionHalt,
+			message: "Error shutting down server: error",
 		},
 		{
 			name: "convert server_uuid to string fail",
 			fields: fields{
 				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{}),
 				ui:     ui,
 			},
 			args: args{
 				state: StateBagMock{state: make(map[string]interface{})},
 			},
-			want: multistep.ActionHalt,
+			want:    multistep.ActionHalt,
+			message: "cannot convert server_uuid to string",
 		},
 		{
 			name: "empty server_uuid",
 			fields: fields{
 				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{}),
 				ui:     ui,
 			},
 			args: args{
@@ -73,18 +83,124 @@ func Test_stepShutdownServer_Run(t *testing.T) {
 					"server_uuid": "",
 				}},
 			},
-			want: multistep.ActionHalt,
+			want:    multistep.ActionHalt,
+			message: "server_uuid is empty",
+		},
+		{
+			name: "shutdown_command",
+			fields: fields{
+				client: poweringOffServerOperatorMock{polls: &polls, offAfter: 2},
+				config: produceTestConfig(map[string]interface{}{
+					"shutdown_command":  "sudo poweroff",
+					"shutdown_fallback": "none",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":  "fail",
+					"communicator": &packer.MockCommunicator{},
+				}},
+			},
+			want:    multistep.ActionContinue,
+			message: "Server (fail) has been shut down by the shutdown command",
+		},
+		{
+			name: "shutdown_command times out",
+			fields: fields{
+				client: poweringOffServerOperatorMock{polls: &polls, offAfter: 1 << 30},
+				config: produceTestConfig(map[string]interface{}{
+					"shutdown_command":  "sudo poweroff",
+					"shutdown_timeout":  "20ms",
+					"shutdown_fallback": "none",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":  "ShutdownSuccess",
+					"communicator": &packer.MockCommunicator{},
+				}},
+			},
+			want:    multistep.ActionHalt,
+			message: "Error waiting for the shutdown command: server (ShutdownSuccess) is still powered on after 20ms",
+		},
+		{
+			name: "shutdown_command times out with fallback",
+			fields: fields{
+				client: poweringOffServerOperatorMock{polls: &polls, offAfter: 1 << 30},
+				config: produceTestConfig(map[string]interface{}{
+					"shutdown_command": "sudo poweroff",
+					"shutdown_timeout": "20ms",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid":  "ShutdownSuccess",
+					"communicator": &packer.MockCommunicator{},
+				}},
+			},
+			want:    multistep.ActionContinue,
+			message: "Gracefully shut down server (ShutdownSuccess)",
+		},
+		{
+			name: "shutdown times out after shutdown_timeout",
+			fields: fields{
+				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"shutdown_timeout": "20ms",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid": "ShutdownHangStopSuccess",
+				}},
+			},
+			want:    multistep.ActionContinue,
+			message: "Successfully turned off server (ShutdownHangStopSuccess).",
+		},
+		{
+			name: "stop",
+			fields: fields{
+				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{
+					"shutdown_fallback": "stop",
+				}),
+				ui: ui,
+			},
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid": "StopSuccess",
+				}},
+			},
+			want:    multistep.ActionContinue,
+			message: "Successfully turned off server (StopSuccess).",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			polls = 0
 			s := &stepShutdownServer{
-				client: tt.fields.client,
-				ui:     tt.fields.ui,
+				client:       tt.fields.client,
+				config:       tt.fields.config,
+				ui:           tt.fields.ui,
+				pollInterval: time.Millisecond,
 			}
-			if got := s.Run(tt.args.ctx, tt.args.state); got != tt.want {
+			if got := s.Run(context.Background(), tt.args.state); got != tt.want {
 				t.Errorf("Run() = %v, want %v", got, tt.want)
 			}
+			message := ui.sayMessage
+			if tt.want == multistep.ActionHalt {
+				message = ui.errorMessage
+			}
+			if message != tt.message {
+				t.Errorf("message = %v, want %v", message, tt.message)
+			}
+			if comm, ok := tt.args.state.Get("communicator").(*packer.MockCommunicator); ok && comm.StartCmd.Command != "sudo poweroff" {
+				t.Errorf("command = %q, want %q", comm.StartCmd.Command, "sudo poweroff")
+			}
 		})
 	}
 }
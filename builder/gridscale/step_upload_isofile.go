@@ -0,0 +1,130 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale/easyssh"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type stepUploadISOFiles struct {
+	config *Config
+	ui     packer.Ui
+	// uploader uploads the ISO images. If nil, it is created from
+	// iso_upload_target.
+	uploader isoFileUploader
+}
+
+func (s *stepUploadISOFiles) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	c := s.config
+	ui := s.ui
+	if !c.hasISOFile() {
+		ui.Say("No local ISO image is requested. Skipping uploading ISO images...")
+		return multistep.ActionContinue
+	}
+	if s.uploader == nil {
+		uploader, err := newISOFileUploader(c, state)
+		if err != nil {
+			err := fmt.Errorf("Error preparing the upload of ISO images: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		s.uploader = uploader
+	}
+	// The URLs are in the order of the requested ISO images, empty for the
+	// ones which are not local.
+	urls := make([]string, len(c.ISOImages))
+	state.Put("iso_file_urls", urls)
+	uploaded := []string{}
+	state.Put("iso_files_uploaded", uploaded)
+	for i, iso := range c.ISOImages {
+		if !iso.isLocal() {
+			continue
+		}
+		file := iso.File
+		if iso.cd {
+			file, _ = state.Get("cd_image_path").(string)
+			if file == "" {
+				err := errors.New("the CD image has not been created")
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+		name := fmt.Sprintf("%d-%s", i, filepath.Base(file))
+		ui.Say(fmt.Sprintf("Uploading the ISO image %s to %s...", file, c.ISOUploadTarget))
+		url, err := s.uploadISOFile(ctx, file, name)
+		if err != nil {
+			err := fmt.Errorf("Error uploading the ISO image %s: %s", file, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		urls[i] = url
+		uploaded = append(uploaded, name)
+		state.Put("iso_files_uploaded", uploaded)
+		ui.Say(fmt.Sprintf("Uploaded the ISO image %s", file))
+	}
+	return multistep.ActionContinue
+}
+
+func (s *stepUploadISOFiles) uploadISOFile(ctx context.Context, file, name string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	body := s.ui.TrackProgress(filepath.Base(file), 0, fi.Size(), f)
+	defer body.Close()
+	return s.uploader.Upload(ctx, name, fi.Size(), body)
+}
+
+// newISOFileUploader creates the uploader of iso_upload_target.
+func newISOFileUploader(c *Config, state multistep.StateBag) (isoFileUploader, error) {
+	if c.ISOUploadTarget == isoUploadTargetS3 {
+		return newS3ISOUploader(c.ISOUploadS3, c.ServerName)
+	}
+	httpIP, _ := state.Get("http_ip").(string)
+	httpPort, _ := state.Get("http_port").(int)
+	if httpIP == "" {
+		return nil, errors.New("the file server is not running")
+	}
+	return &fileServerISOUploader{
+		sshCfg: &easyssh.MakeConfig{
+			User:     "root",
+			Server:   httpIP,
+			Password: fileServerPlainPassword,
+			Port:     "22",
+		},
+		address: fmt.Sprintf("%s:%d", httpIP, httpPort),
+	}, nil
+}
+
+func (s *stepUploadISOFiles) Cleanup(state multistep.StateBag) {
+	ui := s.ui
+	c := s.config
+	if !c.hasISOFile() {
+		return
+	}
+	uploaded, _ := state.Get("iso_files_uploaded").([]string)
+	for _, name := range uploaded {
+		ui.Say(fmt.Sprintf("Removing the uploaded ISO image %s...", name))
+		err := s.uploader.Remove(context.Background(), name)
+		if err != nil {
+			ui.Error(fmt.Sprintf(
+				"Error removing the uploaded ISO image %s. Please remove it manually: %s", name, err))
+			continue
+		}
+		ui.Say(fmt.Sprintf("Removed the uploaded ISO image %s", name))
+	}
+}
@@ -0,0 +1,174 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// isoFileUploaderMock records the uploads. Uploads of ISO images named
+// fail.iso fail.
+type isoFileUploaderMock struct {
+	uploads map[string]string
+	removed []string
+}
+
+func (u *isoFileUploaderMock) Upload(ctx context.Context, name string, size int64, body io.Reader) (string, error) {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) != size {
+		return "", errors.New("unexpected size")
+	}
+	if strings.HasSuffix(name, "-fail.iso") {
+		return "", errors.New("error")
+	}
+	u.uploads[name] = string(content)
+	return "http://test/" + name, nil
+}
+
+func (u *isoFileUploaderMock) Remove(ctx context.Context, name string) error {
+	u.removed = append(u.removed, name)
+	return nil
+}
+
+// produceTestISOFile creates a local ISO image with the content "iso".
+func produceTestISOFile(t *testing.T, name string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte("iso"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func Test_stepUploadISOFiles(t *testing.T) {
+	isoFile := produceTestISOFile(t, "test.iso")
+	failISOFile := produceTestISOFile(t, "fail.iso")
+	cdFile := produceTestISOFile(t, "cd.iso")
+	tests := []struct {
+		name     string
+		config   *Config
+		state    map[string]interface{}
+		want     multistep.StepAction
+		urls     []string
+		uploaded []string
+		message  string
+	}{
+		{
+			name: "no local ISO image",
+			config: produceTestConfig(map[string]interface{}{
+				"isoimage_url": "test URL",
+			}),
+			want:    multistep.ActionContinue,
+			message: "No local ISO image is requested. Skipping uploading ISO images...",
+		},
+		{
+			name: "iso_file",
+			config: produceTestConfig(map[string]interface{}{
+				"iso_file": isoFile,
+			}),
+			want:     multistep.ActionContinue,
+			urls:     []string{"http://test/0-test.iso"},
+			uploaded: []string{"0-test.iso"},
+			message:  "Uploaded the ISO image " + isoFile,
+		},
+		{
+			name: "local and remote ISO images",
+			config: produceTestConfig(map[string]interface{}{
+				"iso_images": []map[string]interface{}{
+					{"uuid": "test"},
+					{"file": isoFile, "boot": true},
+				},
+			}),
+			want:     multistep.ActionContinue,
+			urls:     []string{"", "http://test/1-test.iso"},
+			uploaded: []string{"1-test.iso"},
+			message:  "Uploaded the ISO image " + isoFile,
+		},
+		{
+			name: "CD",
+			config: produceTestConfig(map[string]interface{}{
+				"iso_file": isoFile,
+				"cd_content": map[string]string{
+					"user-data": "",
+				},
+			}),
+			state: map[string]interface{}{
+				"cd_image_path": cdFile,
+			},
+			want:     multistep.ActionContinue,
+			urls:     []string{"http://test/0-test.iso", "http://test/1-cd.iso"},
+			uploaded: []string{"0-test.iso", "1-cd.iso"},
+			message:  "Uploaded the ISO image " + cdFile,
+		},
+		{
+			name: "CD is not created",
+			config: produceTestConfig(map[string]interface{}{
+				"cd_content": map[string]string{
+					"user-data": "",
+				},
+			}),
+			want:    multistep.ActionHalt,
+			urls:    []string{""},
+			message: "the CD image has not been created",
+		},
+		{
+			name: "upload fails",
+			config: produceTestConfig(map[string]interface{}{
+				"iso_images": []map[string]interface{}{
+					{"file": isoFile, "boot": true},
+					{"file": failISOFile},
+				},
+			}),
+			want:     multistep.ActionHalt,
+			urls:     []string{"http://test/0-test.iso", ""},
+			uploaded: []string{"0-test.iso"},
+			message:  "Error uploading the ISO image " + failISOFile + ": error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := &uiMock{}
+			uploader := &isoFileUploaderMock{uploads: map[string]string{}}
+			s := &stepUploadISOFiles{
+				config:   tt.config,
+				ui:       ui,
+				uploader: uploader,
+			}
+			state := StateBagMock{state: map[string]interface{}{}}
+			for k, v := range tt.state {
+				state.Put(k, v)
+			}
+			if got := s.Run(context.Background(), state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			message := ui.sayMessage
+			if tt.want == multistep.ActionHalt {
+				message = ui.errorMessage
+			}
+			if message != tt.message {
+				t.Errorf("message = %v, want %v", message, tt.message)
+			}
+			if urls, _ := state.Get("iso_file_urls").([]string); !reflect.DeepEqual(urls, tt.urls) {
+				t.Errorf("iso_file_urls = %v, want %v", urls, tt.urls)
+			}
+			for _, name := range tt.uploaded {
+				if uploader.uploads[name] != "iso" {
+					t.Errorf("upload %s = %q, want %q", name, uploader.uploads[name], "iso")
+				}
+			}
+			s.Cleanup(state)
+			if !reflect.DeepEqual(uploader.removed, tt.uploaded) {
+				t.Errorf("removed = %v, want %v", uploader.removed, tt.uploaded)
+			}
+		})
+	}
+}
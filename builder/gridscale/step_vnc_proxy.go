@@ -0,0 +1,155 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepVNCProxy exposes a local TCP VNC endpoint for the whole build. Every
+// VNC viewer connecting to it is bridged to its own websocket to the
+// server's console.
+type stepVNCProxy struct {
+	client gsclient.ServerOperator
+	config *Config
+	ui     packer.Ui
+	// dial opens the transport to the VNC server of the console
+	// identified by the given console token.
+	dial func(consoleToken string) (net.Conn, error)
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+}
+
+func (s *stepVNCProxy) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	c := s.config
+	ui := s.ui
+	if c.VNCPort == 0 {
+		ui.Say("vnc_port is not set. Skipping exposing a local VNC endpoint...")
+		return multistep.ActionContinue
+	}
+	// Get server UUID
+	serverUUID, ok := state.Get("server_uuid").(string)
+	if !ok {
+		err := errors.New("cannot convert server_uuid to string")
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	if serverUUID == "" {
+		err := errors.New("serverUUID is empty")
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	if s.dial == nil {
+		s.dial = dialConsoleWebsocket
+	}
+	addr := net.JoinHostPort(c.VNCBindAddress, strconv.Itoa(c.VNCPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		err := fmt.Errorf("Error starting local VNC endpoint: %s", err)
+		ui.Error(err.Error())
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+	s.listener = listener
+	s.conns = make(map[net.Conn]bool)
+	go s.serve(listener, serverUUID)
+	ui.Say(fmt.Sprintf("VNC viewers can connect to vnc://%s", listener.Addr().String()))
+	state.Put("vnc_proxy_address", listener.Addr().String())
+	return multistep.ActionContinue
+}
+
+// serve accepts VNC viewers until the listener is closed.
+func (s *stepVNCProxy) serve(listener net.Listener, serverUUID string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("[DEBUG] local VNC endpoint stopped accepting connections: %s", err)
+			return
+		}
+		go s.bridge(conn, serverUUID)
+	}
+}
+
+// bridge pipes a VNC viewer connection to a new console websocket, using
+// a freshly fetched console token.
+func (s *stepVNCProxy) bridge(conn net.Conn, serverUUID string) {
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+	server, err := s.client.GetServer(context.Background(), serverUUID)
+	if err != nil {
+		log.Printf("[DEBUG] error getting server's VNC console token: %s", err)
+		return
+	}
+	console, err := s.dial(server.Properties.ConsoleToken)
+	if err != nil {
+		log.Printf("[DEBUG] error connecting to the server's console: %s", err)
+		return
+	}
+	if !s.track(console) {
+		return
+	}
+	defer s.untrack(console)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(console, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, console)
+		done <- struct{}{}
+	}()
+	// Tear down both ends as soon as either one is closed
+	<-done
+}
+
+// track registers an open connection, so that it gets closed during
+// cleanup. It closes the connection and returns false, if the cleanup
+// already happened.
+func (s *stepVNCProxy) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		conn.Close()
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+// untrack closes a connection and removes it from the open connections.
+func (s *stepVNCProxy) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn.Close()
+	delete(s.conns, conn)
+}
+
+func (s *stepVNCProxy) Cleanup(state multistep.StateBag) {
+	if s.listener == nil {
+		return
+	}
+	s.ui.Say("Closing local VNC endpoint...")
+	s.listener.Close()
+	s.listener = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
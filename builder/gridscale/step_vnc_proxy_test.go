@@ -0,0 +1,137 @@
+package gridscale
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// produceTestFreePort returns a TCP port that is currently not in use.
+func produceTestFreePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// dialTestEchoConsole returns a console transport echoing everything
+// written to it.
+func dialTestEchoConsole(consoleToken string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		io.Copy(server, server)
+		server.Close()
+	}()
+	return client, nil
+}
+
+func Test_stepVNCProxy_Run(t *testing.T) {
+	type args struct {
+		state multistep.StateBag
+	}
+	tests := []struct {
+		name      string
+		vncPort   int
+		portInUse bool
+		args      args
+		want      multistep.StepAction
+		wantProxy bool
+	}{
+		{
+			name:    "vnc_port is not set",
+			vncPort: 0,
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid": "GetSuccess",
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantProxy: false,
+		},
+		{
+			name:    "success",
+			vncPort: produceTestFreePort(t),
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid": "GetSuccess",
+				}},
+			},
+			want:      multistep.ActionContinue,
+			wantProxy: true,
+		},
+		{
+			name:      "port is in use",
+			vncPort:   produceTestFreePort(t),
+			portInUse: true,
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{
+					"server_uuid": "GetSuccess",
+				}},
+			},
+			want:      multistep.ActionHalt,
+			wantProxy: false,
+		},
+		{
+			name:    "server_uuid is missing",
+			vncPort: produceTestFreePort(t),
+			args: args{
+				state: StateBagMock{state: map[string]interface{}{}},
+			},
+			want:      multistep.ActionHalt,
+			wantProxy: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.portInUse {
+				l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tt.vncPort)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+			}
+			s := &stepVNCProxy{
+				client: ServerOperatorMock{},
+				config: produceTestConfig(map[string]interface{}{"vnc_port": tt.vncPort}),
+				ui:     &uiMock{},
+				dial:   dialTestEchoConsole,
+			}
+			if got := s.Run(context.Background(), tt.args.state); got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			defer s.Cleanup(tt.args.state)
+			addr, ok := tt.args.state.Get("vnc_proxy_address").(string)
+			if ok != tt.wantProxy {
+				t.Fatalf("vnc_proxy_address = %v, want proxy %v", addr, tt.wantProxy)
+			}
+			if !tt.wantProxy {
+				return
+			}
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte("RFB")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			got := make([]byte, 3)
+			if _, err := io.ReadFull(conn, got); err != nil || string(got) != "RFB" {
+				t.Errorf("Read() = %q, %v, want RFB", got, err)
+			}
+			// The viewer connections are closed during cleanup
+			s.Cleanup(tt.args.state)
+			if _, err := conn.Read(got); err == nil {
+				t.Errorf("Read() after Cleanup() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+//go:generate packer-sdc struct-markdown
+
+package gridscale
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+)
+
+// TemplateRetentionConfig removes the older templates of a build, e.g. of
+// nightly builds. The templates of a build are the private templates whose
+// names start with `name_prefix` and which have all `labels`. A template is
+// kept if it is one of the newest `keep_latest` templates or younger than
+// `keep_for`. The new template is never removed.
+type TemplateRetentionConfig struct {
+	// The prefix of the names of the templates of the build, e.g. `packer-`.
+	NamePrefix string `mapstructure:"name_prefix" required:"false"`
+	// The labels of the templates of the build, see `template_labels`.
+	Labels []string `mapstructure:"labels" required:"false"`
+	// The number of the newest templates to keep, including the new one.
+	KeepLatest int `mapstructure:"keep_latest" required:"false"`
+	// The age up to which templates are kept, e.g. `168h` for a week.
+	KeepFor time.Duration `mapstructure:"keep_for" required:"false"`
+}
+
+func (r *TemplateRetentionConfig) prepare() []error {
+	var errs []error
+	if r.NamePrefix == "" && len(r.Labels) == 0 {
+		errs = append(errs, errors.New("template_retention: name_prefix or labels have to be set"))
+	}
+	if r.KeepLatest < 0 || r.KeepFor < 0 {
+		errs = append(errs, errors.New("template_retention: keep_latest and keep_for cannot be negative"))
+	}
+	if r.KeepLatest == 0 && r.KeepFor == 0 {
+		errs = append(errs, errors.New("template_retention: keep_latest or keep_for has to be set"))
+	}
+	return errs
+}
+
+// matches reports whether the template belongs to the build.
+func (r *TemplateRetentionConfig) matches(template gsclient.Template) bool {
+	props := template.Properties
+	if !props.Private || !strings.HasPrefix(props.Name, r.NamePrefix) {
+		return false
+	}
+	for _, label := range r.Labels {
+		if !containsString(props.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// expiredTemplates returns the templates of the build which are not kept,
+// oldest first. The new template keepUUID is never returned and always
+// counts as the newest one.
+func (r *TemplateRetentionConfig) expiredTemplates(templates []gsclient.Template, keepUUID string, now time.Time) []gsclient.Template {
+	var group []gsclient.Template
+	kept := 1
+	for _, template := range templates {
+		if template.Properties.ObjectUUID == keepUUID {
+			// Counted as kept already
+			continue
+		}
+		if r.matches(template) {
+			group = append(group, template)
+		}
+	}
+	// Newest first
+	sort.SliceStable(group, func(i, j int) bool {
+		return group[i].Properties.CreateTime.After(group[j].Properties.CreateTime.Time)
+	})
+	var expired []gsclient.Template
+	for _, template := range group {
+		props := template.Properties
+		if kept < r.KeepLatest || (r.KeepFor > 0 && now.Sub(props.CreateTime.Time) < r.KeepFor) {
+			kept++
+			continue
+		}
+		expired = append([]gsclient.Template{template}, expired...)
+	}
+	return expired
+}
@@ -0,0 +1,104 @@
+package gridscale
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+)
+
+var testRetentionNow = time.Date(2021, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// produceTestTemplate returns a private template created days before
+// testRetentionNow.
+func produceTestTemplate(uuid, name string, days int, labels ...string) gsclient.Template {
+	return gsclient.Template{Properties: gsclient.TemplateProperties{
+		ObjectUUID: uuid,
+		Name:       name,
+		Private:    true,
+		Labels:     labels,
+		CreateTime: gsclient.GSTime{Time: testRetentionNow.Add(-time.Duration(days) * 24 * time.Hour)},
+	}}
+}
+
+func TestTemplateRetentionConfig_expiredTemplates(t *testing.T) {
+	public := produceTestTemplate("public", "packer-public", 30)
+	public.Properties.Private = false
+	templates := []gsclient.Template{
+		produceTestTemplate("new", "packer-new", 0),
+		produceTestTemplate("day-3", "packer-3", 3, "nightly"),
+		produceTestTemplate("day-1", "packer-1", 1, "nightly"),
+		produceTestTemplate("day-9", "packer-9", 9, "nightly", "web"),
+		produceTestTemplate("day-5", "packer-5", 5),
+		produceTestTemplate("other", "other", 20, "nightly"),
+		public,
+	}
+	tests := []struct {
+		name      string
+		retention TemplateRetentionConfig
+		want      []string
+	}{
+		{
+			name:      "keep latest by name prefix",
+			retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepLatest: 2},
+			want:      []string{"day-9", "day-5", "day-3"},
+		},
+		{
+			name:      "keep only the new template",
+			retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepLatest: 1},
+			want:      []string{"day-9", "day-5", "day-3", "day-1"},
+		},
+		{
+			name:      "keep for a duration",
+			retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepFor: 4 * 24 * time.Hour},
+			want:      []string{"day-9", "day-5"},
+		},
+		{
+			name:      "keep latest or for a duration",
+			retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepLatest: 4, KeepFor: 2 * 24 * time.Hour},
+			want:      []string{"day-9"},
+		},
+		{
+			name:      "labels",
+			retention: TemplateRetentionConfig{Labels: []string{"nightly"}, KeepLatest: 2},
+			want:      []string{"other", "day-9", "day-3"},
+		},
+		{
+			name:      "name prefix and labels",
+			retention: TemplateRetentionConfig{NamePrefix: "packer-", Labels: []string{"nightly", "web"}, KeepLatest: 1},
+			want:      []string{"day-9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, template := range tt.retention.expiredTemplates(templates, "new", testRetentionNow) {
+				got = append(got, template.Properties.ObjectUUID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expiredTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateRetentionConfig_prepare(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention TemplateRetentionConfig
+		wantErrs  int
+	}{
+		{name: "valid", retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepLatest: 3}},
+		{name: "no group", retention: TemplateRetentionConfig{KeepFor: time.Hour}, wantErrs: 1},
+		{name: "nothing kept", retention: TemplateRetentionConfig{Labels: []string{"nightly"}}, wantErrs: 1},
+		{name: "negative", retention: TemplateRetentionConfig{NamePrefix: "packer-", KeepLatest: -1}, wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := tt.retention.prepare(); len(errs) != tt.wantErrs {
+				t.Errorf("prepare() = %v, want %d errors", errs, tt.wantErrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+//go:generate packer-sdc struct-markdown
+
+package gridscale
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	unattendedFamilyUbuntu = "ubuntu"
+	unattendedFamilyDebian = "debian"
+	unattendedFamilyRHEL   = "rhel"
+	unattendedDiskLVM      = "lvm"
+	unattendedDiskDirect   = "direct"
+	// unattendedInstallDir is the directory of the file server the answer
+	// files are served from.
+	unattendedInstallDir = "unattended"
+)
+
+// unattendedTemplates holds the answer file templates, one directory per
+// distribution family.
+//
+//go:embed unattended
+var unattendedTemplates embed.FS
+
+// unattendedBootCommands are the default boot commands, which make the
+// installer of a distribution family read its answer file.
+var unattendedBootCommands = map[string][]string{
+	unattendedFamilyUbuntu: {
+		"c<wait>",
+		"linux /casper/vmlinuz --- autoinstall ds='nocloud-net;s=http://{{ .HTTPIP }}:{{ .HTTPPort }}/" + unattendedInstallDir + "/'<enter><wait>",
+		"initrd /casper/initrd<enter><wait>",
+		"boot<enter>",
+	},
+	unattendedFamilyDebian: {
+		"<esc><wait>",
+		"auto url=http://{{ .HTTPIP }}:{{ .HTTPPort }}/" + unattendedInstallDir + "/preseed.cfg<enter>",
+	},
+	unattendedFamilyRHEL: {
+		"<up><tab>",
+		" inst.text inst.ks=http://{{ .HTTPIP }}:{{ .HTTPPort }}/" + unattendedInstallDir + "/ks.cfg<enter>",
+	},
+}
+
+var unattendedUsernameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// UnattendedInstallConfig generates the answer file of an installer.
+type UnattendedInstallConfig struct {
+	// The distribution family: `ubuntu` (autoinstall), `debian` (preseed)
+	// or `rhel` (kickstart, e.g. Rocky Linux and AlmaLinux).
+	Family string `mapstructure:"family" required:"true"`
+	// The locale. Default: `en_US.UTF-8`.
+	Locale string `mapstructure:"locale" required:"false"`
+	// The time zone. Default: `UTC`.
+	Timezone string `mapstructure:"timezone" required:"false"`
+	// The disk layout: `lvm` or `direct` (plain partitions). Default: `lvm`.
+	DiskLayout string `mapstructure:"disk_layout" required:"false"`
+	// The host name. Default: `server_name`.
+	Hostname string `mapstructure:"hostname" required:"false"`
+	// The user to create. The user can use sudo without password.
+	// Default: `ssh_username`.
+	Username string `mapstructure:"username" required:"false"`
+	// The password of the user. Default: `ssh_password`. If neither is set,
+	// the password is locked.
+	Password string `mapstructure:"password" required:"false"`
+	// The SSH public keys authorized to log in as the user. The build has
+	// no temporary SSH key to add, so to log in with a key, add the public
+	// key of `ssh_private_key_file`.
+	SSHAuthorizedKeys []string `mapstructure:"ssh_authorized_keys" required:"false"`
+	// Additional packages to install.
+	Packages []string `mapstructure:"packages" required:"false"`
+}
+
+// prepare sets the defaults, validates the configuration and renders the
+// answer files into c.
+func (u *UnattendedInstallConfig) prepare(c *Config) []error {
+	if u.Locale == "" {
+		u.Locale = "en_US.UTF-8"
+	}
+	if u.Timezone == "" {
+		u.Timezone = "UTC"
+	}
+	if u.DiskLayout == "" {
+		u.DiskLayout = unattendedDiskLVM
+	}
+	if u.Hostname == "" {
+		u.Hostname = c.ServerName
+	}
+	if u.Username == "" {
+		u.Username = c.Comm.SSHUsername
+	}
+	if u.Password == "" {
+		u.Password = c.Comm.SSHPassword
+	}
+
+	var errs []error
+	if _, ok := unattendedBootCommands[u.Family]; !ok {
+		errs = append(errs, fmt.Errorf("unattended_install: family %q is not supported, use %q, %q or %q",
+			u.Family, unattendedFamilyUbuntu, unattendedFamilyDebian, unattendedFamilyRHEL))
+	}
+	if u.DiskLayout != unattendedDiskLVM && u.DiskLayout != unattendedDiskDirect {
+		errs = append(errs, fmt.Errorf("unattended_install: disk_layout %q is not supported, use %q or %q",
+			u.DiskLayout, unattendedDiskLVM, unattendedDiskDirect))
+	}
+	if !unattendedUsernameRe.MatchString(u.Username) {
+		errs = append(errs, fmt.Errorf("unattended_install: invalid username %q", u.Username))
+	}
+	if u.Password == "" && len(u.SSHAuthorizedKeys) == 0 {
+		errs = append(errs, errors.New("unattended_install: a password or ssh_authorized_keys have to be set, "+
+			"the answer files cannot contain a temporary SSH key"))
+	}
+	values := append([]string{u.Locale, u.Timezone, u.Hostname, u.Password}, u.SSHAuthorizedKeys...)
+	for _, v := range append(values, u.Packages...) {
+		if strings.ContainsAny(v, "\r\n") {
+			errs = append(errs, errors.New("unattended_install: values cannot contain line breaks"))
+			break
+		}
+	}
+	for _, p := range u.Packages {
+		if p == "" || strings.ContainsAny(p, " \t") {
+			errs = append(errs, fmt.Errorf("unattended_install: invalid package %q", p))
+		}
+	}
+	if !c.hasBootISOImage() {
+		errs = append(errs, errors.New("unattended_install requires an ISO image to boot from"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	files, err := u.render()
+	if err != nil {
+		return []error{fmt.Errorf("unattended_install: %s", err)}
+	}
+	c.unattendedFiles = files
+	if len(c.BootCommand) == 0 {
+		c.BootCommand = unattendedBootCommands[u.Family]
+	}
+	// The answer files power off the server at the end of the installation
+	if c.ISOInstallWait == "" {
+		c.ISOInstallWait = isoInstallWaitPowerOff
+	}
+	return nil
+}
+
+// render renders the answer files of the distribution family. The keys are
+// the file names.
+func (u *UnattendedInstallConfig) render() (map[string]string, error) {
+	dir := path.Join(unattendedInstallDir, u.Family)
+	entries, err := fs.ReadDir(unattendedTemplates, dir)
+	if err != nil {
+		return nil, err
+	}
+	sudoersLine := fmt.Sprintf("%s ALL=(ALL) NOPASSWD: ALL", u.Username)
+	data := struct {
+		UnattendedInstallConfig
+		SudoersLine string
+		LateCommand string
+	}{
+		UnattendedInstallConfig: *u,
+		SudoersLine:             sudoersLine,
+		LateCommand:             u.lateCommand(sudoersLine),
+	}
+	funcs := template.FuncMap{
+		"quote":      strconv.Quote,
+		"shellquote": shellQuote,
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		tmpl, err := template.New(name).Funcs(funcs).ParseFS(unattendedTemplates, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("rendering %s: %s", name, err)
+		}
+		files[name] = buf.String()
+	}
+	return files, nil
+}
+
+// lateCommand returns the shell command that sets up sudo and the
+// authorized keys of the user at the end of a preseeded installation.
+func (u *UnattendedInstallConfig) lateCommand(sudoersLine string) string {
+	sudoers := "/etc/sudoers.d/" + u.Username
+	cmds := []string{
+		fmt.Sprintf("echo %s > %s", shellQuote(sudoersLine), sudoers),
+		"chmod 0440 " + sudoers,
+	}
+	if len(u.SSHAuthorizedKeys) > 0 {
+		sshDir := fmt.Sprintf("/home/%s/.ssh", u.Username)
+		keys := make([]string, len(u.SSHAuthorizedKeys))
+		for i, key := range u.SSHAuthorizedKeys {
+			keys[i] = shellQuote(key)
+		}
+		cmds = append(cmds,
+			"mkdir -p "+sshDir,
+			fmt.Sprintf("printf '%%s\\n' %s > %s/authorized_keys", strings.Join(keys, " "), sshDir),
+			fmt.Sprintf("chown -R %s:%s %s", u.Username, u.Username, sshDir),
+			"chmod 0700 "+sshDir,
+			fmt.Sprintf("chmod 0600 %s/authorized_keys", sshDir),
+		)
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// unattendedInstallFiles returns the sorted names of the answer files.
+func unattendedInstallFiles(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,146 @@
+package gridscale
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the unattended install tests")
+
+func TestUnattendedInstallConfig_render(t *testing.T) {
+	tests := []struct {
+		name              string
+		unattendedInstall map[string]interface{}
+		wantFiles         []string
+	}{
+		{
+			name: "ubuntu",
+			unattendedInstall: map[string]interface{}{
+				"family":              "ubuntu",
+				"password":            `pa'ss w"rd`,
+				"ssh_authorized_keys": []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGolden golden@example.com"},
+				"packages":            []string{"vim", "curl"},
+			},
+			wantFiles: []string{"meta-data", "user-data"},
+		},
+		{
+			name: "debian",
+			unattendedInstall: map[string]interface{}{
+				"family":              "debian",
+				"locale":              "de_DE.UTF-8",
+				"timezone":            "Europe/Berlin",
+				"disk_layout":         "direct",
+				"ssh_authorized_keys": []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGolden golden@example.com", "ssh-rsa AAAAB3NzaC1yc2E it's@example.com"},
+				"packages":            []string{"qemu-guest-agent"},
+			},
+			wantFiles: []string{"preseed.cfg"},
+		},
+		{
+			name: "rhel",
+			unattendedInstall: map[string]interface{}{
+				"family":   "rhel",
+				"hostname": "rocky",
+				"password": `pa'ss w"rd`,
+				"packages": []string{"vim-enhanced"},
+			},
+			wantFiles: []string{"ks.cfg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, err := NewConfig(map[string]interface{}{
+				"api_key":            "test",
+				"api_token":          "test",
+				"server_name":        "golden",
+				"isoimage_uuid":      "test",
+				"ssh_username":       "packer",
+				"unattended_install": tt.unattendedInstall,
+			})
+			if err != nil {
+				t.Fatalf("NewConfig() error = %v", err)
+			}
+			if got := unattendedInstallFiles(c.unattendedFiles); !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Fatalf("files = %v, want %v", got, tt.wantFiles)
+			}
+			if !reflect.DeepEqual(c.BootCommand, unattendedBootCommands[tt.name]) {
+				t.Errorf("BootCommand = %v, want %v", c.BootCommand, unattendedBootCommands[tt.name])
+			}
+			if c.ISOInstallWait != isoInstallWaitPowerOff {
+				t.Errorf("ISOInstallWait = %q, want %q", c.ISOInstallWait, isoInstallWaitPowerOff)
+			}
+			for _, name := range tt.wantFiles {
+				golden := filepath.Join("testdata", "unattended", tt.name, name+".golden")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(c.unattendedFiles[name]), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := c.unattendedFiles[name]; got != string(want) {
+					t.Errorf("%s = %s\nwant %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnattendedInstallConfig_prepare(t *testing.T) {
+	c, _, err := NewConfig(map[string]interface{}{
+		"api_key":       "test",
+		"api_token":     "test",
+		"isoimage_uuid": "test",
+		"ssh_username":  "packer",
+		"ssh_password":  "secret",
+		"boot_command":  []string{"<enter>"},
+		"unattended_install": map[string]interface{}{
+			"family": "debian",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	u := c.UnattendedInstall
+	want := UnattendedInstallConfig{
+		Family:     "debian",
+		Locale:     "en_US.UTF-8",
+		Timezone:   "UTC",
+		DiskLayout: unattendedDiskLVM,
+		Hostname:   c.ServerName,
+		Username:   "packer",
+		Password:   "secret",
+	}
+	if !reflect.DeepEqual(*u, want) {
+		t.Errorf("UnattendedInstall = %+v, want %+v", *u, want)
+	}
+	if !reflect.DeepEqual(c.BootCommand, []string{"<enter>"}) {
+		t.Errorf("BootCommand = %v, want the configured one", c.BootCommand)
+	}
+	if !c.needsFileServer() {
+		t.Error("needsFileServer() = false, want true")
+	}
+}
+
+func Test_shellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{s: "", want: "''"},
+		{s: "a b", want: "'a b'"},
+		{s: "it's", want: `'it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.s); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
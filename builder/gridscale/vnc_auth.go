@@ -0,0 +1,205 @@
+package gridscale
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/mitchellh/go-vnc"
+)
+
+// RFB security types, see RFC 6143 section 7.1.2 and the VeNCrypt extension.
+const (
+	vncSecurityTypeNone     = 1
+	vncSecurityTypeVNC      = 2
+	vncSecurityTypeVeNCrypt = 19
+)
+
+// VeNCrypt sub-types. The anonymous TLS sub-types (257-259) are not
+// supported, because they rely on anonymous Diffie-Hellman cipher suites
+// which are not implemented by crypto/tls.
+const (
+	vencryptSubtypePlain     uint32 = 256
+	vencryptSubtypeX509None  uint32 = 260
+	vencryptSubtypeX509VNC   uint32 = 261
+	vencryptSubtypeX509Plain uint32 = 262
+)
+
+// vncConn wraps the connection the RFB handshake runs on. go-vnc keeps
+// using the very same net.Conn after the security handshake, so VeNCrypt
+// has to swap the underlying connection for a TLS one in place.
+type vncConn struct {
+	net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newVNCConn(nc net.Conn) *vncConn {
+	return &vncConn{
+		Conn:   nc,
+		closed: make(chan struct{}),
+	}
+}
+
+// Close closes the connection. go-vnc closes the connection as soon as
+// reading from the server fails, which makes it the earliest point to
+// notice a dropped connection.
+func (c *vncConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// isClosed reports whether the connection has been closed.
+func (c *vncConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// vncClientAuths returns the authentication methods offered to the VNC
+// server, in order of preference. go-vnc picks the first one that is
+// also offered by the server.
+func vncClientAuths(c *Config, tlsConfig *tls.Config) []vnc.ClientAuth {
+	auths := []vnc.ClientAuth{}
+	if c.VNCPassword != "" {
+		auths = append(auths, &vnc.PasswordAuth{Password: c.VNCPassword})
+	}
+	if c.VNCPassword != "" || c.VNCUsername != "" {
+		auths = append(auths, &vncAuthVeNCrypt{
+			Username:  c.VNCUsername,
+			Password:  c.VNCPassword,
+			TLSConfig: tlsConfig,
+		})
+	}
+	return append(auths, new(vnc.ClientAuthNone))
+}
+
+// vncAuthVeNCrypt implements the VeNCrypt security type with the Plain
+// and X509 sub-types.
+type vncAuthVeNCrypt struct {
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
+}
+
+func (a *vncAuthVeNCrypt) SecurityType() uint8 {
+	return vncSecurityTypeVeNCrypt
+}
+
+func (a *vncAuthVeNCrypt) Handshake(c net.Conn) error {
+	// Version negotiation, only 0.2 is supported
+	var version [2]uint8
+	if err := binary.Read(c, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version[0] != 0 || version[1] < 2 {
+		return fmt.Errorf("unsupported VeNCrypt version %d.%d", version[0], version[1])
+	}
+	if err := binary.Write(c, binary.BigEndian, [2]uint8{0, 2}); err != nil {
+		return err
+	}
+	var ack uint8
+	if err := binary.Read(c, binary.BigEndian, &ack); err != nil {
+		return err
+	}
+	if ack != 0 {
+		return errors.New("VeNCrypt version 0.2 was rejected by the server")
+	}
+
+	// Sub-type negotiation
+	var numSubtypes uint8
+	if err := binary.Read(c, binary.BigEndian, &numSubtypes); err != nil {
+		return err
+	}
+	subtypes := make([]uint32, numSubtypes)
+	if err := binary.Read(c, binary.BigEndian, &subtypes); err != nil {
+		return err
+	}
+	subtype, ok := a.chooseSubtype(subtypes)
+	if !ok {
+		return fmt.Errorf("no suitable VeNCrypt sub-type found. server supported: %v", subtypes)
+	}
+	if err := binary.Write(c, binary.BigEndian, subtype); err != nil {
+		return err
+	}
+
+	if subtype != vencryptSubtypePlain {
+		var accepted uint8
+		if err := binary.Read(c, binary.BigEndian, &accepted); err != nil {
+			return err
+		}
+		if accepted != 1 {
+			return fmt.Errorf("VeNCrypt sub-type %d was rejected by the server", subtype)
+		}
+		if err := a.upgradeTLS(c); err != nil {
+			return err
+		}
+	}
+
+	switch subtype {
+	case vencryptSubtypePlain, vencryptSubtypeX509Plain:
+		data := []interface{}{
+			uint32(len(a.Username)),
+			uint32(len(a.Password)),
+			[]byte(a.Username),
+			[]byte(a.Password),
+		}
+		for _, val := range data {
+			if err := binary.Write(c, binary.BigEndian, val); err != nil {
+				return err
+			}
+		}
+	case vencryptSubtypeX509VNC:
+		return (&vnc.PasswordAuth{Password: a.Password}).Handshake(c)
+	}
+	return nil
+}
+
+// chooseSubtype picks the most secure sub-type offered by the server
+// which can be used with the configured credentials.
+func (a *vncAuthVeNCrypt) chooseSubtype(offered []uint32) (uint32, bool) {
+	preferred := []uint32{}
+	if a.Username != "" {
+		preferred = append(preferred, vencryptSubtypeX509Plain)
+	}
+	if a.Password != "" {
+		preferred = append(preferred, vencryptSubtypeX509VNC)
+	}
+	preferred = append(preferred, vencryptSubtypeX509None)
+	if a.Username != "" {
+		preferred = append(preferred, vencryptSubtypePlain)
+	}
+	for _, p := range preferred {
+		for _, o := range offered {
+			if p == o {
+				return p, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// upgradeTLS runs the TLS handshake on the underlying connection and
+// swaps it in, so that the rest of the RFB session is encrypted.
+func (a *vncAuthVeNCrypt) upgradeTLS(c net.Conn) error {
+	vc, ok := c.(*vncConn)
+	if !ok {
+		return errors.New("the VNC connection cannot be upgraded to TLS")
+	}
+	tlsConfig := a.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConn := tls.Client(vc.Conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("VeNCrypt TLS handshake failed: %s", err)
+	}
+	vc.Conn = tlsConn
+	return nil
+}
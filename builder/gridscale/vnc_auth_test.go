@@ -0,0 +1,433 @@
+package gridscale
+
+import (
+	"bytes"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+)
+
+// fakeRFBServer is a minimal in-process RFB server. It runs the protocol
+// version, security and initialisation handshakes and afterwards records
+// every key press it receives. If dropAfter is set, the connection is
+// dropped after that many key events.
+type fakeRFBServer struct {
+	securityTypes    []uint8
+	vencryptSubtypes []uint32
+	username         string
+	password         string
+	tlsConfig        *tls.Config
+	keyEvents        chan uint32
+	dropAfter        int
+}
+
+// dial creates a client connection served by the fake server.
+func (s *fakeRFBServer) dial() (net.Conn, <-chan error) {
+	client, server := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := s.serve(server)
+		server.Close()
+		errCh <- err
+	}()
+	return client, errCh
+}
+
+func (s *fakeRFBServer) serve(conn net.Conn) error {
+	if _, err := conn.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return err
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint8(len(s.securityTypes))); err != nil {
+		return err
+	}
+	if err := binary.Write(conn, binary.BigEndian, s.securityTypes); err != nil {
+		return err
+	}
+	var securityType uint8
+	if err := binary.Read(conn, binary.BigEndian, &securityType); err != nil {
+		return err
+	}
+
+	var authErr error
+	switch securityType {
+	case vncSecurityTypeNone:
+	case vncSecurityTypeVNC:
+		authErr = s.checkVNCChallenge(conn)
+	case vncSecurityTypeVeNCrypt:
+		conn, authErr = s.vencrypt(conn)
+	default:
+		authErr = fmt.Errorf("unexpected security type %d", securityType)
+	}
+	if authErr != nil {
+		reason := authErr.Error()
+		data := []interface{}{uint32(1), uint32(len(reason)), []byte(reason)}
+		for _, val := range data {
+			if err := binary.Write(conn, binary.BigEndian, val); err != nil {
+				return err
+			}
+		}
+		return authErr
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+
+	// ClientInit and ServerInit
+	var shared uint8
+	if err := binary.Read(conn, binary.BigEndian, &shared); err != nil {
+		return err
+	}
+	name := "packer"
+	data := []interface{}{
+		uint16(800), uint16(600),
+		[16]byte{32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0},
+		uint32(len(name)), []byte(name),
+	}
+	for _, val := range data {
+		if err := binary.Write(conn, binary.BigEndian, val); err != nil {
+			return err
+		}
+	}
+
+	// Client messages, only key events are of interest
+	for numKeyEvents := 0; s.dropAfter == 0 || numKeyEvents < s.dropAfter; {
+		var msgType uint8
+		if err := binary.Read(conn, binary.BigEndian, &msgType); err != nil {
+			return nil
+		}
+		switch msgType {
+		case 3:
+			// FramebufferUpdateRequest
+			if _, err := io.ReadFull(conn, make([]byte, 9)); err != nil {
+				return nil
+			}
+		case 4:
+			var event struct {
+				Down    uint8
+				Padding [2]uint8
+				Key     uint32
+			}
+			if err := binary.Read(conn, binary.BigEndian, &event); err != nil {
+				return nil
+			}
+			if s.keyEvents != nil && event.Down == 1 {
+				s.keyEvents <- event.Key
+			}
+			numKeyEvents++
+		default:
+			return fmt.Errorf("unexpected client message %d", msgType)
+		}
+	}
+	return nil
+}
+
+func (s *fakeRFBServer) checkVNCChallenge(conn net.Conn) error {
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	if _, err := conn.Write(challenge); err != nil {
+		return err
+	}
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	for i := 0; i < len(s.password) && i < 8; i++ {
+		b := s.password[i]
+		var r byte
+		for j := 0; j < 8; j++ {
+			r = r<<1 | b&1
+			b >>= 1
+		}
+		key[i] = r
+	}
+	block, err := des.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	expected := make([]byte, 16)
+	block.Encrypt(expected, challenge)
+	block.Encrypt(expected[8:], challenge[8:])
+	if !bytes.Equal(expected, response) {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+func (s *fakeRFBServer) vencrypt(conn net.Conn) (net.Conn, error) {
+	if err := binary.Write(conn, binary.BigEndian, [2]uint8{0, 2}); err != nil {
+		return conn, err
+	}
+	var version [2]uint8
+	if err := binary.Read(conn, binary.BigEndian, &version); err != nil {
+		return conn, err
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint8(0)); err != nil {
+		return conn, err
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint8(len(s.vencryptSubtypes))); err != nil {
+		return conn, err
+	}
+	if err := binary.Write(conn, binary.BigEndian, s.vencryptSubtypes); err != nil {
+		return conn, err
+	}
+	var subtype uint32
+	if err := binary.Read(conn, binary.BigEndian, &subtype); err != nil {
+		return conn, err
+	}
+	if subtype != vencryptSubtypePlain {
+		if err := binary.Write(conn, binary.BigEndian, uint8(1)); err != nil {
+			return conn, err
+		}
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return conn, err
+		}
+		conn = tlsConn
+	}
+	switch subtype {
+	case vencryptSubtypePlain, vencryptSubtypeX509Plain:
+		var lengths [2]uint32
+		if err := binary.Read(conn, binary.BigEndian, &lengths); err != nil {
+			return conn, err
+		}
+		credentials := make([]byte, lengths[0]+lengths[1])
+		if _, err := io.ReadFull(conn, credentials); err != nil {
+			return conn, err
+		}
+		if string(credentials[:lengths[0]]) != s.username || string(credentials[lengths[0]:]) != s.password {
+			return conn, errors.New("authentication failed")
+		}
+	case vencryptSubtypeX509VNC:
+		return conn, s.checkVNCChallenge(conn)
+	}
+	return conn, nil
+}
+
+// produceTestTLSConfigs returns a server and a matching client TLS config
+// using a freshly generated self-signed certificate.
+func produceTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	clientConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+	}
+	return serverConfig, clientConfig
+}
+
+func Test_vncClientAuths(t *testing.T) {
+	tests := []struct {
+		name string
+		raws map[string]interface{}
+		want []uint8
+	}{
+		{
+			name: "no credentials",
+			raws: map[string]interface{}{},
+			want: []uint8{vncSecurityTypeNone},
+		},
+		{
+			name: "password",
+			raws: map[string]interface{}{
+				"vnc_password": "secret",
+			},
+			want: []uint8{vncSecurityTypeVNC, vncSecurityTypeVeNCrypt, vncSecurityTypeNone},
+		},
+		{
+			name: "username only",
+			raws: map[string]interface{}{
+				"vnc_username": "packer",
+			},
+			want: []uint8{vncSecurityTypeVeNCrypt, vncSecurityTypeNone},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auths := vncClientAuths(produceTestConfig(tt.raws), nil)
+			got := []uint8{}
+			for _, auth := range auths {
+				got = append(got, auth.SecurityType())
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("vncClientAuths() security types = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_vncAuth_Handshake(t *testing.T) {
+	serverTLSConfig, clientTLSConfig := produceTestTLSConfigs(t)
+	tests := []struct {
+		name    string
+		raws    map[string]interface{}
+		server  *fakeRFBServer
+		wantErr bool
+	}{
+		{
+			name: "no authentication",
+			raws: map[string]interface{}{},
+			server: &fakeRFBServer{
+				securityTypes: []uint8{vncSecurityTypeNone},
+			},
+			wantErr: false,
+		},
+		{
+			name: "VNC password",
+			raws: map[string]interface{}{
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes: []uint8{vncSecurityTypeVNC},
+				password:      "secret",
+			},
+			wantErr: false,
+		},
+		{
+			name: "VNC password is preferred over none",
+			raws: map[string]interface{}{
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes: []uint8{vncSecurityTypeNone, vncSecurityTypeVNC},
+				password:      "secret",
+			},
+			wantErr: false,
+		},
+		{
+			name: "wrong VNC password",
+			raws: map[string]interface{}{
+				"vnc_password": "wrong",
+			},
+			server: &fakeRFBServer{
+				securityTypes: []uint8{vncSecurityTypeVNC},
+				password:      "secret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "VNC password required but not set",
+			raws: map[string]interface{}{},
+			server: &fakeRFBServer{
+				securityTypes: []uint8{vncSecurityTypeVNC},
+				password:      "secret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "VeNCrypt plain",
+			raws: map[string]interface{}{
+				"vnc_username": "packer",
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes:    []uint8{vncSecurityTypeVeNCrypt},
+				vencryptSubtypes: []uint32{vencryptSubtypePlain},
+				username:         "packer",
+				password:         "secret",
+			},
+			wantErr: false,
+		},
+		{
+			name: "VeNCrypt X509 VNC password",
+			raws: map[string]interface{}{
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes:    []uint8{vncSecurityTypeVeNCrypt},
+				vencryptSubtypes: []uint32{vencryptSubtypePlain, vencryptSubtypeX509VNC},
+				password:         "secret",
+				tlsConfig:        serverTLSConfig,
+			},
+			wantErr: false,
+		},
+		{
+			name: "VeNCrypt X509 plain",
+			raws: map[string]interface{}{
+				"vnc_username": "packer",
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes:    []uint8{vncSecurityTypeVeNCrypt},
+				vencryptSubtypes: []uint32{vencryptSubtypePlain, vencryptSubtypeX509Plain},
+				username:         "packer",
+				password:         "secret",
+				tlsConfig:        serverTLSConfig,
+			},
+			wantErr: false,
+		},
+		{
+			name: "VeNCrypt without suitable sub-type",
+			raws: map[string]interface{}{
+				"vnc_password": "secret",
+			},
+			server: &fakeRFBServer{
+				securityTypes:    []uint8{vncSecurityTypeVeNCrypt},
+				vencryptSubtypes: []uint32{257, 258},
+				password:         "secret",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, _ := tt.server.dial()
+			conn, err := vnc.Client(newVNCConn(nc), &vnc.ClientConfig{
+				Auth: vncClientAuths(produceTestConfig(tt.raws), clientTLSConfig),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("vnc.Client() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if conn.DesktopName != "packer" {
+					t.Errorf("DesktopName = %v, want packer", conn.DesktopName)
+				}
+				conn.Close()
+			}
+		})
+	}
+}
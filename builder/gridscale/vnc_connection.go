@@ -0,0 +1,215 @@
+package gridscale
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/mitchellh/go-vnc"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	consoleHost                = "api.gridscale.io"
+	vncReconnectAttempts       = 5
+	vncReconnectInitialBackoff = 2 * time.Second
+	vncReconnectMaxBackoff     = 30 * time.Second
+)
+
+// vncConnection is a VNC connection to the console of the build server.
+// It implements bootcommand.VNCKeyEvent and transparently reconnects, with
+// a freshly fetched console token, when the connection has been dropped.
+// The key event that failed is repeated on the new connection, so a boot
+// command sequence resumes at the key where it stopped.
+type vncConnection struct {
+	// ctx is the context of the build, it stops reconnecting
+	ctx        context.Context
+	client     gsclient.ServerOperator
+	config     *Config
+	serverUUID string
+	ui         packer.Ui
+	// dial opens the transport to the VNC server of the console
+	// identified by the given console token.
+	dial    func(consoleToken string) (net.Conn, error)
+	backoff time.Duration
+
+	conn *vnc.ClientConn
+	nc   *vncConn
+	// modifiers holds the modifier keys that are currently pressed, they
+	// are pressed again after reconnecting.
+	modifiers map[uint32]bool
+}
+
+func newVNCConnection(ctx context.Context, client gsclient.ServerOperator, config *Config, serverUUID string, ui packer.Ui) *vncConnection {
+	return &vncConnection{
+		ctx:        ctx,
+		client:     client,
+		config:     config,
+		serverUUID: serverUUID,
+		ui:         ui,
+		dial:       dialConsoleWebsocket,
+		backoff:    vncReconnectInitialBackoff,
+		modifiers:  make(map[uint32]bool),
+	}
+}
+
+// dialConsoleWebsocket opens the websocket to the gridscale console.
+func dialConsoleWebsocket(consoleToken string) (net.Conn, error) {
+	websocketUrl := fmt.Sprintf("wss://%s/console/?token=%s", consoleHost, consoleToken)
+	u, err := url.Parse(websocketUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing websocket url: %s", err)
+	}
+	// Maybe for CORS
+	origin, err := url.Parse("http://localhost")
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing websocket origin url: %s", err)
+	}
+
+	// Create the websocket connection and set it to a BinaryFrame
+	websocketConfig := &websocket.Config{
+		Location: u,
+		Origin:   origin,
+		// Not sure about TLS things
+		TlsConfig: &tls.Config{InsecureSkipVerify: false},
+		Version:   websocket.ProtocolVersionHybi13,
+		Protocol:  []string{"binary"},
+	}
+	nc, err := websocket.DialConfig(websocketConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error Dialing: %s", err)
+	}
+	nc.PayloadType = websocket.BinaryFrame
+	return nc, nil
+}
+
+// connect fetches a fresh console token of the server and sets up the
+// VNC connection over the console websocket.
+func (v *vncConnection) connect() error {
+	server, err := v.client.GetServer(context.Background(), v.serverUUID)
+	if err != nil {
+		return fmt.Errorf("Error getting server's VNC console token: %s", err)
+	}
+	nc, err := v.dial(server.Properties.ConsoleToken)
+	if err != nil {
+		return err
+	}
+	// Setup the VNC connection over the websocket, offering every
+	// authentication method usable with the configured credentials
+	ccconfig := &vnc.ClientConfig{
+		Auth:      vncClientAuths(v.config, &tls.Config{ServerName: consoleHost}),
+		Exclusive: false,
+	}
+	v.nc = newVNCConn(nc)
+	conn, err := vnc.Client(v.nc, ccconfig)
+	if err != nil {
+		return fmt.Errorf("Error setting the VNC over websocket client: %s", err)
+	}
+	v.conn = conn
+	return nil
+}
+
+// reconnect drops the current connection and connects again, backing
+// off between the attempts. It gives up when the build is cancelled.
+func (v *vncConnection) reconnect() error {
+	v.Close()
+	backoff := v.backoff
+	var err error
+	for attempt := 1; attempt <= vncReconnectAttempts; attempt++ {
+		v.ui.Say(fmt.Sprintf("Reconnecting to VNC server (attempt %d/%d)...", attempt, vncReconnectAttempts))
+		if err = v.connect(); err == nil {
+			break
+		}
+		log.Printf("[DEBUG] reconnecting to VNC server failed: %s", err)
+		if attempt < vncReconnectAttempts {
+			select {
+			case <-v.ctx.Done():
+				return fmt.Errorf("Error reconnecting to VNC server: %s", v.ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > vncReconnectMaxBackoff {
+				backoff = vncReconnectMaxBackoff
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Error reconnecting to VNC server: %s", err)
+	}
+	// Restore the state of the modifier keys in the new session
+	for keysym := range v.modifiers {
+		if err := v.conn.KeyEvent(keysym, true); err != nil {
+			return err
+		}
+	}
+	v.ui.Say("VNC reconnected")
+	return nil
+}
+
+// ensureConnected checks that the connection is still alive and
+// reconnects otherwise. Nothing is typed while waiting for the server to
+// boot, so this is the earliest point to notice a dropped connection.
+func (v *vncConnection) ensureConnected() error {
+	if v.conn != nil && !v.nc.isClosed() {
+		err := v.conn.FramebufferUpdateRequest(true, 0, 0, 1, 1)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[DEBUG] VNC connection is not alive: %s", err)
+	}
+	v.ui.Say("VNC connection was lost")
+	return v.reconnect()
+}
+
+// KeyEvent sends a key event, reconnecting once if the connection has
+// been dropped.
+func (v *vncConnection) KeyEvent(keysym uint32, down bool) error {
+	if v.conn == nil {
+		return errors.New("VNC connection is not established")
+	}
+	err := errors.New("VNC connection is closed")
+	if !v.nc.isClosed() {
+		err = v.conn.KeyEvent(keysym, down)
+	}
+	if err != nil {
+		log.Printf("[DEBUG] sending VNC key event failed: %s", err)
+		v.ui.Say("VNC connection was lost while typing the boot command")
+		if err := v.reconnect(); err != nil {
+			return err
+		}
+		if err := v.conn.KeyEvent(keysym, down); err != nil {
+			return err
+		}
+	}
+	if isModifierKeysym(keysym) {
+		if down {
+			v.modifiers[keysym] = true
+		} else {
+			delete(v.modifiers, keysym)
+		}
+	}
+	return nil
+}
+
+// Close closes the current connection.
+func (v *vncConnection) Close() error {
+	if v.conn == nil {
+		return nil
+	}
+	err := v.conn.Close()
+	v.conn = nil
+	return err
+}
+
+// isModifierKeysym reports whether the keysym is a shift, control, alt,
+// super or AltGr key.
+func isModifierKeysym(keysym uint32) bool {
+	return (keysym >= 0xFFE1 && keysym <= 0xFFEE) || keysym == 0xFE03
+}
@@ -0,0 +1,197 @@
+package gridscale
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// produceTestVNCConnection returns a vncConnection dialing fake RFB servers.
+// The n-th connection is dropped after dropAfter[n] key events, dialing
+// fails once all of them have been used.
+func produceTestVNCConnection(t *testing.T, dropAfter []int, keyEvents chan uint32) (*vncConnection, *int) {
+	dials := 0
+	conn := newVNCConnection(context.Background(), ServerOperatorMock{}, produceTestConfig(map[string]interface{}{}), "GetSuccess", &uiMock{})
+	conn.backoff = time.Millisecond
+	conn.dial = func(consoleToken string) (net.Conn, error) {
+		if consoleToken != "token" {
+			t.Errorf("dial() consoleToken = %v, want token", consoleToken)
+		}
+		if dials >= len(dropAfter) {
+			return nil, errors.New("error")
+		}
+		server := &fakeRFBServer{
+			securityTypes: []uint8{vncSecurityTypeNone},
+			keyEvents:     keyEvents,
+			dropAfter:     dropAfter[dials],
+		}
+		dials++
+		nc, _ := server.dial()
+		return nc, nil
+	}
+	return conn, &dials
+}
+
+func Test_vncConnection_KeyEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		dropAfter []int
+		command   string
+		want      []uint32
+		wantDials int
+		wantErr   bool
+	}{
+		{
+			name:      "no connection drop",
+			dropAfter: []int{0},
+			command:   "abc",
+			want:      []uint32{'a', 'b', 'c'},
+			wantDials: 1,
+			wantErr:   false,
+		},
+		{
+			name:      "resume at the key where the connection dropped",
+			dropAfter: []int{3, 0},
+			command:   "abc",
+			want:      []uint32{'a', 'b', 'c'},
+			wantDials: 2,
+			wantErr:   false,
+		},
+		{
+			name:      "modifiers are pressed again after reconnecting",
+			dropAfter: []int{1, 0},
+			command:   "A",
+			want:      []uint32{bootcommand.KeyLeftShift, bootcommand.KeyLeftShift, 'A'},
+			wantDials: 2,
+			wantErr:   false,
+		},
+		{
+			name:      "several connection drops",
+			dropAfter: []int{2, 2, 0},
+			command:   "abc",
+			want:      []uint32{'a', 'b', 'c'},
+			wantDials: 3,
+			wantErr:   false,
+		},
+		{
+			name:      "reconnecting fails",
+			dropAfter: []int{2},
+			command:   "abc",
+			want:      []uint32{'a'},
+			wantDials: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyEvents := make(chan uint32, 32)
+			conn, dials := produceTestVNCConnection(t, tt.dropAfter, keyEvents)
+			if err := conn.connect(); err != nil {
+				t.Fatalf("connect() error = %v", err)
+			}
+			defer conn.Close()
+			seq, err := bootcommand.GenerateExpressionSequence(tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = seq.Do(context.Background(), bootcommand.NewVNCDriver(conn, time.Millisecond))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			conn.Close()
+			got := []uint32{}
+			for len(got) < len(tt.want) {
+				select {
+				case key := <-keyEvents:
+					got = append(got, key)
+				case <-time.After(time.Second):
+					t.Fatalf("key events = %v, want %v", got, tt.want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("key events = %v, want %v", got, tt.want)
+			}
+			if *dials != tt.wantDials {
+				t.Errorf("dials = %v, want %v", *dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func Test_vncConnection_ensureConnected(t *testing.T) {
+	tests := []struct {
+		name      string
+		dropAfter []int
+		drop      bool
+		wantDials int
+		wantErr   bool
+	}{
+		{
+			name:      "connection alive",
+			dropAfter: []int{0},
+			drop:      false,
+			wantDials: 1,
+			wantErr:   false,
+		},
+		{
+			name:      "connection dropped",
+			dropAfter: []int{0, 0},
+			drop:      true,
+			wantDials: 2,
+			wantErr:   false,
+		},
+		{
+			name:      "reconnecting fails",
+			dropAfter: []int{0},
+			drop:      true,
+			wantDials: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, dials := produceTestVNCConnection(t, tt.dropAfter, nil)
+			if err := conn.connect(); err != nil {
+				t.Fatalf("connect() error = %v", err)
+			}
+			defer conn.Close()
+			if tt.drop {
+				// This is what go-vnc does once reading from the server fails
+				conn.nc.Close()
+			}
+			if err := conn.ensureConnected(); (err != nil) != tt.wantErr {
+				t.Errorf("ensureConnected() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if *dials != tt.wantDials {
+				t.Errorf("dials = %v, want %v", *dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func Test_vncConnection_reconnectCancelled(t *testing.T) {
+	conn, dials := produceTestVNCConnection(t, []int{0}, nil)
+	if err := conn.connect(); err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn.ctx = ctx
+	// The back-off would outlast the test, the cancelled build stops it
+	conn.backoff = time.Hour
+	conn.nc.Close()
+	err := conn.ensureConnected()
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("ensureConnected() error = %v, want %v", err, context.Canceled)
+	}
+	if *dials != 1 {
+		t.Errorf("dials = %v, want 1", *dials)
+	}
+}
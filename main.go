@@ -5,6 +5,8 @@ import (
 	"os"
 
 	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	"github.com/gridscale/packer-plugin-gridscale/post-processor/download"
+	"github.com/gridscale/packer-plugin-gridscale/post-processor/templatecopy"
 	"github.com/gridscale/packer-plugin-gridscale/version"
 	"github.com/hashicorp/packer-plugin-sdk/plugin"
 )
@@ -12,6 +14,8 @@ import (
 func main() {
 	pps := plugin.NewSet()
 	pps.RegisterBuilder(plugin.DEFAULT_NAME, new(gridscale.Builder))
+	pps.RegisterPostProcessor("template-copy", new(templatecopy.PostProcessor))
+	pps.RegisterPostProcessor("download", new(download.PostProcessor))
 	pps.SetVersion(version.PluginVersion)
 	err := pps.Run()
 	if err != nil {
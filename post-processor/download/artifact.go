@@ -0,0 +1,43 @@
+package download
+
+import (
+	"fmt"
+	"os"
+)
+
+type Artifact struct {
+	// The path of the downloaded image
+	Path string
+
+	// The path of the SHA-256 checksum file of the image
+	ChecksumPath string
+}
+
+func (*Artifact) BuilderId() string {
+	return BuilderId
+}
+
+func (a *Artifact) Files() []string {
+	return []string{a.Path, a.ChecksumPath}
+}
+
+func (a *Artifact) Id() string {
+	return a.Path
+}
+
+func (a *Artifact) String() string {
+	return fmt.Sprintf("The image was downloaded: %s (checksum: %s)", a.Path, a.ChecksumPath)
+}
+
+func (a *Artifact) State(name string) interface{} {
+	return nil
+}
+
+func (a *Artifact) Destroy() error {
+	for _, path := range a.Files() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifact_Destroy(t *testing.T) {
+	dir := t.TempDir()
+	a := &Artifact{
+		Path:         filepath.Join(dir, "image.raw"),
+		ChecksumPath: filepath.Join(dir, "image.raw.sha256"),
+	}
+	if got := a.BuilderId(); got != BuilderId {
+		t.Errorf("BuilderId() = %v, want %v", got, BuilderId)
+	}
+	if got := a.Id(); got != a.Path {
+		t.Errorf("Id() = %v, want %v", got, a.Path)
+	}
+	for _, path := range a.Files() {
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("Destroy() error = %v", err)
+	}
+	for _, path := range a.Files() {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is kept", path)
+		}
+	}
+	// Destroying twice is fine
+	if err := a.Destroy(); err != nil {
+		t.Errorf("Destroy() error = %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// convert converts the gzipped image download to format, as output. The
+// image is a qcow2 image as exported by the API, or a raw image.
+func convert(format, download, output string) error {
+	if format == formatGzip {
+		return os.Rename(download, output)
+	}
+	raw := output
+	if format == formatVMDK {
+		raw = output + ".raw"
+		defer os.Remove(raw)
+	}
+	if err := gunzipRaw(download, raw); err != nil {
+		return err
+	}
+	if format == formatVMDK {
+		return writeVMDK(raw, output)
+	}
+	return nil
+}
+
+// gunzipRaw decompresses the image src and converts it to the raw image dst.
+func gunzipRaw(src, dst string) error {
+	image := dst + ".image"
+	defer os.Remove(image)
+	if err := gunzip(src, image); err != nil {
+		return err
+	}
+	qcow2, err := isQCOW2(image)
+	if err != nil {
+		return err
+	}
+	if !qcow2 {
+		return os.Rename(image, dst)
+	}
+	return qcow2ToRaw(image, dst)
+}
+
+// gunzip decompresses src to dst.
+func gunzip(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, bufio.NewReader(gz)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeChecksumFile writes the SHA-256 checksum of the file to
+// `<path>.sha256`, in the format of sha256sum. It returns the path of the
+// checksum file and the checksum.
+func writeChecksumFile(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	checksumPath := path + ".sha256"
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err := os.WriteFile(checksumPath, []byte(content), 0644); err != nil {
+		return "", "", err
+	}
+	return checksumPath, sum, nil
+}
+
+const (
+	vmdkSectorSize = 512
+	// vmdkGrainSectors is the size of the grains, the allocation unit of
+	// sparse extents.
+	vmdkGrainSectors = 128
+	vmdkGrainSize    = vmdkGrainSectors * vmdkSectorSize
+	// vmdkGTEs is the number of entries of a grain table.
+	vmdkGTEs = 512
+	// vmdkDescriptorSectors is the space reserved for the embedded
+	// descriptor.
+	vmdkDescriptorSectors = 20
+)
+
+// vmdkHeader is the header of a hosted sparse extent, see the Virtual Disk
+// Format 5.0 specification of VMware.
+type vmdkHeader struct {
+	MagicNumber        uint32
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64
+	GrainSize          uint64
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RGDOffset          uint64
+	GDOffset           uint64
+	OverHead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  uint8
+	NonEndLineChar     uint8
+	DoubleEndLineChar1 uint8
+	DoubleEndLineChar2 uint8
+	CompressAlgorithm  uint16
+	Pad                [433]uint8
+}
+
+// vmdkMagic is "KDMV" in little endian.
+const vmdkMagic = 0x564d444b
+
+// writeVMDK converts the raw image to a monolithic sparse VMDK image. Grains
+// of zeros are not allocated.
+func writeVMDK(raw, output string) error {
+	in, err := os.Open(raw)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	capacity := uint64(info.Size()+vmdkSectorSize-1) / vmdkSectorSize
+	numGrains := (capacity + vmdkGrainSectors - 1) / vmdkGrainSectors
+	numGTs := (numGrains + vmdkGTEs - 1) / vmdkGTEs
+	gdOffset := uint64(1 + vmdkDescriptorSectors)
+	gdSectors := (numGTs*4 + vmdkSectorSize - 1) / vmdkSectorSize
+	gtOffset := gdOffset + gdSectors
+	// The grains start at a grain boundary
+	overHead := (gtOffset + numGTs*vmdkGTEs*4/vmdkSectorSize + vmdkGrainSectors - 1) / vmdkGrainSectors * vmdkGrainSectors
+
+	header := vmdkHeader{
+		MagicNumber:        vmdkMagic,
+		Version:            1,
+		Flags:              1, // valid new line detection test
+		Capacity:           capacity,
+		GrainSize:          vmdkGrainSectors,
+		DescriptorOffset:   1,
+		DescriptorSize:     vmdkDescriptorSectors,
+		NumGTEsPerGT:       vmdkGTEs,
+		GDOffset:           gdOffset,
+		OverHead:           overHead,
+		SingleEndLineChar:  '\n',
+		NonEndLineChar:     ' ',
+		DoubleEndLineChar1: '\r',
+		DoubleEndLineChar2: '\n',
+	}
+	descriptor := vmdkDescriptor(capacity, filepath.Base(output))
+	if len(descriptor) > vmdkDescriptorSectors*vmdkSectorSize {
+		return errors.New("the VMDK descriptor is too long")
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := binary.Write(out, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if _, err := out.WriteAt([]byte(descriptor), vmdkSectorSize); err != nil {
+		return err
+	}
+
+	// Write the allocated grains after the metadata, the grain tables are
+	// written when all grains are known
+	gt := make([]uint32, numGTs*vmdkGTEs)
+	grain := make([]byte, vmdkGrainSize)
+	zeros := make([]byte, vmdkGrainSize)
+	next := overHead
+	for i := uint64(0); i < numGrains; i++ {
+		n, err := io.ReadFull(in, grain)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// The last grain is padded with zeros
+		copy(grain[n:], zeros)
+		if bytes.Equal(grain, zeros) {
+			continue
+		}
+		if _, err := out.WriteAt(grain, int64(next*vmdkSectorSize)); err != nil {
+			return err
+		}
+		gt[i] = uint32(next)
+		next += vmdkGrainSectors
+	}
+
+	gd := make([]uint32, numGTs)
+	for i := range gd {
+		gd[i] = uint32(gtOffset + uint64(i)*vmdkGTEs*4/vmdkSectorSize)
+	}
+	metadata := new(bytes.Buffer)
+	if err := binary.Write(metadata, binary.LittleEndian, gd); err != nil {
+		return err
+	}
+	metadata.Write(make([]byte, gtOffset*vmdkSectorSize-gdOffset*vmdkSectorSize-uint64(metadata.Len())))
+	if err := binary.Write(metadata, binary.LittleEndian, gt); err != nil {
+		return err
+	}
+	if _, err := out.WriteAt(metadata.Bytes(), int64(gdOffset*vmdkSectorSize)); err != nil {
+		return err
+	}
+	// The file ends after the last grain, even if it is not allocated
+	if err := out.Truncate(int64(next * vmdkSectorSize)); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// vmdkDescriptor returns the embedded descriptor of a monolithic sparse
+// image of capacity sectors.
+func vmdkDescriptor(capacity uint64, name string) string {
+	cylinders := capacity / (16 * 63)
+	if cylinders > 16383 {
+		cylinders = 16383
+	}
+	return fmt.Sprintf(`# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="monolithicSparse"
+
+# Extent description
+RW %d SPARSE "%s"
+
+# The Disk Data Base
+#DDB
+
+ddb.virtualHWVersion = "4"
+ddb.geometry.cylinders = "%d"
+ddb.geometry.heads = "16"
+ddb.geometry.sectors = "63"
+ddb.adapterType = "ide"
+`, capacity, name, cylinders)
+}
@@ -0,0 +1,150 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readVMDK returns the raw image of the monolithic sparse VMDK image and the
+// number of allocated grains.
+func readVMDK(t *testing.T, path string) ([]byte, int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header vmdkHeader
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.MagicNumber != vmdkMagic || header.GrainSize != vmdkGrainSectors || header.OverHead%vmdkGrainSectors != 0 {
+		t.Fatalf("header = %+v, want a monolithic sparse header", header)
+	}
+	descriptor := string(data[header.DescriptorOffset*vmdkSectorSize : (header.DescriptorOffset+header.DescriptorSize)*vmdkSectorSize])
+	if !strings.Contains(descriptor, `createType="monolithicSparse"`) {
+		t.Errorf("descriptor = %q, want a monolithic sparse descriptor", descriptor)
+	}
+	raw := make([]byte, header.Capacity*vmdkSectorSize)
+	numGrains := (header.Capacity + vmdkGrainSectors - 1) / vmdkGrainSectors
+	allocated := 0
+	for i := uint64(0); i < numGrains; i++ {
+		gdEntry := header.GDOffset*vmdkSectorSize + i/vmdkGTEs*4
+		gtOffset := uint64(binary.LittleEndian.Uint32(data[gdEntry:]))
+		gtEntry := gtOffset*vmdkSectorSize + i%vmdkGTEs*4
+		grainOffset := uint64(binary.LittleEndian.Uint32(data[gtEntry:])) * vmdkSectorSize
+		if grainOffset == 0 {
+			continue
+		}
+		allocated++
+		copy(raw[i*vmdkGrainSize:], data[grainOffset:grainOffset+vmdkGrainSize])
+	}
+	return raw, allocated
+}
+
+func TestWriteVMDK(t *testing.T) {
+	dir := t.TempDir()
+	// Three grains of data and the zeros in between, over two grain tables
+	raw := make([]byte, (vmdkGTEs+10)*vmdkGrainSize+1000)
+	copy(raw, "boot sector")
+	copy(raw[5*vmdkGrainSize+7:], "data")
+	copy(raw[len(raw)-4:], "last")
+	rawPath := filepath.Join(dir, "image.raw")
+	if err := os.WriteFile(rawPath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	vmdkPath := filepath.Join(dir, "image.vmdk")
+	if err := writeVMDK(rawPath, vmdkPath); err != nil {
+		t.Fatalf("writeVMDK() error = %v", err)
+	}
+	got, allocated := readVMDK(t, vmdkPath)
+	// The capacity is rounded up to sectors
+	want := append(raw, make([]byte, 24)...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("the VMDK image differs from the raw image")
+	}
+	if allocated != 3 {
+		t.Errorf("allocated grains = %d, want 3", allocated)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		image   []byte
+		wantErr bool
+	}{
+		{name: "gzip", format: formatGzip, image: []byte("raw image")},
+		{name: "raw", format: formatRaw, image: []byte("raw image")},
+		{name: "vmdk", format: formatVMDK, image: []byte("raw image")},
+		{name: "qcow2 to raw", format: formatRaw, image: testQCOW2Image(t)},
+		{name: "qcow2 to vmdk", format: formatVMDK, image: testQCOW2Image(t)},
+		{name: "broken qcow2", format: formatRaw, image: []byte("QFI\xfb qcow2 image"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			gz.Write(tt.image)
+			gz.Close()
+			download := filepath.Join(dir, "download")
+			if err := os.WriteFile(download, compressed.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "output")
+			err := convert(tt.format, download, output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got []byte
+			switch tt.format {
+			case formatGzip:
+				got, _ = os.ReadFile(output)
+				if !bytes.Equal(got, compressed.Bytes()) {
+					t.Errorf("output = %q, want the download", got)
+				}
+				return
+			case formatRaw:
+				got, _ = os.ReadFile(output)
+			case formatVMDK:
+				got, _ = readVMDK(t, output)
+				got = bytes.TrimRight(got, "\x00")
+			}
+			want := tt.image
+			if bytes.HasPrefix(want, qcow2Magic) {
+				want = []byte("raw image")
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_writeChecksumFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.raw")
+	if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksumPath, sum, err := writeChecksumFile(path)
+	if err != nil {
+		t.Fatalf("writeChecksumFile() error = %v", err)
+	}
+	// sha256sum of "image"
+	want := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	if sum != want {
+		t.Errorf("writeChecksumFile() sum = %v, want %v", sum, want)
+	}
+	content, _ := os.ReadFile(checksumPath)
+	if string(content) != want+"  image.raw\n" {
+		t.Errorf("checksum file = %q, want %q", content, want+"  image.raw\n")
+	}
+}
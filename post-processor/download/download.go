@@ -0,0 +1,274 @@
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const (
+	// defaultChunkSize is the size of the ranged requests of a download.
+	defaultChunkSize = 64 << 20
+	// downloadRetries is how often a ranged request is retried before the
+	// download fails.
+	downloadRetries = 5
+)
+
+// errChecksumMismatch is returned when the download does not match the
+// object.
+var errChecksumMismatch = errors.New("the checksum of the download does not match")
+
+// downloader downloads an object in ranged requests. A failed request is
+// retried from the current offset, and a failed download is resumed by the
+// next download of the same object.
+type downloader struct {
+	client *s3.S3
+	bucket string
+	key    string
+	// The size of the ranged requests. Default: 64 MiB.
+	chunkSize int64
+	// The delay before retrying a failed request. It grows with each retry.
+	retryDelay time.Duration
+}
+
+// download downloads the object to path and verifies it against the ETag of
+// the object. The partial download is kept in `<path>.part`.
+func (d *downloader) download(ctx context.Context, ui packersdk.Ui, path string) error {
+	head, err := d.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.key),
+	})
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", d.key, err)
+	}
+	size := aws.Int64Value(head.ContentLength)
+	etag := aws.StringValue(head.ETag)
+
+	// The ETag of a partial download tells whether it is a download of the
+	// same object
+	partial := path + ".part"
+	etagPath := partial + ".etag"
+	var offset int64
+	if previous, err := os.ReadFile(etagPath); err == nil && string(previous) == etag {
+		if info, err := os.Stat(partial); err == nil && info.Size() <= size {
+			offset = info.Size()
+		}
+	}
+	if err := os.WriteFile(etagPath, []byte(etag), 0644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if offset > 0 {
+		ui.Say(fmt.Sprintf("Resuming the download at %d of %d bytes", offset, size))
+	}
+
+	body := ui.TrackProgress(d.key, offset, size, &rangeReader{
+		ctx:    ctx,
+		d:      d,
+		etag:   etag,
+		offset: offset,
+		size:   size,
+	})
+	_, err = io.Copy(f, body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := d.verify(ctx, partial, etag); err != nil {
+		if errors.Is(err, errChecksumMismatch) {
+			// Do not resume a corrupt download
+			os.Remove(partial)
+			os.Remove(etagPath)
+		}
+		return err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return err
+	}
+	return os.Remove(etagPath)
+}
+
+// verify checks the download against the ETag of the object. The ETag of an
+// object uploaded in one part is its MD5 checksum, the ETag of a multipart
+// upload is the MD5 checksum of the MD5 checksums of the parts, followed by
+// the number of parts.
+func (d *downloader) verify(ctx context.Context, path, etag string) error {
+	etag = strings.Trim(etag, `"`)
+	var partSize int64
+	if strings.Contains(etag, "-") {
+		part, err := d.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket:     aws.String(d.bucket),
+			Key:        aws.String(d.key),
+			PartNumber: aws.Int64(1),
+		})
+		if err != nil {
+			return fmt.Errorf("reading the part size of %s: %s", d.key, err)
+		}
+		if part.PartsCount == nil {
+			return fmt.Errorf("cannot verify %s, the object storage does not report the part size", d.key)
+		}
+		partSize = aws.Int64Value(part.ContentLength)
+	}
+	got, err := fileETag(path, partSize)
+	if err != nil {
+		return err
+	}
+	if got != etag {
+		return fmt.Errorf("%w: %s is %s, the ETag of %s is %s", errChecksumMismatch, path, got, d.key, etag)
+	}
+	return nil
+}
+
+// fileETag returns the ETag of the file uploaded in parts of partSize, or in
+// one part if partSize is 0.
+func fileETag(path string, partSize int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if partSize == 0 {
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	var sums []byte
+	parts := 0
+	for {
+		h := md5.New()
+		n, err := io.CopyN(h, f, partSize)
+		if n > 0 {
+			sums = h.Sum(sums)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts), nil
+}
+
+// rangeReader reads the object from offset to size in ranged requests. The
+// requests only succeed while the object has the ETag etag.
+type rangeReader struct {
+	ctx    context.Context
+	d      *downloader
+	etag   string
+	offset int64
+	size   int64
+
+	body    io.ReadCloser
+	retries int
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for r.offset < r.size {
+		if r.body == nil {
+			chunkSize := r.d.chunkSize
+			if chunkSize == 0 {
+				chunkSize = defaultChunkSize
+			}
+			end := r.offset + chunkSize - 1
+			if end >= r.size {
+				end = r.size - 1
+			}
+			out, err := r.d.client.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+				Bucket:  aws.String(r.d.bucket),
+				Key:     aws.String(r.d.key),
+				Range:   aws.String(fmt.Sprintf("bytes=%d-%d", r.offset, end)),
+				IfMatch: aws.String(r.etag),
+			})
+			if err != nil {
+				var reqErr awserr.RequestFailure
+				if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusPreconditionFailed {
+					return 0, fmt.Errorf("%s changed during the download", r.d.key)
+				}
+				if err := r.retry(err); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			r.body = out.Body
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.retries = 0
+		}
+		if err != nil {
+			// The next read requests the rest of the object
+			r.body.Close()
+			r.body = nil
+			if err != io.EOF && n == 0 {
+				if err := r.retry(err); err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err == io.EOF && r.offset < r.size {
+			// An empty response would be requested again forever
+			if err := r.retry(io.ErrUnexpectedEOF); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, io.EOF
+}
+
+// retry waits before the next request, or returns err if the request has
+// been retried too often.
+func (r *rangeReader) retry(err error) error {
+	r.retries++
+	if r.retries > downloadRetries {
+		return fmt.Errorf("reading %s at %d: %s", r.d.key, r.offset, err)
+	}
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-time.After(time.Duration(r.retries) * r.d.retryDelay):
+		return nil
+	}
+}
+
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,258 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// s3StandIn is a local stand-in of an S3-compatible object storage, with the
+// path-style objects by path, e.g. `/bucket/key`.
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// partSize makes the ETags the ETags of multipart uploads
+	partSize int
+	// etag replaces the ETags of the objects
+	etag string
+	// failGets is the number of GETs to break off halfway
+	failGets int
+	// ranges are the ranges of the GETs
+	ranges []string
+}
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *httptest.Server) {
+	s := &s3StandIn{objects: map[string][]byte{}}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *s3StandIn) etagOf(content []byte) string {
+	if s.etag != "" {
+		return s.etag
+	}
+	if s.partSize == 0 {
+		sum := md5.Sum(content)
+		return hex.EncodeToString(sum[:])
+	}
+	var sums []byte
+	parts := 0
+	for i := 0; i < len(content); i += s.partSize {
+		end := i + s.partSize
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := md5.Sum(content[i:end])
+		sums = append(sums, sum[:]...)
+		parts++
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts)
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		s.objects[r.URL.Path] = content
+		return
+	case http.MethodDelete:
+		// Like S3, removing a missing object succeeds
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	content, ok := s.objects[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	etag := `"` + s.etagOf(content) + `"`
+	w.Header().Set("ETag", etag)
+	switch r.Method {
+	case http.MethodHead:
+		size := len(content)
+		if part := r.URL.Query().Get("partNumber"); part != "" && s.partSize > 0 {
+			n, _ := strconv.Atoi(part)
+			size = s.partSize
+			if rest := len(content) - (n-1)*s.partSize; rest < size {
+				size = rest
+			}
+			w.Header().Set("x-amz-mp-parts-count", strconv.Itoa((len(content)+s.partSize-1)/s.partSize))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+	case http.MethodGet:
+		if match := r.Header.Get("If-Match"); match != "" && match != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			start, end = 0, len(content)-1
+		}
+		body := content[start : end+1]
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		if s.failGets > 0 {
+			s.failGets--
+			body = body[:len(body)/2]
+		}
+		_, _ = w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// testObject returns an object of size bytes.
+func testObject(size int) []byte {
+	return bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+}
+
+func TestDownloader_download(t *testing.T) {
+	object := testObject(95)
+	tests := []struct {
+		name string
+		// partial is the partial download of a previous download
+		partial     []byte
+		partialETag string
+		partSize    int
+		etag        string
+		failGets    int
+		wantRanges  []string
+		wantErr     error
+		// wantPartial reports whether the partial download is kept
+		wantPartial bool
+	}{
+		{
+			name:       "success",
+			wantRanges: []string{"bytes=0-39", "bytes=40-79", "bytes=80-94"},
+		},
+		{
+			name:        "resume",
+			partial:     object[:30],
+			partialETag: `"` + (&s3StandIn{}).etagOf(object) + `"`,
+			wantRanges:  []string{"bytes=30-69", "bytes=70-94"},
+		},
+		{
+			name:        "partial download of another object",
+			partial:     []byte("other"),
+			partialETag: `"other"`,
+			wantRanges:  []string{"bytes=0-39", "bytes=40-79", "bytes=80-94"},
+		},
+		{
+			name:       "broken off request",
+			failGets:   1,
+			wantRanges: []string{"bytes=0-39", "bytes=20-59", "bytes=60-94"},
+		},
+		{
+			name:       "multipart upload",
+			partSize:   50,
+			wantRanges: []string{"bytes=0-39", "bytes=40-79", "bytes=80-94"},
+		},
+		{
+			name:       "checksum mismatch",
+			etag:       "0123456789abcdef0123456789abcdef",
+			wantRanges: []string{"bytes=0-39", "bytes=40-79", "bytes=80-94"},
+			wantErr:    errChecksumMismatch,
+		},
+		{
+			name:        "too many broken off requests",
+			failGets:    100,
+			wantErr:     errors.New("reading"),
+			wantPartial: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newS3StandIn(t)
+			s.objects["/staging/image.gz"] = object
+			s.partSize = tt.partSize
+			s.etag = tt.etag
+			s.failGets = tt.failGets
+			client, err := gridscale.NewS3Client(gridscale.S3Config{
+				Endpoint:  srv.URL,
+				Region:    "us-east-1",
+				Bucket:    "staging",
+				AccessKey: "access",
+				SecretKey: "secret",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "image.gz")
+			if tt.partial != nil {
+				if err := os.WriteFile(path+".part", tt.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path+".part.etag", []byte(tt.partialETag), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := &downloader{client: client, bucket: "staging", key: "image.gz", chunkSize: 40}
+			err = d.download(context.Background(), packersdk.TestUi(t), path)
+			if tt.wantRanges != nil && !reflect.DeepEqual(s.ranges, tt.wantRanges) {
+				t.Errorf("ranges = %v, want %v", s.ranges, tt.wantRanges)
+			}
+			if tt.wantErr != nil {
+				if err == nil || !errors.Is(err, tt.wantErr) && !strings.HasPrefix(err.Error(), tt.wantErr.Error()) {
+					t.Fatalf("download() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, err := os.Stat(path + ".part"); (err == nil) != tt.wantPartial {
+					t.Errorf("partial download kept = %v, want %v", err == nil, tt.wantPartial)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("download() error = %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, object) {
+				t.Errorf("download = %q, want %q", got, object)
+			}
+			if _, err := os.Stat(path + ".part.etag"); !os.IsNotExist(err) {
+				t.Errorf("the ETag of the partial download is kept")
+			}
+		})
+	}
+}
+
+func Test_fileETag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	object := testObject(100)
+	if err := os.WriteFile(path, object, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, partSize := range []int{0, 10, 30, 100} {
+		got, err := fileETag(path, int64(partSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := (&s3StandIn{partSize: partSize}).etagOf(object)
+		if got != want {
+			t.Errorf("fileETag(%d) = %v, want %v", partSize, got, want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+
+// Package download implements the gridscale-download post-processor, which
+// downloads the image built by the gridscale builder to the local machine.
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/hcl/v2/hcldec"
+
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// BuilderId is the unique id of the artifacts of the post-processor.
+const BuilderId = "packer.post-processor.gridscale-download"
+
+const (
+	formatGzip = "gzip"
+	formatRaw  = "raw"
+	formatVMDK = "vmdk"
+)
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+	// The API key of the project the image has been built in. Environment
+	// variable `GRIDSCALE_UUID` can be set instead.
+	APIKey string `mapstructure:"api_key" required:"true"`
+	// The API token of the project the image has been built in. Environment
+	// variable `GRIDSCALE_TOKEN` can be set instead.
+	APIToken string `mapstructure:"api_token" required:"true"`
+	// The server URL to use to access your account. Default:
+	// "https://api.gridscale.io". Environment variable `GRIDSCALE_URL` can be
+	// set instead.
+	APIURL string `mapstructure:"api_url" required:"false"`
+	// The staging bucket the image is exported to. The export is removed
+	// after the download. The export of a failed download is kept, a later
+	// download of the same image to the same output resumes it.
+	S3 gridscale.S3Config `mapstructure:"s3" required:"true"`
+	// The path of the downloaded image, e.g. `output/image.raw`. The
+	// directory is created if needed.
+	Output string `mapstructure:"output" required:"true"`
+	// The format of the downloaded image: `gzip` for the gzipped qcow2 image
+	// as exported by the API, `raw` for a raw image, or `vmdk` for a sparse
+	// VMDK image. Default: `gzip`.
+	Format string `mapstructure:"format" required:"false"`
+	// How long the export of the image may take. Default: `2h`.
+	ExportTimeout time.Duration `mapstructure:"export_timeout" required:"false"`
+
+	ctx interpolate.Context
+}
+
+// sourceClient is the API client of the project the image has been built in.
+type sourceClient interface {
+	gridscale.ExportClient
+	GetTemplate(ctx context.Context, id string) (gsclient.Template, error)
+}
+
+type PostProcessor struct {
+	config Config
+
+	// The client is replaced in tests
+	newSourceClient func(c *Config) sourceClient
+	// waitForObject waits for the export, nil polls the bucket
+	waitForObject func(ctx context.Context, key string) error
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	c := &p.config
+	err := config.Decode(c, &config.DecodeOpts{
+		PluginType:         BuilderId,
+		Interpolate:        true,
+		InterpolateContext: &c.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	if c.APIURL == "" {
+		c.APIURL = os.Getenv("GRIDSCALE_URL")
+	}
+	if c.APIToken == "" {
+		c.APIToken = os.Getenv("GRIDSCALE_TOKEN")
+	}
+	if c.APIKey == "" {
+		c.APIKey = os.Getenv("GRIDSCALE_UUID")
+	}
+	if c.APIKey == "" || c.APIToken == "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("api_key and api_token for auth must be specified"))
+	}
+	if es := c.S3.Prepare("s3"); len(es) > 0 {
+		errs = packersdk.MultiErrorAppend(errs, es...)
+	}
+	if c.Output == "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("output has to be set"))
+	}
+	if c.Format == "" {
+		c.Format = formatGzip
+	}
+	switch c.Format {
+	case formatGzip, formatRaw, formatVMDK:
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("format %q is not supported, use %q, %q or %q", c.Format, formatGzip, formatRaw, formatVMDK))
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	packersdk.LogSecretFilter.Set(c.APIToken, c.S3.SecretKey)
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	c := &p.config
+	if artifact.BuilderId() != gridscale.BuilderId {
+		return nil, false, false, fmt.Errorf(
+			"Unknown artifact type %s, can only download images of the gridscale builder", artifact.BuilderId())
+	}
+	if artifact.Id() == "" {
+		return nil, false, false, errors.New("The artifact has no image, e.g. because of skip_create_template")
+	}
+	newSourceClient := p.newSourceClient
+	if newSourceClient == nil {
+		newSourceClient = func(c *Config) sourceClient {
+			return gridscale.NewClient(c.APIURL, c.APIKey, c.APIToken, "")
+		}
+	}
+	s3Client, err := gridscale.NewS3Client(c.S3)
+	if err != nil {
+		return nil, false, false, err
+	}
+	source := newSourceClient(c)
+	exporter := &gridscale.S3Exporter{
+		Client:        source,
+		Config:        c.S3,
+		Timeout:       c.ExportTimeout,
+		WaitForObject: p.waitForObject,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Output), 0755); err != nil {
+		return nil, false, false, err
+	}
+	key := fmt.Sprintf("%s.gz", artifact.Id())
+	download := c.Output + ".download"
+	// A failed download keeps its export, which is recorded next to the
+	// partial download
+	exportPath := download + ".export"
+	if resumableExport(ctx, s3Client, ui, exporter, download, key) {
+		ui.Say(fmt.Sprintf("Resuming the download of the export %s", exporter.ObjectURL(key)))
+	} else {
+		ui.Say(fmt.Sprintf("Exporting the image %s to %s...", artifact.Id(), exporter.ObjectURL(key)))
+		if err := p.export(ctx, source, exporter, artifact, key); err != nil {
+			return nil, false, false, fmt.Errorf("Error exporting the image: %s", err)
+		}
+		ui.Say(fmt.Sprintf("Exported the image %s", artifact.Id()))
+	}
+	if err := os.WriteFile(exportPath, []byte(key), 0644); err != nil {
+		deleteExport(s3Client, ui, exporter, key)
+		return nil, false, false, err
+	}
+
+	d := &downloader{
+		client:     s3Client,
+		bucket:     c.S3.Bucket,
+		key:        key,
+		retryDelay: time.Second,
+	}
+	ui.Say(fmt.Sprintf("Downloading %s to %s...", exporter.ObjectURL(key), c.Output))
+	if err := d.download(ctx, ui, download); err != nil {
+		return nil, false, false, fmt.Errorf(
+			"Error downloading the image, the export %s is kept to resume the download: %s", exporter.ObjectURL(key), err)
+	}
+	ui.Say("Verified the checksum of the download")
+
+	if err := convert(c.Format, download, c.Output); err != nil {
+		return nil, false, false, fmt.Errorf("Error converting the image to %s: %s", c.Format, err)
+	}
+	if err := os.Remove(download); err != nil && !os.IsNotExist(err) {
+		ui.Error(fmt.Sprintf("Error removing %s. Please remove it manually: %s", download, err))
+	}
+	deleteExport(s3Client, ui, exporter, key)
+	os.Remove(exportPath)
+	checksumPath, sum, err := writeChecksumFile(c.Output)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error writing the checksum of the image: %s", err)
+	}
+	ui.Say(fmt.Sprintf("Downloaded the image to %s (SHA-256: %s)", c.Output, sum))
+	return &Artifact{Path: c.Output, ChecksumPath: checksumPath}, true, false, nil
+}
+
+// deleteExport removes the export from the staging bucket.
+func deleteExport(s3Client *s3.S3, ui packersdk.Ui, exporter *gridscale.S3Exporter, key string) {
+	_, err := s3Client.DeleteObjectWithContext(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(exporter.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error removing the export %s. Please remove it manually: %s", exporter.ObjectURL(key), err))
+	}
+}
+
+// resumableExport reports whether the export key of a failed download can
+// be resumed, i.e. whether it is the object of the partial download of
+// download. The export of a failed download of another image is removed
+// with its partial download.
+func resumableExport(ctx context.Context, s3Client *s3.S3, ui packersdk.Ui, exporter *gridscale.S3Exporter, download, key string) bool {
+	exportPath := download + ".export"
+	previous, err := os.ReadFile(exportPath)
+	if err != nil {
+		return false
+	}
+	if string(previous) != key {
+		ui.Say(fmt.Sprintf("Removing the export %s of a previous download", exporter.ObjectURL(string(previous))))
+		deleteExport(s3Client, ui, exporter, string(previous))
+		os.Remove(download + ".part")
+		os.Remove(download + ".part.etag")
+		os.Remove(exportPath)
+		return false
+	}
+	etag, err := os.ReadFile(download + ".part.etag")
+	if err != nil {
+		return false
+	}
+	head, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(exporter.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	return err == nil && aws.StringValue(head.ETag) == string(etag)
+}
+
+// export exports the template, storage or snapshot of the artifact as the
+// object key.
+func (p *PostProcessor) export(ctx context.Context, source sourceClient, exporter *gridscale.S3Exporter, artifact packersdk.Artifact, key string) error {
+	storageUUID, _ := artifact.State("storage_uuid").(string)
+	switch artifactType, _ := artifact.State("artifact_type").(string); artifactType {
+	case "storage":
+		return exporter.ExportStorage(ctx, storageUUID, artifact.Id(), key)
+	case "snapshot":
+		return exporter.ExportSnapshot(ctx, storageUUID, artifact.Id(), key)
+	}
+	template, err := source.GetTemplate(ctx, artifact.Id())
+	if err != nil {
+		return fmt.Errorf("getting the template %s: %s", artifact.Id(), err)
+	}
+	return exporter.ExportTemplate(ctx, template, key)
+}
@@ -0,0 +1,372 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// sourceClientMock is the source project. It has the template "test", the
+// export puts a gzipped image into the S3 stand-in.
+type sourceClientMock struct {
+	calls    []string
+	exported func(key string)
+}
+
+func (m *sourceClientMock) GetTemplate(ctx context.Context, id string) (gsclient.Template, error) {
+	if id != "test" {
+		return gsclient.Template{}, errors.New("not found")
+	}
+	return gsclient.Template{Properties: gsclient.TemplateProperties{
+		ObjectUUID: "test",
+		Name:       "packer",
+		Capacity:   10,
+	}}, nil
+}
+
+func (m *sourceClientMock) CreateStorage(ctx context.Context, body gsclient.StorageCreateRequest) (gsclient.CreateResponse, error) {
+	m.calls = append(m.calls, "CreateStorage "+body.Template.TemplateUUID)
+	return gsclient.CreateResponse{ObjectUUID: "temporary storage"}, nil
+}
+
+func (m *sourceClientMock) DeleteStorage(ctx context.Context, id string) error {
+	m.calls = append(m.calls, "DeleteStorage "+id)
+	return nil
+}
+
+func (m *sourceClientMock) CreateStorageSnapshot(ctx context.Context, id string, body gsclient.StorageSnapshotCreateRequest) (gsclient.StorageSnapshotCreateResponse, error) {
+	m.calls = append(m.calls, "CreateStorageSnapshot "+id)
+	return gsclient.StorageSnapshotCreateResponse{ObjectUUID: "temporary snapshot"}, nil
+}
+
+func (m *sourceClientMock) DeleteStorageSnapshot(ctx context.Context, storageID, snapshotID string) error {
+	m.calls = append(m.calls, "DeleteStorageSnapshot "+snapshotID)
+	return nil
+}
+
+func (m *sourceClientMock) ExportStorageSnapshotToS3(ctx context.Context, storageID, snapshotID string, body gsclient.StorageSnapshotExportToS3Request) error {
+	m.calls = append(m.calls, "ExportStorageSnapshotToS3 "+storageID+" "+snapshotID)
+	m.exported(body.S3data.Filename)
+	return nil
+}
+
+// testArtifact is a builder artifact, as seen by the post-processors.
+type testArtifact struct {
+	packersdk.MockArtifact
+	state map[string]interface{}
+}
+
+func (a *testArtifact) State(name string) interface{} {
+	return a.state[name]
+}
+
+func TestPostProcessor_Configure(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        map[string]interface{}
+		wantFormat string
+		wantErr    bool
+	}{
+		{
+			name: "success",
+			raw: map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"s3":        map[string]interface{}{"bucket": "staging", "access_key": "access", "secret_key": "secret"},
+				"output":    "output/image.gz",
+			},
+			wantFormat: formatGzip,
+		},
+		{
+			name: "vmdk",
+			raw: map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"s3":        map[string]interface{}{"bucket": "staging", "access_key": "access", "secret_key": "secret"},
+				"output":    "output/image.vmdk",
+				"format":    "vmdk",
+			},
+			wantFormat: formatVMDK,
+		},
+		{
+			name: "unsupported format",
+			raw: map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"s3":        map[string]interface{}{"bucket": "staging", "access_key": "access", "secret_key": "secret"},
+				"output":    "output/image.qcow2",
+				"format":    "qcow2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing output",
+			raw: map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"s3":        map[string]interface{}{"bucket": "staging", "access_key": "access", "secret_key": "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing bucket",
+			raw: map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"output":    "output/image.gz",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostProcessor{}
+			err := p.Configure(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && p.config.Format != tt.wantFormat {
+				t.Errorf("Format = %v, want %v", p.config.Format, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestPostProcessor_PostProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		// state is the state of the artifact
+		state map[string]interface{}
+		// etag replaces the ETag of the export, which breaks the download
+		etag string
+		// setup prepares the bucket and the output directory, e.g. with the
+		// export of a failed download
+		setup func(t *testing.T, s *s3StandIn, output string, image []byte)
+		// keepExport expects the export to be kept for a later download
+		keepExport bool
+		wantCalls  []string
+		// wantResume expects the download to resume at 10 bytes
+		wantResume bool
+		wantErr    bool
+	}{
+		{
+			name: "template",
+			id:   "test",
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot temporary storage",
+				"ExportStorageSnapshotToS3 temporary storage temporary snapshot",
+				"DeleteStorageSnapshot temporary snapshot",
+				"DeleteStorage temporary storage",
+			},
+		},
+		{
+			name:  "snapshot",
+			id:    "snapshot",
+			state: map[string]interface{}{"artifact_type": "snapshot", "storage_uuid": "storage"},
+			wantCalls: []string{
+				"ExportStorageSnapshotToS3 storage snapshot",
+			},
+		},
+		{
+			name:  "storage",
+			id:    "storage",
+			state: map[string]interface{}{"artifact_type": "storage", "storage_uuid": "storage"},
+			wantCalls: []string{
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 storage temporary snapshot",
+				"DeleteStorageSnapshot temporary snapshot",
+			},
+		},
+		{
+			name: "download fail",
+			id:   "test",
+			etag: "broken",
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot temporary storage",
+				"ExportStorageSnapshotToS3 temporary storage temporary snapshot",
+				"DeleteStorageSnapshot temporary snapshot",
+				"DeleteStorage temporary storage",
+			},
+			keepExport: true,
+			wantErr:    true,
+		},
+		{
+			name: "resume",
+			id:   "test",
+			setup: func(t *testing.T, s *s3StandIn, output string, image []byte) {
+				s.objects["/staging/test.gz"] = image
+				writeTestFiles(t, map[string]string{
+					output + ".download.part":      string(image[:10]),
+					output + ".download.part.etag": `"` + s.etagOf(image) + `"`,
+					output + ".download.export":    "test.gz",
+				})
+			},
+			wantResume: true,
+		},
+		{
+			name: "changed export",
+			id:   "test",
+			setup: func(t *testing.T, s *s3StandIn, output string, image []byte) {
+				s.objects["/staging/test.gz"] = []byte("changed")
+				writeTestFiles(t, map[string]string{
+					output + ".download.part":      string(image[:10]),
+					output + ".download.part.etag": `"` + s.etagOf(image) + `"`,
+					output + ".download.export":    "test.gz",
+				})
+			},
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot temporary storage",
+				"ExportStorageSnapshotToS3 temporary storage temporary snapshot",
+				"DeleteStorageSnapshot temporary snapshot",
+				"DeleteStorage temporary storage",
+			},
+			// The new export is the image of the partial download
+			wantResume: true,
+		},
+		{
+			name: "export of another image",
+			id:   "test",
+			setup: func(t *testing.T, s *s3StandIn, output string, image []byte) {
+				s.objects["/staging/previous.gz"] = []byte("previous image")
+				writeTestFiles(t, map[string]string{
+					output + ".download.part":      "previous",
+					output + ".download.part.etag": `"` + s.etagOf([]byte("previous image")) + `"`,
+					output + ".download.export":    "previous.gz",
+				})
+			},
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot temporary storage",
+				"ExportStorageSnapshotToS3 temporary storage temporary snapshot",
+				"DeleteStorageSnapshot temporary snapshot",
+				"DeleteStorage temporary storage",
+			},
+		},
+		{
+			name:    "unknown template",
+			id:      "unknown",
+			wantErr: true,
+		},
+	}
+	var image bytes.Buffer
+	gz := gzip.NewWriter(&image)
+	gz.Write(testQCOW2Image(t))
+	gz.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newS3StandIn(t)
+			output := filepath.Join(t.TempDir(), "output", "image.raw")
+			if tt.setup != nil {
+				tt.setup(t, s, output, image.Bytes())
+			}
+			s.etag = tt.etag
+			source := &sourceClientMock{exported: func(key string) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.objects["/staging/"+key] = image.Bytes()
+			}}
+			p := &PostProcessor{
+				newSourceClient: func(*Config) sourceClient { return source },
+				waitForObject: func(ctx context.Context, key string) error {
+					return nil
+				},
+			}
+			err := p.Configure(map[string]interface{}{
+				"api_key":   "key",
+				"api_token": "token",
+				"s3": map[string]interface{}{
+					"endpoint":   srv.URL,
+					"bucket":     "staging",
+					"access_key": "access",
+					"secret_key": "secret",
+				},
+				"output": output,
+				"format": "raw",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			artifact := &testArtifact{
+				MockArtifact: packersdk.MockArtifact{BuilderIdValue: gridscale.BuilderId, IdValue: tt.id},
+				state:        tt.state,
+			}
+			got, keep, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), artifact)
+			if !reflect.DeepEqual(source.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", source.calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PostProcess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resumed := len(s.ranges) > 0 && strings.HasPrefix(s.ranges[0], "bytes=10-"); resumed != tt.wantResume {
+				t.Errorf("ranges = %v, want resumed %v", s.ranges, tt.wantResume)
+			}
+			_, exportErr := os.Stat(output + ".download.export")
+			if tt.keepExport {
+				if _, ok := s.objects["/staging/"+tt.id+".gz"]; !ok || len(s.objects) != 1 {
+					t.Errorf("objects = %v, want the export kept", s.objects)
+				}
+				if exportErr != nil {
+					t.Errorf("the kept export is not recorded: %v", exportErr)
+				}
+			} else {
+				if len(s.objects) != 0 {
+					t.Errorf("objects = %v, want the export removed", s.objects)
+				}
+				if !os.IsNotExist(exportErr) {
+					t.Errorf("the removed export is recorded")
+				}
+			}
+			if _, err := os.Stat(output + ".download.part"); !os.IsNotExist(err) {
+				t.Errorf("the partial download is kept")
+			}
+			if tt.wantErr {
+				return
+			}
+			if !keep {
+				t.Errorf("PostProcess() keep = false, want true")
+			}
+			want := []string{output, output + ".sha256"}
+			if !reflect.DeepEqual(got.Files(), want) {
+				t.Errorf("Files() = %v, want %v", got.Files(), want)
+			}
+			if content, _ := os.ReadFile(output); string(content) != "raw image" {
+				t.Errorf("image = %q, want %q", content, "raw image")
+			}
+			if _, err := os.Stat(output + ".download"); !os.IsNotExist(err) {
+				t.Errorf("the download is kept")
+			}
+		})
+	}
+
+	p := &PostProcessor{}
+	artifact := &packersdk.MockArtifact{BuilderIdValue: "packer.other", IdValue: "test"}
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), artifact); err == nil {
+		t.Errorf("PostProcess() of another builder error = nil, want an error")
+	}
+}
+
+// writeTestFiles writes the files with their content by path.
+func writeTestFiles(t *testing.T, files map[string]string) {
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
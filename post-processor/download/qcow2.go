@@ -0,0 +1,197 @@
+package download
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// qcow2Magic starts qcow2 images, the format of the exports of the API.
+var qcow2Magic = []byte("QFI\xfb")
+
+const (
+	// qcow2OffsetMask masks the host offset of L1 and standard L2 entries.
+	qcow2OffsetMask = 0x00fffffffffffe00
+	// qcow2Compressed flags an L2 entry of a compressed cluster.
+	qcow2Compressed = 1 << 62
+	// qcow2Zero flags an L2 entry of a cluster reading as zeros.
+	qcow2Zero = 1
+	// qcow2Dirty and qcow2CompressionType are the incompatible features
+	// the conversion supports: a dirty image only has stale reference
+	// counts, and the compression type is checked separately.
+	qcow2Dirty           = 1 << 0
+	qcow2CompressionType = 1 << 3
+)
+
+// qcow2Header is the header of a qcow2 image, see docs/interop/qcow2.txt of
+// QEMU. The fields of version 3 are zero in version 2 images.
+type qcow2Header struct {
+	Magic                 [4]byte
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+	CompressionType       uint8
+}
+
+// isQCOW2 reports whether the file is a qcow2 image.
+func isQCOW2(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(magic, qcow2Magic), nil
+}
+
+// readQCOW2Header reads and checks the header of the qcow2 image. Images
+// with a backing file, encryption, an external data file, extended L2
+// entries or zstd compression are not supported.
+func readQCOW2Header(r io.ReaderAt) (*qcow2Header, error) {
+	var h qcow2Header
+	// Version 2 headers end before the feature fields
+	buf := make([]byte, binary.Size(h))
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n < 72 {
+		return nil, errors.New("the qcow2 header is truncated")
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.Magic[:], qcow2Magic) {
+		return nil, errors.New("the image is not a qcow2 image")
+	}
+	switch h.Version {
+	case 2:
+		h.IncompatibleFeatures, h.CompressionType = 0, 0
+	case 3:
+		if h.HeaderLength <= 104 {
+			h.CompressionType = 0
+		}
+	default:
+		return nil, fmt.Errorf("qcow2 version %d is not supported", h.Version)
+	}
+	if h.ClusterBits < 9 || h.ClusterBits > 21 {
+		return nil, fmt.Errorf("the qcow2 cluster size 2^%d is not supported", h.ClusterBits)
+	}
+	if h.BackingFileOffset != 0 {
+		return nil, errors.New("qcow2 images with a backing file are not supported")
+	}
+	if h.CryptMethod != 0 {
+		return nil, errors.New("encrypted qcow2 images are not supported")
+	}
+	if features := h.IncompatibleFeatures &^ (qcow2Dirty | qcow2CompressionType); features != 0 {
+		return nil, fmt.Errorf("the qcow2 features %#x are not supported", features)
+	}
+	if h.CompressionType != 0 {
+		return nil, errors.New("only zlib compressed qcow2 images are supported")
+	}
+	return &h, nil
+}
+
+// qcow2ToRaw converts the qcow2 image src to the raw image dst. Clusters
+// reading as zeros are not written, so dst is a sparse file.
+func qcow2ToRaw(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	h, err := readQCOW2Header(in)
+	if err != nil {
+		return err
+	}
+	clusterSize := uint64(1) << h.ClusterBits
+	l2Entries := clusterSize / 8
+	// The compressed cluster descriptor splits into the host offset and the
+	// number of additional sectors
+	offsetBits := 62 - (h.ClusterBits - 8)
+	offsetMask := uint64(1)<<offsetBits - 1
+	sectorsMask := uint64(1)<<(h.ClusterBits-8) - 1
+
+	l1 := make([]uint64, h.L1Size)
+	if err := binary.Read(io.NewSectionReader(in, int64(h.L1TableOffset), int64(h.L1Size)*8), binary.BigEndian, l1); err != nil {
+		return fmt.Errorf("reading the qcow2 L1 table: %s", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	l2 := make([]uint64, l2Entries)
+	cluster := make([]byte, clusterSize)
+	for i, l1Entry := range l1 {
+		l2Offset := l1Entry & qcow2OffsetMask
+		if l2Offset == 0 {
+			continue
+		}
+		if err := binary.Read(io.NewSectionReader(in, int64(l2Offset), int64(clusterSize)), binary.BigEndian, l2); err != nil {
+			return fmt.Errorf("reading the qcow2 L2 table at %d: %s", l2Offset, err)
+		}
+		for j, l2Entry := range l2 {
+			guestOffset := (uint64(i)*l2Entries + uint64(j)) * clusterSize
+			if guestOffset >= h.Size {
+				break
+			}
+			switch {
+			case l2Entry&qcow2Compressed != 0:
+				hostOffset := l2Entry & offsetMask
+				size := ((l2Entry>>offsetBits)&sectorsMask+1)*512 - hostOffset&511
+				zr := flate.NewReader(io.NewSectionReader(in, int64(hostOffset), int64(size)))
+				_, err := io.ReadFull(zr, cluster)
+				zr.Close()
+				if err != nil {
+					return fmt.Errorf("decompressing the qcow2 cluster at %d: %s", hostOffset, err)
+				}
+			case l2Entry&qcow2Zero != 0 || l2Entry&qcow2OffsetMask == 0:
+				continue
+			default:
+				hostOffset := l2Entry & qcow2OffsetMask
+				n, err := in.ReadAt(cluster, int64(hostOffset))
+				if err != nil && err != io.EOF {
+					return fmt.Errorf("reading the qcow2 cluster at %d: %s", hostOffset, err)
+				}
+				// The image may end within its last cluster
+				for k := range cluster[n:] {
+					cluster[n+k] = 0
+				}
+			}
+			if _, err := out.WriteAt(cluster, int64(guestOffset)); err != nil {
+				return err
+			}
+		}
+	}
+	// The last cluster may reach beyond the end of the disk
+	if err := out.Truncate(int64(h.Size)); err != nil {
+		return err
+	}
+	return out.Close()
+}
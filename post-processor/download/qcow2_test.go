@@ -0,0 +1,234 @@
+package download
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testQCOW2 describes a qcow2 image written by encodeQCOW2.
+type testQCOW2 struct {
+	version     uint32
+	clusterBits uint32
+	// compressed compresses the clusters of data
+	compressed bool
+	// zeroFlag marks the cluster with the zero flag instead of leaving it
+	// unallocated
+	zeroFlag int
+	header   func(h *qcow2Header)
+}
+
+// encodeQCOW2 returns the raw image data as a qcow2 image: the header, the
+// L1 table and the L2 tables in the first clusters, followed by the data
+// clusters. Clusters of zeros are not allocated.
+func encodeQCOW2(t *testing.T, data []byte, image testQCOW2) []byte {
+	clusterSize := 1 << image.clusterBits
+	l2Entries := clusterSize / 8
+	numClusters := (len(data) + clusterSize - 1) / clusterSize
+	numL2 := (numClusters + l2Entries - 1) / l2Entries
+	if numL2*8 > clusterSize {
+		t.Fatal("the L1 table does not fit into a cluster")
+	}
+	h := qcow2Header{
+		Version:       image.version,
+		ClusterBits:   image.clusterBits,
+		Size:          uint64(len(data)),
+		L1Size:        uint32(numL2),
+		L1TableOffset: uint64(clusterSize),
+	}
+	copy(h.Magic[:], qcow2Magic)
+	if image.version == 3 {
+		h.RefcountOrder = 4
+		h.HeaderLength = 104
+	}
+	if image.header != nil {
+		image.header(&h)
+	}
+	out := new(bytes.Buffer)
+	if err := binary.Write(out, binary.BigEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+
+	l1 := make([]uint64, numL2)
+	l2 := make([]uint64, numL2*l2Entries)
+	for i := range l1 {
+		l1[i] = uint64((2 + i) * clusterSize)
+	}
+	body := make([]byte, (2+numL2)*clusterSize)
+	offsetBits := 62 - (image.clusterBits - 8)
+	for i := 0; i < numClusters; i++ {
+		cluster := make([]byte, clusterSize)
+		copy(cluster, data[i*clusterSize:])
+		if i == image.zeroFlag && image.version == 3 {
+			l2[i] = qcow2Zero
+			continue
+		}
+		if bytes.Equal(cluster, make([]byte, clusterSize)) {
+			continue
+		}
+		offset := uint64(len(body))
+		if !image.compressed {
+			l2[i] = offset
+			body = append(body, cluster...)
+			continue
+		}
+		compressed := new(bytes.Buffer)
+		zw, _ := flate.NewWriter(compressed, flate.BestCompression)
+		zw.Write(cluster)
+		zw.Close()
+		sectors := (offset+uint64(compressed.Len())-1)/512 - offset/512
+		l2[i] = qcow2Compressed | sectors<<offsetBits | offset
+		body = append(body, compressed.Bytes()...)
+	}
+	copy(body, out.Bytes())
+	table := new(bytes.Buffer)
+	binary.Write(table, binary.BigEndian, l1)
+	copy(body[clusterSize:], table.Bytes())
+	table.Reset()
+	binary.Write(table, binary.BigEndian, l2)
+	copy(body[2*clusterSize:], table.Bytes())
+	return body
+}
+
+// testQCOW2Image returns the qcow2 image of "raw image", as exported by the
+// API.
+func testQCOW2Image(t *testing.T) []byte {
+	return encodeQCOW2(t, []byte("raw image"), testQCOW2{version: 3, clusterBits: 16, compressed: true, zeroFlag: -1})
+}
+
+func Test_qcow2ToRaw(t *testing.T) {
+	// Data over two L2 tables of 512 byte clusters, with zeros in between
+	// and a last cluster cut short
+	data := make([]byte, 70*512+100)
+	copy(data, "boot sector")
+	copy(data[3*512+10:], "data")
+	copy(data[64*512:], "second table")
+	copy(data[len(data)-4:], "last")
+	tests := []struct {
+		name    string
+		image   testQCOW2
+		wantErr bool
+	}{
+		{
+			name:  "version 3",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1},
+		},
+		{
+			name:  "version 2",
+			image: testQCOW2{version: 2, clusterBits: 9, zeroFlag: -1},
+		},
+		{
+			name:  "compressed",
+			image: testQCOW2{version: 3, clusterBits: 9, compressed: true, zeroFlag: -1},
+		},
+		{
+			name:  "compressed 64 KiB clusters",
+			image: testQCOW2{version: 3, clusterBits: 16, compressed: true, zeroFlag: -1},
+		},
+		{
+			name:  "zero flag",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: 3},
+		},
+		{
+			name: "dirty",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.IncompatibleFeatures = qcow2Dirty
+			}},
+		},
+		{
+			name: "backing file",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.BackingFileOffset = 200
+				h.BackingFileSize = 4
+			}},
+			wantErr: true,
+		},
+		{
+			name: "encrypted",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.CryptMethod = 2
+			}},
+			wantErr: true,
+		},
+		{
+			name: "extended L2 entries",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.IncompatibleFeatures = 1 << 4
+			}},
+			wantErr: true,
+		},
+		{
+			name: "zstd",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.IncompatibleFeatures = qcow2CompressionType
+				h.HeaderLength = 112
+				h.CompressionType = 1
+			}},
+			wantErr: true,
+		},
+		{
+			name: "unknown version",
+			image: testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1, header: func(h *qcow2Header) {
+				h.Version = 4
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "image.qcow2")
+			if err := os.WriteFile(src, encodeQCOW2(t, data, tt.image), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(dir, "image.raw")
+			err := qcow2ToRaw(src, dst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("qcow2ToRaw() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := os.ReadFile(dst)
+			want := data
+			if tt.image.zeroFlag >= 0 {
+				want = append([]byte(nil), data...)
+				copy(want[tt.image.zeroFlag*512:(tt.image.zeroFlag+1)*512], make([]byte, 512))
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("the raw image differs from the data")
+			}
+		})
+	}
+}
+
+func Test_isQCOW2(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content []byte
+		want    bool
+	}{
+		{name: "qcow2", content: encodeQCOW2(t, []byte("data"), testQCOW2{version: 3, clusterBits: 9, zeroFlag: -1}), want: true},
+		{name: "raw", content: []byte("raw image")},
+		{name: "short", content: []byte("QF")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := isQCOW2(path)
+			if err != nil {
+				t.Fatalf("isQCOW2() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isQCOW2() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package templatecopy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// LocationTemplate is the copy of the template in a location.
+type LocationTemplate struct {
+	// The name of the location
+	Location string
+
+	// The UUID of the template in the location
+	TemplateUUID string
+
+	// The client of the location, for destroying the template
+	client targetClient
+}
+
+type Artifact struct {
+	// The name of the templates
+	TemplateName string
+
+	// The copies of the template, in the order of the locations
+	Templates []LocationTemplate
+}
+
+func (*Artifact) BuilderId() string {
+	return BuilderId
+}
+
+func (*Artifact) Files() []string {
+	return nil
+}
+
+// Id returns the templates as location:uuid pairs, separated by commas.
+func (a *Artifact) Id() string {
+	ids := make([]string, len(a.Templates))
+	for i, t := range a.Templates {
+		ids[i] = fmt.Sprintf("%s:%s", t.Location, t.TemplateUUID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (a *Artifact) String() string {
+	templates := make([]string, len(a.Templates))
+	for i, t := range a.Templates {
+		templates[i] = fmt.Sprintf("%s: %s", t.Location, t.TemplateUUID)
+	}
+	return fmt.Sprintf("The template '%v' was copied: %s", a.TemplateName, strings.Join(templates, ", "))
+}
+
+func (a *Artifact) State(name string) interface{} {
+	return nil
+}
+
+func (a *Artifact) Destroy() error {
+	var errs *packersdk.MultiError
+	for _, t := range a.Templates {
+		log.Printf("Destroying template: %s (%s) in %s", a.TemplateName, t.TemplateUUID, t.Location)
+		if err := t.client.DeleteTemplate(context.Background(), t.TemplateUUID); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("%s: %s", t.Location, err))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package templatecopy
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestArtifact(t *testing.T) {
+	var c calls
+	a := &Artifact{
+		TemplateName: "packer",
+		Templates: []LocationTemplate{
+			{Location: "de/fra", TemplateUUID: "fra", client: &targetClientMock{location: "de/fra", calls: &c}},
+			{Location: "ch/zrh", TemplateUUID: "zrh", client: &targetClientMock{location: "ch/zrh", calls: &c}},
+		},
+	}
+	if got := a.BuilderId(); got != BuilderId {
+		t.Errorf("BuilderId() = %v, want %v", got, BuilderId)
+	}
+	if got, want := a.Id(), "de/fra:fra,ch/zrh:zrh"; got != want {
+		t.Errorf("Id() = %v, want %v", got, want)
+	}
+	if got, want := a.String(), "The template 'packer' was copied: de/fra: fra, ch/zrh: zrh"; got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+	if err := a.Destroy(); err != nil {
+		t.Errorf("Destroy() error = %v", err)
+	}
+	sort.Strings(c.calls)
+	want := []string{"ch/zrh DeleteTemplate zrh", "de/fra DeleteTemplate fra"}
+	if !reflect.DeepEqual(c.calls, want) {
+		t.Errorf("calls = %v, want %v", c.calls, want)
+	}
+}
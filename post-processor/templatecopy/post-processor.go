@@ -0,0 +1,376 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,LocationConfig
+
+// Package templatecopy implements the gridscale-template-copy
+// post-processor, which copies the template of the gridscale builder to
+// other locations.
+package templatecopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/hashicorp/hcl/v2/hcldec"
+
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// BuilderId is the unique id of the artifacts of the post-processor.
+const BuilderId = "packer.post-processor.gridscale-template-copy"
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+	// The API key of the project the template has been built in. Environment
+	// variable `GRIDSCALE_UUID` can be set instead.
+	APIKey string `mapstructure:"api_key" required:"true"`
+	// The API token of the project the template has been built in.
+	// Environment variable `GRIDSCALE_TOKEN` can be set instead.
+	APIToken string `mapstructure:"api_token" required:"true"`
+	// The server URL to use to access your account. Default:
+	// "https://api.gridscale.io". Environment variable `GRIDSCALE_URL` can be
+	// set instead.
+	APIURL string `mapstructure:"api_url" required:"false"`
+	// The object storage the template is transferred through. The exported
+	// image stays in the bucket, the copies are imported from it.
+	S3 gridscale.S3Config `mapstructure:"s3" required:"true"`
+	// The locations to copy the template to. See [Locations](#locations).
+	Locations []LocationConfig `mapstructure:"location" required:"true"`
+	// The number of copies running at the same time. Default: all locations
+	// at once.
+	Parallel int `mapstructure:"parallel" required:"false"`
+	// How long the export of the template may take. Default: `2h`.
+	ExportTimeout time.Duration `mapstructure:"export_timeout" required:"false"`
+
+	ctx interpolate.Context
+}
+
+// LocationConfig is a location to copy the template to. The API places new
+// objects in the location of the project, a location is given by the
+// credentials of a project in it.
+type LocationConfig struct {
+	// The name of the location in the output and the artifact, e.g.
+	// `de/fra`.
+	Name string `mapstructure:"name" required:"true"`
+	// The API key of the project in the location. Default: `api_key`.
+	APIKey string `mapstructure:"api_key" required:"false"`
+	// The API token of the project in the location. Default: `api_token`.
+	APIToken string `mapstructure:"api_token" required:"false"`
+	// The server URL of the location. Default: `api_url`.
+	APIURL string `mapstructure:"api_url" required:"false"`
+}
+
+// sourceClient is the API client of the project the template has been built
+// in.
+type sourceClient interface {
+	gridscale.ExportClient
+	GetTemplate(ctx context.Context, id string) (gsclient.Template, error)
+	CreateMarketplaceApplication(ctx context.Context, body gsclient.MarketplaceApplicationCreateRequest) (gsclient.MarketplaceApplicationCreateResponse, error)
+	DeleteMarketplaceApplication(ctx context.Context, id string) error
+}
+
+// targetClient is the API client of a project the template is copied to.
+type targetClient interface {
+	ImportMarketplaceApplication(ctx context.Context, body gsclient.MarketplaceApplicationImportRequest) (gsclient.MarketplaceApplicationCreateResponse, error)
+	GetTemplateList(ctx context.Context) ([]gsclient.Template, error)
+	UpdateTemplate(ctx context.Context, id string, body gsclient.TemplateUpdateRequest) error
+	DeleteTemplate(ctx context.Context, id string) error
+}
+
+type PostProcessor struct {
+	config Config
+
+	// The clients are replaced in tests
+	newSourceClient func(c *Config) sourceClient
+	newTargetClient func(l *LocationConfig) targetClient
+	// waitForObject waits for the exported template, nil polls the bucket
+	waitForObject func(ctx context.Context, key string) error
+	// pollInterval is the delay between the lookups of an imported template
+	pollInterval time.Duration
+}
+
+const (
+	// defaultPollInterval is the delay between the lookups of an imported
+	// template.
+	defaultPollInterval = 5 * time.Second
+	// importTimeout is how long an imported template may take to show up.
+	importTimeout = 30 * time.Minute
+)
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	c := &p.config
+	err := config.Decode(c, &config.DecodeOpts{
+		PluginType:         BuilderId,
+		Interpolate:        true,
+		InterpolateContext: &c.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	if c.APIURL == "" {
+		c.APIURL = os.Getenv("GRIDSCALE_URL")
+	}
+	if c.APIToken == "" {
+		c.APIToken = os.Getenv("GRIDSCALE_TOKEN")
+	}
+	if c.APIKey == "" {
+		c.APIKey = os.Getenv("GRIDSCALE_UUID")
+	}
+	if c.APIKey == "" || c.APIToken == "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("api_key and api_token for auth must be specified"))
+	}
+	if es := c.S3.Prepare("s3"); len(es) > 0 {
+		errs = packersdk.MultiErrorAppend(errs, es...)
+	}
+	if len(c.Locations) == 0 {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("at least one location has to be set"))
+	}
+	names := make(map[string]bool)
+	for i := range c.Locations {
+		l := &c.Locations[i]
+		if l.Name == "" {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("location %d: name has to be set", i))
+		} else if names[l.Name] {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("location %s is set twice", l.Name))
+		}
+		names[l.Name] = true
+		if l.APIKey == "" {
+			l.APIKey = c.APIKey
+		}
+		if l.APIToken == "" {
+			l.APIToken = c.APIToken
+		}
+		if l.APIURL == "" {
+			l.APIURL = c.APIURL
+		}
+	}
+	if c.Parallel < 0 {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("parallel cannot be negative"))
+	}
+	if c.Parallel == 0 {
+		c.Parallel = len(c.Locations)
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	packersdk.LogSecretFilter.Set(c.APIToken, c.S3.SecretKey)
+	for _, l := range c.Locations {
+		packersdk.LogSecretFilter.Set(l.APIToken)
+	}
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	c := &p.config
+	if artifact.BuilderId() != gridscale.BuilderId {
+		return nil, false, false, fmt.Errorf(
+			"Unknown artifact type %s, can only copy templates of the gridscale builder", artifact.BuilderId())
+	}
+	if artifact.Id() == "" {
+		return nil, false, false, errors.New("The artifact has no template, e.g. because of skip_create_template")
+	}
+	newSourceClient := p.newSourceClient
+	if newSourceClient == nil {
+		newSourceClient = func(c *Config) sourceClient {
+			return gridscale.NewClient(c.APIURL, c.APIKey, c.APIToken, "")
+		}
+	}
+	source := newSourceClient(c)
+	template, err := source.GetTemplate(ctx, artifact.Id())
+	if err != nil {
+		return nil, false, false, fmt.Errorf(
+			"Error getting the template %s, only templates can be copied: %s", artifact.Id(), err)
+	}
+	props := template.Properties
+
+	// Export the template once, every location imports it
+	exporter := &gridscale.S3Exporter{
+		Client:        source,
+		Config:        c.S3,
+		Timeout:       c.ExportTimeout,
+		WaitForObject: p.waitForObject,
+	}
+	key := fmt.Sprintf("%s.gz", props.ObjectUUID)
+	ui.Say(fmt.Sprintf("Exporting the template %s (%s) to %s...", props.Name, props.ObjectUUID, exporter.ObjectStoragePath(key)))
+	if err := exporter.ExportTemplate(ctx, template, key); err != nil {
+		return nil, false, false, fmt.Errorf("Error exporting the template: %s", err)
+	}
+	ui.Say(fmt.Sprintf("Exported the template %s (%s)", props.Name, props.ObjectUUID))
+	// The import creates a template named after the application. The name is
+	// unique, so that the template is found in the locations.
+	appName := applicationName(props)
+	application, err := source.CreateMarketplaceApplication(ctx, gsclient.MarketplaceApplicationCreateRequest{
+		Name:              appName,
+		ObjectStoragePath: exporter.ObjectStoragePath(key),
+		Setup: gsclient.MarketplaceApplicationSetup{
+			Cores:    1,
+			Memory:   1,
+			Capacity: props.Capacity,
+		},
+	})
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error creating the marketplace application of the template: %s", err)
+	}
+
+	copies := p.copyToLocations(ctx, ui, props, appName, application.UniqueHash)
+	// The application is only needed for the imports
+	if err := source.DeleteMarketplaceApplication(ctx, application.ObjectUUID); err != nil {
+		ui.Error(fmt.Sprintf(
+			"Error removing the marketplace application (%s). Please remove it manually: %s", application.ObjectUUID, err))
+	}
+	result := &Artifact{TemplateName: props.Name}
+	var copyErrs *packersdk.MultiError
+	for _, copy := range copies {
+		if copy.TemplateUUID != "" {
+			result.Templates = append(result.Templates, copy.LocationTemplate)
+		}
+		if copy.err != nil {
+			copyErrs = packersdk.MultiErrorAppend(copyErrs, fmt.Errorf("%s: %s", copy.Location, copy.err))
+		}
+	}
+	if copyErrs != nil {
+		// Do not leave incomplete copies behind
+		if err := result.Destroy(); err != nil {
+			ui.Error(fmt.Sprintf("Error removing the copied templates. Please remove them manually: %s", err))
+		}
+		return nil, false, false, fmt.Errorf("Error copying the template: %s", copyErrs)
+	}
+	return result, true, false, nil
+}
+
+// locationCopy is the result of copying the template to a location.
+type locationCopy struct {
+	LocationTemplate
+	err error
+}
+
+// applicationName returns the name of the marketplace application the
+// template is imported from.
+func applicationName(props gsclient.TemplateProperties) string {
+	return fmt.Sprintf("packer-copy-%s", props.ObjectUUID)
+}
+
+// copyToLocations imports the template into the locations, c.Parallel at a
+// time. The copies are in the order of the locations.
+func (p *PostProcessor) copyToLocations(ctx context.Context, ui packersdk.Ui, props gsclient.TemplateProperties, applicationName, uniqueHash string) []locationCopy {
+	c := &p.config
+	newTargetClient := p.newTargetClient
+	if newTargetClient == nil {
+		newTargetClient = func(l *LocationConfig) targetClient {
+			return gridscale.NewClient(l.APIURL, l.APIKey, l.APIToken, "")
+		}
+	}
+	copies := make([]locationCopy, len(c.Locations))
+	sem := make(chan struct{}, c.Parallel)
+	var wg sync.WaitGroup
+	for i := range c.Locations {
+		l := &c.Locations[i]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			client := newTargetClient(l)
+			copies[i] = locationCopy{LocationTemplate: LocationTemplate{Location: l.Name, client: client}}
+			ui.Say(fmt.Sprintf("[%s] Copying the template %s...", l.Name, props.Name))
+			// Templates left over by an earlier copy are not the copy
+			existing, err := importedTemplates(ctx, client, applicationName)
+			if err != nil {
+				copies[i].err = fmt.Errorf("getting the templates: %s", err)
+				ui.Error(fmt.Sprintf("[%s] Error getting the templates: %s", l.Name, err))
+				return
+			}
+			_, err = client.ImportMarketplaceApplication(ctx, gsclient.MarketplaceApplicationImportRequest{
+				UniqueHash: uniqueHash,
+			})
+			if err != nil {
+				copies[i].err = fmt.Errorf("importing the template: %s", err)
+				ui.Error(fmt.Sprintf("[%s] Error importing the template: %s", l.Name, err))
+				return
+			}
+			templateUUID, err := p.waitForTemplate(ctx, client, applicationName, existing)
+			if err != nil {
+				copies[i].err = fmt.Errorf("looking up the imported template: %s", err)
+				ui.Error(fmt.Sprintf("[%s] Error looking up the imported template: %s", l.Name, err))
+				return
+			}
+			copies[i].TemplateUUID = templateUUID
+			labels := props.Labels
+			err = client.UpdateTemplate(ctx, templateUUID, gsclient.TemplateUpdateRequest{
+				Name:   props.Name,
+				Labels: &labels,
+			})
+			if err != nil {
+				copies[i].err = fmt.Errorf("setting the name and labels of the template %s: %s", templateUUID, err)
+				ui.Error(fmt.Sprintf("[%s] Error setting the name and labels of the template %s: %s", l.Name, templateUUID, err))
+				return
+			}
+			ui.Say(fmt.Sprintf("[%s] Copied the template %s (%s)", l.Name, props.Name, templateUUID))
+		}(i)
+	}
+	wg.Wait()
+	return copies
+}
+
+// importedTemplates returns the UUIDs of the templates named name.
+func importedTemplates(ctx context.Context, client targetClient, name string) (map[string]bool, error) {
+	templates, err := client.GetTemplateList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make(map[string]bool)
+	for _, template := range templates {
+		if template.Properties.Name == name {
+			uuids[template.Properties.ObjectUUID] = true
+		}
+	}
+	return uuids, nil
+}
+
+// waitForTemplate returns the UUID of the template created by importing the
+// application named name. The templates in existing were there before the
+// import.
+func (p *PostProcessor) waitForTemplate(ctx context.Context, client targetClient, name string, existing map[string]bool) (string, error) {
+	pollInterval := p.pollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+	timeout := time.After(importTimeout)
+	for {
+		uuids, err := importedTemplates(ctx, client, name)
+		if err != nil {
+			return "", err
+		}
+		for uuid := range uuids {
+			if !existing[uuid] {
+				return uuid, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("the template %s did not show up within %s", name, importTimeout)
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package templatecopy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gridscale/gsclient-go/v3"
+	"github.com/gridscale/packer-plugin-gridscale/builder/gridscale"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// calls records the API calls, which are made from several goroutines.
+type calls struct {
+	sync.Mutex
+	calls []string
+}
+
+func (c *calls) add(call string) {
+	c.Lock()
+	defer c.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+// sourceClientMock is the source project. It has the template "test".
+type sourceClientMock struct {
+	calls *calls
+}
+
+func (m *sourceClientMock) GetTemplate(ctx context.Context, id string) (gsclient.Template, error) {
+	if id != "test" {
+		return gsclient.Template{}, errors.New("not found")
+	}
+	return gsclient.Template{Properties: gsclient.TemplateProperties{
+		ObjectUUID: "test",
+		Name:       "packer",
+		Capacity:   10,
+		Labels:     []string{"packer"},
+	}}, nil
+}
+
+func (m *sourceClientMock) CreateStorage(ctx context.Context, body gsclient.StorageCreateRequest) (gsclient.CreateResponse, error) {
+	m.calls.add("CreateStorage " + body.Template.TemplateUUID)
+	return gsclient.CreateResponse{ObjectUUID: "storage"}, nil
+}
+
+func (m *sourceClientMock) DeleteStorage(ctx context.Context, id string) error {
+	m.calls.add("DeleteStorage " + id)
+	return nil
+}
+
+func (m *sourceClientMock) CreateStorageSnapshot(ctx context.Context, id string, body gsclient.StorageSnapshotCreateRequest) (gsclient.StorageSnapshotCreateResponse, error) {
+	m.calls.add("CreateStorageSnapshot " + id)
+	return gsclient.StorageSnapshotCreateResponse{ObjectUUID: "snapshot"}, nil
+}
+
+func (m *sourceClientMock) DeleteStorageSnapshot(ctx context.Context, storageID, snapshotID string) error {
+	m.calls.add("DeleteStorageSnapshot " + snapshotID)
+	return nil
+}
+
+func (m *sourceClientMock) ExportStorageSnapshotToS3(ctx context.Context, storageID, snapshotID string, body gsclient.StorageSnapshotExportToS3Request) error {
+	m.calls.add("ExportStorageSnapshotToS3 " + body.S3data.Filename)
+	return nil
+}
+
+func (m *sourceClientMock) CreateMarketplaceApplication(ctx context.Context, body gsclient.MarketplaceApplicationCreateRequest) (gsclient.MarketplaceApplicationCreateResponse, error) {
+	m.calls.add("CreateMarketplaceApplication " + body.Name + " " + body.ObjectStoragePath)
+	return gsclient.MarketplaceApplicationCreateResponse{ObjectUUID: "application", UniqueHash: "hash"}, nil
+}
+
+func (m *sourceClientMock) DeleteMarketplaceApplication(ctx context.Context, id string) error {
+	m.calls.add("DeleteMarketplaceApplication " + id)
+	return nil
+}
+
+// targetClientMock is the project in a location. It has a template left over
+// by an earlier copy, the import adds the template "<location> template". The
+// import fails in the location "fail".
+type targetClientMock struct {
+	location string
+	calls    *calls
+	imported bool
+	// lookups is the number of template lookups after the import before the
+	// imported template shows up
+	lookups int
+}
+
+func (m *targetClientMock) ImportMarketplaceApplication(ctx context.Context, body gsclient.MarketplaceApplicationImportRequest) (gsclient.MarketplaceApplicationCreateResponse, error) {
+	m.calls.add(m.location + " ImportMarketplaceApplication " + body.UniqueHash)
+	if m.location == "fail" {
+		return gsclient.MarketplaceApplicationCreateResponse{}, errors.New("error")
+	}
+	m.imported = true
+	return gsclient.MarketplaceApplicationCreateResponse{ObjectUUID: m.location + " application"}, nil
+}
+
+func (m *targetClientMock) GetTemplateList(ctx context.Context) ([]gsclient.Template, error) {
+	templates := []gsclient.Template{
+		{Properties: gsclient.TemplateProperties{ObjectUUID: m.location + " other", Name: "other"}},
+		{Properties: gsclient.TemplateProperties{ObjectUUID: m.location + " old", Name: "packer-copy-test"}},
+	}
+	if m.imported && m.lookups > 0 {
+		m.lookups--
+		return templates, nil
+	}
+	if m.imported {
+		templates = append(templates, gsclient.Template{
+			Properties: gsclient.TemplateProperties{ObjectUUID: m.location + " template", Name: "packer-copy-test"},
+		})
+	}
+	return templates, nil
+}
+
+func (m *targetClientMock) UpdateTemplate(ctx context.Context, id string, body gsclient.TemplateUpdateRequest) error {
+	m.calls.add(m.location + " UpdateTemplate " + id + " " + body.Name)
+	return nil
+}
+
+func (m *targetClientMock) DeleteTemplate(ctx context.Context, id string) error {
+	m.calls.add(m.location + " DeleteTemplate " + id)
+	return nil
+}
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"api_key":   "key",
+		"api_token": "token",
+		"s3": map[string]interface{}{
+			"bucket":     "packer",
+			"access_key": "access",
+			"secret_key": "secret",
+		},
+		"location": []map[string]interface{}{
+			{"name": "de/fra"},
+			{"name": "ch/zrh", "api_key": "zrh key"},
+		},
+	}
+}
+
+func TestPostProcessor_Configure(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(raw map[string]interface{})
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			change: func(raw map[string]interface{}) {},
+		},
+		{
+			name:    "missing credentials",
+			change:  func(raw map[string]interface{}) { delete(raw, "api_token") },
+			wantErr: true,
+		},
+		{
+			name:    "missing bucket",
+			change:  func(raw map[string]interface{}) { delete(raw, "s3") },
+			wantErr: true,
+		},
+		{
+			name:    "no location",
+			change:  func(raw map[string]interface{}) { delete(raw, "location") },
+			wantErr: true,
+		},
+		{
+			name: "location without name",
+			change: func(raw map[string]interface{}) {
+				raw["location"] = []map[string]interface{}{{"api_key": "key"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate location",
+			change: func(raw map[string]interface{}) {
+				raw["location"] = []map[string]interface{}{{"name": "de/fra"}, {"name": "de/fra"}}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "negative parallel",
+			change:  func(raw map[string]interface{}) { raw["parallel"] = -1 },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			tt.change(raw)
+			p := &PostProcessor{}
+			err := p.Configure(raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The locations default to the credentials of the source project
+	p := &PostProcessor{}
+	if err := p.Configure(testConfig()); err != nil {
+		t.Fatal(err)
+	}
+	want := []LocationConfig{
+		{Name: "de/fra", APIKey: "key", APIToken: "token"},
+		{Name: "ch/zrh", APIKey: "zrh key", APIToken: "token"},
+	}
+	if !reflect.DeepEqual(p.config.Locations, want) {
+		t.Errorf("Locations = %+v, want %+v", p.config.Locations, want)
+	}
+	if p.config.Parallel != 2 {
+		t.Errorf("Parallel = %v, want 2", p.config.Parallel)
+	}
+}
+
+func TestPostProcessor_PostProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		locations []map[string]interface{}
+		artifact  packersdk.Artifact
+		wantID    string
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			locations: []map[string]interface{}{{"name": "de/fra"}, {"name": "ch/zrh"}},
+			artifact:  &gridscale.Artifact{TemplateUUID: "test"},
+			wantID:    "de/fra:de/fra template,ch/zrh:ch/zrh template",
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 test.gz",
+				"DeleteStorageSnapshot snapshot",
+				"DeleteStorage storage",
+				"CreateMarketplaceApplication packer-copy-test s3://packer/test.gz",
+				"DeleteMarketplaceApplication application",
+				"ch/zrh ImportMarketplaceApplication hash",
+				"ch/zrh UpdateTemplate ch/zrh template packer",
+				"de/fra ImportMarketplaceApplication hash",
+				"de/fra UpdateTemplate de/fra template packer",
+			},
+		},
+		{
+			name:      "copy fail",
+			locations: []map[string]interface{}{{"name": "de/fra"}, {"name": "fail"}},
+			artifact:  &gridscale.Artifact{TemplateUUID: "test"},
+			wantCalls: []string{
+				"CreateStorage test",
+				"CreateStorageSnapshot storage",
+				"ExportStorageSnapshotToS3 test.gz",
+				"DeleteStorageSnapshot snapshot",
+				"DeleteStorage storage",
+				"CreateMarketplaceApplication packer-copy-test s3://packer/test.gz",
+				"DeleteMarketplaceApplication application",
+				"de/fra ImportMarketplaceApplication hash",
+				"de/fra UpdateTemplate de/fra template packer",
+				"fail ImportMarketplaceApplication hash",
+				"de/fra DeleteTemplate de/fra template",
+			},
+			wantErr: true,
+		},
+		{
+			name:      "unknown template",
+			locations: []map[string]interface{}{{"name": "de/fra"}},
+			artifact:  &gridscale.Artifact{TemplateUUID: "unknown"},
+			wantErr:   true,
+		},
+		{
+			name:      "no template",
+			locations: []map[string]interface{}{{"name": "de/fra"}},
+			artifact:  &gridscale.Artifact{SkippedTemplate: true},
+			wantErr:   true,
+		},
+		{
+			name:      "other builder",
+			locations: []map[string]interface{}{{"name": "de/fra"}},
+			artifact:  &packersdk.MockArtifact{BuilderIdValue: "packer.other", IdValue: "test"},
+			wantErr:   true,
+		},
+	}
+	// The bucket only has to remove previous exports
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			raw["location"] = tt.locations
+			raw["s3"].(map[string]interface{})["endpoint"] = srv.URL
+			var c calls
+			p := &PostProcessor{
+				newSourceClient: func(*Config) sourceClient {
+					return &sourceClientMock{calls: &c}
+				},
+				newTargetClient: func(l *LocationConfig) targetClient {
+					return &targetClientMock{location: l.Name, calls: &c}
+				},
+				waitForObject: func(ctx context.Context, key string) error {
+					return nil
+				},
+			}
+			if err := p.Configure(raw); err != nil {
+				t.Fatal(err)
+			}
+			got, keep, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), tt.artifact)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PostProcess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// The copies run in parallel, their calls are in no fixed order
+			wantCalls := append([]string(nil), tt.wantCalls...)
+			sort.Strings(c.calls)
+			sort.Strings(wantCalls)
+			if !reflect.DeepEqual(c.calls, wantCalls) {
+				t.Errorf("calls = %v, want %v", c.calls, wantCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !keep {
+				t.Errorf("PostProcess() keep = false, want true")
+			}
+			if got.Id() != tt.wantID {
+				t.Errorf("Id() = %v, want %v", got.Id(), tt.wantID)
+			}
+		})
+	}
+}
+
+func TestPostProcessor_waitForTemplate(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		client  *targetClientMock
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "imported",
+			ctx:    context.Background(),
+			client: &targetClientMock{location: "de/fra", imported: true},
+			want:   "de/fra template",
+		},
+		{
+			name:   "imported after some lookups",
+			ctx:    context.Background(),
+			client: &targetClientMock{location: "de/fra", imported: true, lookups: 2},
+			want:   "de/fra template",
+		},
+		{
+			name:    "canceled",
+			ctx:     canceled,
+			client:  &targetClientMock{location: "de/fra"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostProcessor{pollInterval: time.Millisecond}
+			existing := map[string]bool{"de/fra old": true}
+			got, err := p.waitForTemplate(tt.ctx, tt.client, "packer-copy-test", existing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("waitForTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("waitForTemplate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}